@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Request body of Grafana's POST /api/annotations and PATCH /api/annotations/<id>
+type grafanaAnnotation struct {
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	Time         int64    `json:"time,omitempty"`
+	TimeEnd      int64    `json:"timeEnd,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Text         string   `json:"text"`
+}
+
+// Annotation of the trial that is running, 0 if there is none
+var trialAnnotationID int64
+var trialAnnotationStart time.Time
+
+// grafanaRequest sends an annotation to the Grafana API and decodes the response into result
+func grafanaRequest(method, path string, annotation grafanaAnnotation, result interface{}) error {
+	body, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(method, strings.TrimSuffix(grafanaURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if grafanaToken != "" {
+		request.Header.Set("Authorization", "Bearer "+grafanaToken)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s returned %s", method, path, response.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// startTrialAnnotation marks the start of a trial on the Grafana dashboards
+func startTrialAnnotation(option ConfigOption, oldValue, newValue string) {
+	if grafanaURL == "" {
+		return
+	}
+	trialAnnotationStart = time.Now()
+	annotation := grafanaAnnotation{
+		DashboardUID: grafanaDashboard,
+		Time:         trialAnnotationStart.UnixMilli(),
+		Tags:         []string{"ceph-optimize", runID, trialID, option.Name},
+		Text:         fmt.Sprintf("Trial %s: %s -> %s", option.Name, oldValue, newValue),
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := grafanaRequest(http.MethodPost, "/api/annotations", annotation, &created); err != nil {
+		log.WithError(err).Warn("Cannot create Grafana annotation")
+		return
+	}
+	trialAnnotationID = created.ID
+}
+
+// finishTrialAnnotation turns the annotation of the running trial into a
+// region covering the whole trial and adds its outcome
+func finishTrialAnnotation(trial Trial) {
+	if trialAnnotationID == 0 {
+		return
+	}
+	outcome := fmt.Sprintf("score %.2f", trial.Score)
+	if trial.Error != "" {
+		outcome = "failed: " + trial.Error
+	} else if trial.Accepted {
+		outcome += " (new best)"
+	}
+	annotation := grafanaAnnotation{
+		Time:    trialAnnotationStart.UnixMilli(),
+		TimeEnd: trial.Timestamp.UnixMilli(),
+		Tags:    []string{"ceph-optimize", runID, trial.ID, trial.Option},
+		Text:    fmt.Sprintf("Trial %s: %s -> %s, %s", trial.Option, trial.OldValue, trial.NewValue, outcome),
+	}
+	if err := grafanaRequest(http.MethodPatch, fmt.Sprintf("/api/annotations/%d", trialAnnotationID), annotation, nil); err != nil {
+		log.WithError(err).Warn("Cannot update Grafana annotation")
+	}
+	trialAnnotationID = 0
+}
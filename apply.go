@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Entry of 'ceph config dump -f json'
+type configDumpEntry struct {
+	Section string `json:"section"`
+	Mask    string `json:"mask"`
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+}
+
+// who returns the who-mask of the entry as used by 'ceph config set'
+func (entry configDumpEntry) who() string {
+	if entry.Mask == "" {
+		return entry.Section
+	}
+	return entry.Section + "/" + entry.Mask
+}
+
+// Value of every option before the optimizer changed it for the first time
+var firstValues = map[string]string{}
+
+// getCurrentValueForOption returns the value the optimizer applied last or
+// the value from the config database. Injected values are not visible in the
+// config database, so the applied value takes precedence.
+func getCurrentValueForOption(option ConfigOption) (value string) {
+	if value, known := currentValues[option.key()]; known {
+		return value
+	}
+	if option.isGroup() {
+		return option.groupValue()
+	}
+	if option.daemonType() == "pool" {
+		return getPoolProperty(option.Name)
+	}
+	if option.daemonType() == "crush" {
+		return getCrushTunable(option.Name)
+	}
+	if option.daemonType() == "image" {
+		return getImageProperty(option.Name)
+	}
+	if option.daemonType() == "host" {
+		return getHostSetting(option.Name)
+	}
+	if option.daemonType() == "numa" {
+		return getNumaAffinity()
+	}
+	if option.daemonType() == "frontend" {
+		return getFrontendParam(option.Name)
+	}
+	output, err := runCeph([]string{"config", "get", option.readTarget(), option.Name})
+	if err != nil {
+		log.WithError(err).Errorf("Cannot execute ceph command to get current value for %s", option.Name)
+		return ""
+	}
+	return strings.TrimSpace(output)
+}
+
+// setValue applies value to all daemons the option targets. Options that
+// need a restart are always written to the config database, because
+// injected values do not survive it. The returned error fails the trial:
+// it wraps errRejected if the value crosses its hard bounds or Ceph refused
+// it and errUnhealthy if the daemons did not come back after the restart.
+func setValue(option *ConfigOption, value string) (err error) {
+	changeLock.Lock()
+	defer changeLock.Unlock()
+	span := startSpan("apply", "option", option.Name, "value", value)
+	defer func() { span.end(err) }()
+	if err := option.checkHardBounds(value); err != nil {
+		log.WithError(err).WithField("option", option.Name).Error("Refusing to apply value")
+		return fmt.Errorf("%w: %s=%s: %v", errHardBound, option.Name, value, err)
+	}
+	pushUndo(*option, value)
+	if err := applyValue(option, value); err != nil {
+		return err
+	}
+	if err := restartIfRequired(option); err != nil {
+		return fmt.Errorf("%w: daemon restart failed: %v", errUnhealthy, err)
+	}
+	return nil
+}
+
+// applyValue changes the option without restarting daemons. All options of
+// a group are applied before the group's daemons are restarted once. The
+// first failure is returned, wrapping errRejected if Ceph refused the value.
+func applyValue(option *ConfigOption, value string) error {
+	currentValues[option.key()] = value
+	if option.isGroup() {
+		members, values := option.memberValues(value)
+		for i := range members {
+			if err := applyValue(&members[i], values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if option.appliedLocally() {
+		clientOverrides[option.Name] = value
+		return nil
+	}
+	switch option.daemonType() {
+	case "pool":
+		return setPoolProperty(option.Name, value)
+	case "crush":
+		return setCrushTunable(option.Name, value)
+	case "image":
+		return setImageProperty(option.Name, value)
+	case "host":
+		return setHostSetting(option.Name, value)
+	case "numa":
+		return setNumaAffinity(value)
+	case "frontend":
+		return setFrontendParam(option.Name, value)
+	}
+	if applyMethod == "admin-socket" && option.daemonType() == "osd" && !option.Restart {
+		return setValueViaAdminSocket(option, value)
+	}
+	return applyConfigValue(option, value)
+}
+
+// Entry of 'ceph osd metadata -f json'
+type osdMetadata struct {
+	ID         int    `json:"id"`
+	Hostname   string `json:"hostname"`
+	Devices    string `json:"devices"`      // comma separated kernel names of the devices backing the OSD
+	MemTotalKB string `json:"mem_total_kb"` // memory of the host
+}
+
+var osdHosts map[int]string
+var osdDevices map[int][]string
+var hostMemory map[string]float64
+
+// getOSDHosts returns the host of every OSD
+func getOSDHosts() (map[int]string, error) {
+	if osdHosts != nil {
+		return osdHosts, nil
+	}
+	var metadata []osdMetadata
+	if err := cephJSON(&metadata, []string{"osd", "metadata"}); err != nil {
+		return nil, fmt.Errorf("cannot get OSD metadata: %w", err)
+	}
+	osdHosts = map[int]string{}
+	osdDevices = map[int][]string{}
+	hostMemory = map[string]float64{}
+	for _, osd := range metadata {
+		if memory, err := strconv.ParseFloat(osd.MemTotalKB, 64); err == nil {
+			hostMemory[osd.Hostname] = memory * 1024
+		}
+		osdHosts[osd.ID] = osd.Hostname
+		if osd.Devices != "" {
+			osdDevices[osd.ID] = strings.Split(osd.Devices, ",")
+		}
+	}
+	return osdHosts, nil
+}
+
+// runtimeOSDHosts returns the host of every OSD, or aborts the run if the
+// metadata cannot be read. During the shutdown no hosts are returned so the
+// rest of the cleanup runs.
+func runtimeOSDHosts() map[int]string {
+	hosts, err := getOSDHosts()
+	if err == nil {
+		return hosts
+	}
+	if cleaningUp() {
+		log.WithError(err).Error("Cannot get the hosts of the OSDs")
+		return map[int]string{}
+	}
+	abortRun(err)
+	return nil
+}
+
+// setValueViaAdminSocket sets value through the admin socket of every OSD in
+// scope, running 'ceph daemon' locally or via SSH on the OSD's host
+func setValueViaAdminSocket(option *ConfigOption, value string) error {
+	ids := option.osdIDs()
+	hosts := runtimeOSDHosts()
+	if ids == nil {
+		for id := range hosts {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range ids {
+		daemon := fmt.Sprintf("osd.%d", id)
+		command := []string{cephBinary, "daemon", daemon, "config", "set", option.Name, value}
+		if execMode == "cephadm" {
+			// The admin socket is only reachable from a shell of the daemon's container
+			command = append([]string{"cephadm", "shell", "--name", daemon, "--"}, command...)
+		}
+		if _, err := runOnHost(hosts[id], command); err != nil {
+			return applyError(option.Name, value, daemon+" admin socket", err)
+		}
+	}
+	return nil
+}
+
+// rollbackValue restores value after an unsuccessful trial. With config-set,
+// rolling back to the value from before the run removes the option from the
+// mon config database again unless it was overridden there already.
+// The run is aborted if the old value cannot be applied or the daemons do
+// not come back, because every later trial would measure a changed or
+// broken cluster.
+func rollbackValue(option *ConfigOption, value string) {
+	span := startSpan("rollback", "option", option.Name, "value", value)
+	err := revertValue(option, value)
+	if err == nil {
+		if err = restartIfRequired(option); err != nil {
+			err = fmt.Errorf("daemon restart failed: %w", err)
+		}
+	}
+	span.end(err)
+	if err == nil {
+		return
+	}
+	err = fmt.Errorf("cannot roll back %s to %s: %w", option.Name, value, err)
+	if cleaningUp() {
+		// Already shutting down - restore as much of the config as possible
+		log.WithError(err).Error("Cannot roll back value")
+		return
+	}
+	abortRun(err)
+}
+
+// revertValue sets the option back to value without restarting daemons.
+// Reverting to the value from before the run restores the config database
+// as it was instead of pinning the old value.
+func revertValue(option *ConfigOption, value string) error {
+	if option.isGroup() {
+		currentValues[option.key()] = value
+		members, values := option.memberValues(value)
+		for i := range members {
+			if err := revertValue(&members[i], values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if option.appliedLocally() {
+		clientOverrides[option.Name] = value
+		return nil
+	}
+	if option.isConfigOption() && storedInDatabase[option.key()] && firstValues[option.key()] == value {
+		if _, err := getInitialConfigDump(); err == nil {
+			restoreOverrides(option)
+			currentValues[option.key()] = value
+			return nil
+		}
+	}
+	return applyValue(option, value)
+}
+
+// rememberFirstValue records the state of an option before config-set touches it for the first time
+func rememberFirstValue(option *ConfigOption) {
+	if _, known := firstValues[option.key()]; known {
+		return
+	}
+	firstValues[option.key()] = getCurrentValueForOption(*option)
+	if _, err := getInitialConfigDump(); err != nil {
+		log.WithError(err).Warn("Cannot read the config database - rollback will keep explicit values")
+	}
+}
+
+func setValueToStart(option *ConfigOption) error {
+	if option.StartValue == "" {
+		return nil
+	}
+	return setValue(option, option.cephValue(option.StartValue))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const confBlockStart = "# BEGIN ceph-optimize"
+const confBlockEnd = "# END ceph-optimize"
+
+// runApplyCommand implements the apply and unapply subcommands, which
+// permanently apply the best config of a results file or remove it again
+func runApplyCommand(command string, args []string) {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	method := flags.String("method", "config-set", "How to persist the config - one of config-set,conf-file")
+	confFile := flags.String("conf-file", "/etc/ceph/ceph.conf", "ceph.conf to edit with the conf-file method")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s results.json [flags]\n", os.Args[0], command)
+		flags.PrintDefaults()
+	}
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	results, err := readResults(positional[0])
+	if err != nil {
+		log.WithError(err).WithField("file", positional[0]).Fatal("Cannot read results file")
+	}
+
+	switch *method {
+	case "config-set":
+		for _, value := range results.BestConfig {
+			switch value.Target {
+			case "pool":
+				log.WithFields(log.Fields{"property": value.Name, "value": value.Value}).Warn("Pool properties were tuned on the benchmark pool - set them on your pools with 'ceph osd pool set'")
+				continue
+			case "image":
+				log.WithFields(log.Fields{"property": value.Name, "value": value.Value}).Warn("RBD image properties only apply to new images - pass them to 'rbd create'")
+				continue
+			case "host":
+				log.WithFields(log.Fields{"setting": value.Name, "value": value.Value}).Warn("Host settings are not applied automatically - persist them with sysctl.d or udev rules")
+				continue
+			case "numa":
+				log.WithField("strategy", value.Value).Warn("NUMA affinity is not applied automatically - set osd_numa_node per OSD")
+				continue
+			case "frontend":
+				log.WithFields(log.Fields{"param": value.Name, "value": value.Value}).Warn("Beast frontend parameters are not applied automatically - add them to rgw_frontends")
+				continue
+			case "crush":
+				log.WithFields(log.Fields{"tunable": value.Name, "value": value.Value}).Warn("CRUSH tunables are not applied automatically - change them with crushtool")
+				continue
+			}
+			for _, section := range value.Sections {
+				var err error
+				if command == "apply" {
+					_, err = runCeph([]string{"config", "set", section, value.Name, value.Value})
+				} else {
+					_, err = runCeph([]string{"config", "rm", section, value.Name})
+				}
+				if err != nil {
+					log.WithError(err).WithFields(log.Fields{"option": value.Name, "section": section}).Error("Cannot update the config database")
+				}
+			}
+		}
+	case "conf-file":
+		if err := updateConfFile(*confFile, results.BestConfig, command == "apply"); err != nil {
+			log.WithError(err).WithField("file", *confFile).Fatal("Cannot update ceph.conf")
+		}
+		log.Info("Daemons pick up changes in ceph.conf only after a restart")
+	default:
+		log.WithField("method", *method).Fatal("Unknown method")
+	}
+	log.WithFields(log.Fields{"options": len(results.BestConfig), "method": *method}).Infof("Finished %s", command)
+}
+
+// parseInterspersed parses flags that may come after positional arguments
+// and returns the positional arguments
+func parseInterspersed(flags *flag.FlagSet, args []string) (positional []string) {
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// updateConfFile removes a previously applied block from confFile and, if
+// add is set, appends a new block with the given values
+func updateConfFile(confFile string, values []ResultValue, add bool) error {
+	content, err := os.ReadFile(confFile)
+	if err != nil {
+		return err
+	}
+	conf := string(content)
+	if start := strings.Index(conf, confBlockStart); start >= 0 {
+		end := strings.Index(conf, confBlockEnd)
+		if end < start {
+			return fmt.Errorf("found %q without %q", confBlockStart, confBlockEnd)
+		}
+		conf = strings.TrimRight(conf[:start], "\n") + "\n" + strings.TrimPrefix(conf[end+len(confBlockEnd):], "\n")
+	}
+	if add {
+		conf = strings.TrimRight(conf, "\n") + "\n\n" + confBlockStart + "\n" + confSections(values) + confBlockEnd + "\n"
+	}
+	return os.WriteFile(confFile, []byte(conf), 0644)
+}
+
+// confSections renders values as ceph.conf sections. Masked sections like
+// osd/class:ssd cannot be expressed in ceph.conf and are skipped.
+func confSections(values []ResultValue) string {
+	var order []string
+	sections := map[string][]string{}
+	for _, value := range values {
+		for _, section := range value.Sections {
+			if strings.Contains(section, "/") {
+				log.WithFields(log.Fields{"option": value.Name, "section": section}).Warn("Masked sections cannot be written to ceph.conf - skipping")
+				continue
+			}
+			if _, known := sections[section]; !known {
+				order = append(order, section)
+			}
+			sections[section] = append(sections[section], fmt.Sprintf("%s = %s", value.Name, value.Value))
+		}
+	}
+	out := ""
+	for _, section := range order {
+		out += fmt.Sprintf("[%s]\n%s\n", section, strings.Join(sections[section], "\n"))
+	}
+	return out
+}
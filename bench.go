@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Subset of the JSON report written by 'fio --output-format=json'
+type fioReport struct {
+	Jobs []struct {
+		Read  fioJobStats `json:"read"`
+		Write fioJobStats `json:"write"`
+	} `json:"jobs"`
+}
+
+type fioJobStats struct {
+	IOPS float64 `json:"iops"`
+	Lat  struct {
+		Mean   float64 `json:"mean"`
+		Max    float64 `json:"max"`
+		Stddev float64 `json:"stddev"`
+	} `json:"lat_ns"`
+	Clat struct {
+		Percentile map[string]float64 `json:"percentile"`
+	} `json:"clat_ns"`
+}
+
+var krbdDevice string
+var fioLogPrefix = filepath.Join(os.TempDir(), "ceph-optimize-fio")
+var benchRun int
+
+// BenchResult holds the metrics of a single benchmark run
+type BenchResult struct {
+	IOPS          float64
+	AvgLatency    float64   // seconds
+	MaxLatency    float64   // seconds
+	StddevLatency float64   // seconds
+	P99Latency    float64   // seconds, 0 if the backend does not report percentiles
+	Timeline      []float64 // IOPS of every second of the benchmark
+}
+
+// errTrialFailed marks errors after which the trial counts as failed
+// but the search can continue with the next trial
+var errTrialFailed = errors.New("trial failed")
+
+// errUnhealthy fails a trial that broke the cluster
+var errUnhealthy = fmt.Errorf("%w: cluster became unhealthy", errTrialFailed)
+
+// stability returns the coefficient of variation of the per-second IOPS -
+// lower values mean smoother performance
+func (result BenchResult) stability() float64 {
+	if len(result.Timeline) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, iops := range result.Timeline {
+		sum += iops
+	}
+	mean := sum / float64(len(result.Timeline))
+	if mean == 0 {
+		return 0
+	}
+	var squares float64
+	for _, iops := range result.Timeline {
+		squares += math.Pow(iops-mean, 2)
+	}
+	return math.Sqrt(squares/float64(len(result.Timeline))) / mean
+}
+
+// tailLatency returns the latency that --max-latency-ms is compared against
+func (result BenchResult) tailLatency() float64 {
+	if latencyConstraint == "p99" && result.P99Latency > 0 {
+		return result.P99Latency
+	}
+	return result.MaxLatency
+}
+
+// checkLatencyConstraint fails the trial if the tail latency exceeds --max-latency-ms
+func checkLatencyConstraint(result BenchResult) error {
+	if maxLatencyMs <= 0 {
+		return nil
+	}
+	if latencyMs := result.tailLatency() * 1000; latencyMs > maxLatencyMs {
+		return fmt.Errorf("%w: %s latency %.2fms exceeds the limit of %.2fms", errTrialFailed, latencyConstraint, latencyMs, maxLatencyMs)
+	}
+	return nil
+}
+
+// getScore runs the benchmark(s) needed by the selected objective and
+// returns a score where higher is better. With --slow-ops-penalty the
+// penalty is subtracted for every health poll that reported slow requests.
+// The trial is aborted as soon as the cluster reports HEALTH_ERR or down OSDs.
+func getScore() (number float64, err error) {
+	stop := make(chan struct{})
+	var slowOps <-chan int
+	if slowOpsPenalty > 0 {
+		slowOps = watchSlowOps(stop)
+	}
+	critical := watchCriticalHealth(stop)
+	// Stop both health watchers exactly once, whichever way the benchmark ends
+	defer func() {
+		close(stop)
+		if slowOps == nil || errors.Is(err, errUnhealthy) {
+			return
+		}
+		if occurrences := <-slowOps; occurrences > 0 {
+			log.WithFields(log.Fields{"occurrences": occurrences, "penalty": float64(occurrences) * slowOpsPenalty}).Warn("Slow ops during benchmark - applying penalty")
+			number -= float64(occurrences) * slowOpsPenalty
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	benchmarkContext = ctx
+
+	done := make(chan scoreOutcome, 1)
+	go func() {
+		number, err := getObjectiveScore()
+		done <- scoreOutcome{number, err}
+	}()
+	select {
+	case outcome := <-done:
+		number, err = outcome.number, outcome.err
+	case problem := <-critical:
+		// Kill the benchmark instead of waiting for a meaningless score.
+		// Its goroutine still collects the killed command and cleans up,
+		// so it is waited for before the next trial starts.
+		cancel()
+		abandonedBenchmark = done
+		return 0, fmt.Errorf("%w: %s", errUnhealthy, problem)
+	}
+	return number, err
+}
+
+type scoreOutcome struct {
+	number float64
+	err    error
+}
+
+// Results of a benchmark that was killed because the cluster became unhealthy
+var abandonedBenchmark <-chan scoreOutcome
+
+// benchmarkContext is cancelled when the health watchdog aborts the running benchmark
+var benchmarkContext = context.Background()
+
+// benchmarkDeadline limits a benchmark command to --bench-time plus --bench-timeout seconds
+func benchmarkDeadline() (context.Context, context.CancelFunc) {
+	if benchTimeout <= 0 {
+		return withTimeout(benchmarkContext, 0)
+	}
+	return withTimeout(benchmarkContext, benchTime+benchTimeout)
+}
+
+func getObjectiveScore() (number float64, err error) {
+	if objective == "recovery-latency" {
+		return getRecoveryLatencyScore()
+	}
+	result, err := runBenchmark()
+	if err != nil {
+		return 0, err
+	}
+	measureObjectives(result)
+	log.WithFields(log.Fields{"avgLatency": result.AvgLatency, "maxLatency": result.MaxLatency, "stddevLatency": result.StddevLatency, "p99Latency": result.P99Latency}).Debug("Benchmark latencies")
+	cv := result.stability()
+	log.WithFields(log.Fields{"timeline": result.Timeline, "cv": cv}).Debug("Benchmark IOPS timeline")
+	// Penalize spiky performance by the share of the stability weight
+	score := result.IOPS * (1 - stabilityWeight*cv)
+	return score, checkLatencyConstraint(result)
+}
+
+// runBenchmark runs the benchmark of the selected backend. IOPS are
+// operations per second for the rgw backend.
+func runBenchmark() (result BenchResult, err error) {
+	span := startSpan("benchmark", "backend", benchBackend)
+	defer func() { span.end(err) }()
+	benchRun++
+	if waitHealthy > 0 {
+		if err := waitForHealthy(time.Duration(waitHealthy) * time.Second); err != nil {
+			log.WithError(err).Warn("Cluster did not settle - benchmarking anyway")
+		}
+	}
+	if !keepBenchObjects {
+		defer cleanupBenchmark()
+	}
+	benchmarkStartedMetrics()
+	switch benchBackend {
+	case "rbd":
+		return runFioBench()
+	case "rgw":
+		return runRGWBench()
+	default:
+		return runRadosBench()
+	}
+}
+
+// cleanupBenchmark removes the objects written by the last benchmark so
+// the pool does not keep growing across trials
+func cleanupBenchmark() {
+	switch benchBackend {
+	case "rados":
+		if _, err := runRados([]string{"-p", poolName, "cleanup", "--run-name", benchRunName()}); err != nil {
+			log.WithError(err).Warn("Cannot remove the objects of the last benchmark")
+		}
+	case "rgw":
+		client := newS3Client()
+		for _, prefix := range []string{"put-", "multipart-", "meta-"} {
+			emptyS3Bucket(client, prefix)
+		}
+	}
+}
+
+// benchRunName is the rados bench run name of the current benchmark
+func benchRunName() string {
+	return fmt.Sprintf("ceph-optimize-%d", benchRun)
+}
+
+func radosBenchArgs() []string {
+	return append([]string{"bench", "-p", poolName, fmt.Sprint(benchTime), "write", "-t", fmt.Sprint(benchScale), "-b", fmt.Sprint(benchBlockSize * 1024), "-O", fmt.Sprint(benchObjectSize * 1024), "--run-name", benchRunName(), "--no-cleanup"}, clientArgs()...)
+}
+
+func runRadosBench() (result BenchResult, err error) {
+	ctx, cancel := benchmarkDeadline()
+	defer cancel()
+	output, err := runRadosContext(ctx, radosBenchArgs())
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", errTrialFailed, err)
+	}
+
+	iops, found := radosBenchValue(output, "Average IOPS")
+	if !found {
+		return result, fmt.Errorf("could not find score in output")
+	}
+	result.IOPS = iops
+	result.Timeline = radosBenchTimeline(output)
+	result.AvgLatency, _ = radosBenchValue(output, "Average Latency(s)")
+	result.MaxLatency, _ = radosBenchValue(output, "Max latency(s)")
+	result.StddevLatency, _ = radosBenchValue(output, "Stddev Latency(s)")
+	return result, nil
+}
+
+// radosBenchValue extracts the number from the summary line of the
+// rados bench output that contains searchString
+func radosBenchValue(output, searchString string) (number float64, found bool) {
+	// Regex to match integers and float values
+	pattern := `[-+]?[0-9]*\.?[0-9]+`
+	re := regexp.MustCompile(pattern)
+
+	// Create a scanner to read the output line by line
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	// Iterate through each line of the output
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Check if the line contains the desired string
+		if strings.Contains(line, searchString) {
+			match := re.FindString(strings.TrimPrefix(line, searchString))
+			number, err := strconv.ParseFloat(match, 64)
+			if err != nil {
+				log.WithError(err).WithField("line", line).Error("Error extracting value from rados bench output")
+			}
+			return number, true
+		}
+	}
+
+	// Check for any scanner errors
+	if err := scanner.Err(); err != nil {
+		log.WithError(err).Error("Cannot read rados bench output")
+	}
+	return 0, false
+}
+
+// radosBenchTimeline calculates the IOPS of every second from the
+// finished column of the per-second rados bench progress lines
+func radosBenchTimeline(output string) (timeline []float64) {
+	lastFinished := 0.0
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		// sec Cur-ops started finished avg-MB/s cur-MB/s last-lat(s) avg-lat(s)
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 8 {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[0]); err != nil {
+			continue
+		}
+		finished, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			continue
+		}
+		timeline = append(timeline, finished-lastFinished)
+		lastFinished = finished
+	}
+	// The first line reports second 0 before any IO was done
+	if len(timeline) > 0 {
+		timeline = timeline[1:]
+	}
+	return timeline
+}
+
+// fioTimeline reads the per-second IOPS from the IOPS logs fio wrote with logPrefix
+func fioTimeline(logPrefix string) (timeline []float64) {
+	files, _ := filepath.Glob(logPrefix + "_iops.*.log")
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			log.WithError(err).Warn("Cannot read fio IOPS log")
+			continue
+		}
+		second := 0
+		scanner := bufio.NewScanner(strings.NewReader(string(content)))
+		for scanner.Scan() {
+			// msec, value, direction, block size, offset
+			fields := strings.Split(scanner.Text(), ",")
+			if len(fields) < 2 {
+				continue
+			}
+			iops, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+			if err != nil {
+				continue
+			}
+			if second >= len(timeline) {
+				timeline = append(timeline, 0)
+			}
+			timeline[second] += iops
+			second++
+		}
+		os.Remove(file)
+	}
+	return timeline
+}
+
+// fioBenchArgs returns the fio arguments that benchmark the RBD test image,
+// either through librbd (fio's rbd ioengine) or through the kernel mapped
+// block device
+func fioBenchArgs() []string {
+	arguments := []string{
+		"--name=ceph-optimize",
+		"--rw=" + fioReadWrite(),
+		fmt.Sprintf("--bs=%d", benchBlockSize*1024),
+		fmt.Sprintf("--iodepth=%d", benchScale),
+		fmt.Sprintf("--runtime=%d", benchTime),
+		"--time_based",
+		"--direct=1",
+		"--output-format=json",
+		"--write_iops_log=" + fioLogPrefix,
+		"--log_avg_msec=1000",
+	}
+	if rbdClient == "krbd" {
+		arguments = append(arguments, "--ioengine=libaio", "--filename="+krbdDevice)
+	} else {
+		arguments = append(arguments, "--ioengine=rbd", "--clientname="+fioClientName(), "--pool="+poolName, "--rbdname="+rbdImage)
+		if cephCluster != "" {
+			arguments = append(arguments, "--clustername="+cephCluster)
+		}
+	}
+	return arguments
+}
+
+func runFioBench() (result BenchResult, err error) {
+	ctx, cancel := benchmarkDeadline()
+	defer cancel()
+	output, err := executeCommandContext(ctx, fioBinary, fioBenchArgs(), cephArgsEnv())
+	if errors.Is(err, errTrialFailed) {
+		return result, err
+	}
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", errTrialFailed, err)
+	}
+
+	var report fioReport
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		return result, fmt.Errorf("could not parse fio output: %w", err)
+	}
+	if len(report.Jobs) == 0 {
+		return result, fmt.Errorf("could not find score in output")
+	}
+	job := report.Jobs[0]
+	result.IOPS = job.Read.IOPS + job.Write.IOPS
+	if result.IOPS > 0 {
+		// Weight the latency of both directions by their share of the IOs
+		result.AvgLatency = (job.Read.Lat.Mean*job.Read.IOPS + job.Write.Lat.Mean*job.Write.IOPS) / result.IOPS / 1e9
+		result.StddevLatency = (job.Read.Lat.Stddev*job.Read.IOPS + job.Write.Lat.Stddev*job.Write.IOPS) / result.IOPS / 1e9
+	}
+	result.MaxLatency = math.Max(job.Read.Lat.Max, job.Write.Lat.Max) / 1e9
+	result.P99Latency = math.Max(job.Read.Clat.Percentile["99.000000"], job.Write.Clat.Percentile["99.000000"]) / 1e9
+	result.Timeline = fioTimeline(fioLogPrefix)
+	return result, nil
+}
+
+// fioClientName returns the client id for fio's rbd engine, which expects
+// the name without "client." prefix
+func fioClientName() string {
+	if cephName == "" {
+		return "admin"
+	}
+	return strings.TrimPrefix(cephName, "client.")
+}
+
+// fioReadWrite maps the benchmark type to the matching fio workload
+func fioReadWrite() string {
+	switch benchType {
+	case "seq":
+		return "read"
+	case "rand":
+		return "randread"
+	default:
+		return "write"
+	}
+}
+
+func setUpRBDImage() error {
+	if _, err := runRBD(append([]string{"create", poolName + "/" + rbdImage, "--size", fmt.Sprint(rbdImageSize)}, imageCreateArgs()...)); err != nil {
+		return fmt.Errorf("cannot create the RBD test image: %w", err)
+	}
+	if rbdClient != "krbd" {
+		return nil
+	}
+	output, err := runRBD([]string{"map", poolName + "/" + rbdImage})
+	if err != nil {
+		return fmt.Errorf("cannot map the RBD test image: %w", err)
+	}
+	krbdDevice = strings.TrimSpace(output)
+	log.WithField("device", krbdDevice).Debug("Mapped RBD test image")
+	return nil
+}
+
+func removeRBDImage() {
+	if krbdDevice != "" {
+		if _, err := runRBD([]string{"unmap", krbdDevice}); err != nil {
+			log.WithError(err).WithField("device", krbdDevice).Error("Cannot unmap the RBD test image")
+		}
+		krbdDevice = ""
+	}
+	if _, err := runRBD([]string{"rm", poolName + "/" + rbdImage}); err != nil {
+		log.WithError(err).Error("Cannot remove the RBD test image")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strings"
+	"time"
+)
+
+// chartPoint is one point of a chart, Label is shown as tooltip
+type chartPoint struct {
+	X, Y  float64
+	Label string
+}
+
+// chartSeries is a set of points drawn in one color, connected if Line is set
+type chartSeries struct {
+	Name   string
+	Color  string
+	Line   bool
+	Points []chartPoint
+}
+
+const chartWidth, chartHeight, chartMargin = 640.0, 320.0, 50.0
+
+// svgChart renders series as a standalone SVG document. categories, if
+// given, label the x axis ticks 0, 1, 2, ... instead of numbers.
+func svgChart(title, xLabel, yLabel string, series []chartSeries, categories []string) string {
+	minX, maxX, minY, maxY := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, point := range s.Points {
+			minX, maxX = math.Min(minX, point.X), math.Max(maxX, point.X)
+			minY, maxY = math.Min(minY, point.Y), math.Max(maxY, point.Y)
+		}
+	}
+	if math.IsInf(minX, 1) {
+		minX, maxX, minY, maxY = 0, 1, 0, 1
+	}
+	if maxX == minX {
+		minX, maxX = minX-1, maxX+1
+	}
+	if maxY == minY {
+		minY, maxY = minY-1, maxY+1
+	}
+	// Scores start at the bottom of the chart unless they are negative
+	if minY > 0 {
+		minY = 0
+	}
+	x := func(value float64) float64 {
+		return chartMargin + (value-minX)/(maxX-minX)*(chartWidth-2*chartMargin)
+	}
+	y := func(value float64) float64 {
+		return chartHeight - chartMargin - (value-minY)/(maxY-minY)*(chartHeight-2*chartMargin)
+	}
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="sans-serif" font-size="11">`+"\n", chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(&svg, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	fmt.Fprintf(&svg, `<text x="%.0f" y="20" font-size="14" text-anchor="middle">%s</text>`+"\n", chartWidth/2, html.EscapeString(title))
+	fmt.Fprintf(&svg, `<line x1="%.0f" y1="%.0f" x2="%.0f" y2="%.0f" stroke="black"/>`+"\n", chartMargin, chartHeight-chartMargin, chartWidth-chartMargin, chartHeight-chartMargin)
+	fmt.Fprintf(&svg, `<line x1="%.0f" y1="%.0f" x2="%.0f" y2="%.0f" stroke="black"/>`+"\n", chartMargin, chartMargin, chartMargin, chartHeight-chartMargin)
+	for i := 0; i <= 4; i++ {
+		value := minY + (maxY-minY)*float64(i)/4
+		fmt.Fprintf(&svg, `<text x="%.0f" y="%.1f" text-anchor="end">%s</text>`+"\n", chartMargin-4, y(value)+4, chartNumber(value))
+	}
+	if categories != nil {
+		for i, category := range categories {
+			fmt.Fprintf(&svg, `<text x="%.1f" y="%.0f" text-anchor="middle">%s</text>`+"\n", x(float64(i)), chartHeight-chartMargin+14, html.EscapeString(category))
+		}
+	} else {
+		for i := 0; i <= 4; i++ {
+			value := minX + (maxX-minX)*float64(i)/4
+			fmt.Fprintf(&svg, `<text x="%.1f" y="%.0f" text-anchor="middle">%s</text>`+"\n", x(value), chartHeight-chartMargin+14, chartNumber(value))
+		}
+	}
+	fmt.Fprintf(&svg, `<text x="%.0f" y="%.0f" text-anchor="middle">%s</text>`+"\n", chartWidth/2, chartHeight-10, html.EscapeString(xLabel))
+	fmt.Fprintf(&svg, `<text x="12" y="%.0f" text-anchor="middle" transform="rotate(-90 12 %.0f)">%s</text>`+"\n", chartHeight/2, chartHeight/2, html.EscapeString(yLabel))
+
+	for i, s := range series {
+		if s.Line && len(s.Points) > 1 {
+			var points []string
+			for _, point := range s.Points {
+				points = append(points, fmt.Sprintf("%.1f,%.1f", x(point.X), y(point.Y)))
+			}
+			fmt.Fprintf(&svg, `<polyline fill="none" stroke="%s" stroke-width="2" points="%s"/>`+"\n", s.Color, strings.Join(points, " "))
+		} else {
+			for _, point := range s.Points {
+				fmt.Fprintf(&svg, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s</title></circle>`+"\n", x(point.X), y(point.Y), s.Color, html.EscapeString(point.Label))
+			}
+		}
+		if s.Name != "" {
+			legendY := chartMargin + float64(i)*14
+			fmt.Fprintf(&svg, `<rect x="%.0f" y="%.0f" width="10" height="10" fill="%s"/><text x="%.0f" y="%.0f">%s</text>`+"\n", chartWidth-chartMargin-100, legendY-9, s.Color, chartWidth-chartMargin-86, legendY, html.EscapeString(s.Name))
+		}
+	}
+	svg.WriteString("</svg>\n")
+	return svg.String()
+}
+
+// chartNumber formats axis labels compactly
+func chartNumber(value float64) string {
+	switch {
+	case math.Abs(value) >= 1e9:
+		return fmt.Sprintf("%.1fG", value/1e9)
+	case math.Abs(value) >= 1e6:
+		return fmt.Sprintf("%.1fM", value/1e6)
+	case math.Abs(value) >= 1e4:
+		return fmt.Sprintf("%.1fk", value/1e3)
+	}
+	return fmt.Sprintf("%.4g", value)
+}
+
+// chartValue maps an option value onto the x axis of a chart. Values that
+// are no numbers, sizes, durations or booleans are placed by their index in
+// categories, which collects them in order of appearance.
+func chartValue(value string, categories *[]string) float64 {
+	switch value {
+	case "true":
+		return 1
+	case "false":
+		return 0
+	}
+	if number, err := parseQuantity(value, time.Second); err == nil {
+		return number
+	}
+	for i, category := range *categories {
+		if category == value {
+			return float64(i)
+		}
+	}
+	*categories = append(*categories, value)
+	return float64(len(*categories) - 1)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// checkpoint is the state of a run between two trials - everything needed
+// to continue the search after the process was interrupted
+type checkpoint struct {
+	RunID            string
+	Started          time.Time
+	OptionKeys       []string
+	NoNewBest        int
+	HighestScore     float64
+	BaselineScore    float64
+	BaselineConfig   []ResultValue
+	BestOptionValues []ResultValue
+	Trials           []Trial
+	Overrides        []configDumpEntry
+	Environment      Environment
+
+	// Applied values and what is needed to undo them
+	CurrentValues     map[string]string
+	FirstValues       map[string]string
+	StoredInDatabase  map[string]bool
+	FailedValues      map[string][]string
+	BlockedRanges     map[string][][2]float64
+	UndoStack         []undoEntry
+	ClientOverrides   map[string]string
+	ImageSettings     map[string]string
+	Snapshot          configSnapshot
+	InitialConfigDump []configDumpEntry
+	CrushBackupTaken  bool
+	PoolCreated       bool
+	AutoscaleMode     string
+	HostSnapshot      map[string]string
+	HostSnapshotOrder []string
+	NumaOriginal      map[int]*string
+	FrontendOriginal  map[string]*string
+}
+
+func checkpointPath(id string) string {
+	return filepath.Join(checkpointDir, "checkpoint-"+id+".json")
+}
+
+// optionKeys identifies the tuned options so a resumed run can check it tunes the same ones
+func optionKeys(options []ConfigOption) (keys []string) {
+	for _, option := range options {
+		keys = append(keys, option.key())
+	}
+	return keys
+}
+
+// saveCheckpoint captures the global state of the run together with the
+// search state passed in and replaces the checkpoint file of the run
+func saveCheckpoint(state checkpoint) {
+	state.Environment = environment
+	state.CurrentValues = currentValues
+	state.FirstValues = firstValues
+	state.StoredInDatabase = storedInDatabase
+	state.FailedValues = failedValues
+	state.BlockedRanges = blockedRanges
+	state.UndoStack = undoStack
+	state.ClientOverrides = clientOverrides
+	state.ImageSettings = imageSettings
+	state.Snapshot = snapshot
+	state.InitialConfigDump = initialConfigDump
+	state.CrushBackupTaken = crushBackupTaken
+	state.PoolCreated = poolCreated
+	state.AutoscaleMode = autoscaleModeOriginal
+	state.HostSnapshot = hostSnapshot
+	state.HostSnapshotOrder = nil
+	for _, target := range hostSnapshotOrder {
+		state.HostSnapshotOrder = append(state.HostSnapshotOrder, target.String())
+	}
+	state.NumaOriginal = numaOriginal
+	state.FrontendOriginal = frontendOriginal
+
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		log.WithError(err).Error("Cannot serialize checkpoint")
+		return
+	}
+	// Write to a temporary file first so an interruption never leaves a truncated checkpoint
+	path := checkpointPath(state.RunID)
+	if err := os.WriteFile(path+".tmp", content, 0644); err != nil {
+		log.WithError(err).WithField("file", path).Error("Cannot write checkpoint")
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.WithError(err).WithField("file", path).Error("Cannot write checkpoint")
+	}
+}
+
+// loadCheckpoint reads the checkpoint of run id and restores the global state of the run
+func loadCheckpoint(id string, options []ConfigOption) (state checkpoint) {
+	path := checkpointPath(id)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.WithError(err).WithField("file", path).Fatal("Cannot read checkpoint")
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		log.WithError(err).WithField("file", path).Fatal("Cannot parse checkpoint")
+	}
+	if strings.Join(state.OptionKeys, ",") != strings.Join(optionKeys(options), ",") {
+		log.WithFields(log.Fields{"checkpoint": state.OptionKeys, "now": optionKeys(options)}).Fatal("The tuned options differ from the interrupted run")
+	}
+
+	currentValues = state.CurrentValues
+	firstValues = state.FirstValues
+	storedInDatabase = state.StoredInDatabase
+	failedValues = state.FailedValues
+	undoStack = state.UndoStack
+	if state.BlockedRanges != nil {
+		blockedRanges = state.BlockedRanges
+	}
+	clientOverrides = state.ClientOverrides
+	imageSettings = state.ImageSettings
+	snapshot = state.Snapshot
+	initialConfigDump, initialConfigDumpRead = state.InitialConfigDump, true
+	crushBackupTaken = state.CrushBackupTaken
+	autoscaleModeOriginal = state.AutoscaleMode
+	hostSnapshot = state.HostSnapshot
+	for _, target := range state.HostSnapshotOrder {
+		parts := strings.SplitN(target, ":", 3)
+		hostSnapshotOrder = append(hostSnapshotOrder, hostTarget{host: parts[0], device: parts[1], name: parts[2]})
+	}
+	numaOriginal = state.NumaOriginal
+	frontendOriginal = state.FrontendOriginal
+	trials = state.Trials
+	log.WithFields(log.Fields{"runID": id, "trials": len(trials)}).Info("Resuming run from checkpoint")
+	return state
+}
+
+// discardBenchmarkData removes the benchmark pool and bucket an interrupted
+// run left behind. The pool is only removed if that run created it.
+func discardBenchmarkData(state *checkpoint) {
+	if benchBackend == "rgw" {
+		removeS3Bucket()
+	}
+	if !state.PoolCreated {
+		return
+	}
+	exists, err := poolExists(poolName)
+	if err != nil {
+		log.WithError(err).Fatal("Cannot list pools")
+	}
+	if exists {
+		deleteBenchmarkPool()
+	}
+}
+
+func removeCheckpoint(id string) {
+	if err := os.Remove(checkpointPath(id)); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Warn("Cannot remove checkpoint of the finished run")
+	}
+}
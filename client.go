@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Client options (target: client) are read by librados and librbd when the
+// benchmark client connects. Every benchmark starts a new client process, so
+// new values take effect without an explicit reconnect.
+
+// Client options passed on the command line of the benchmark clients with
+// --client-apply-method args, keyed by option name
+var clientOverrides = map[string]string{}
+
+// clientArgs returns the client option overrides as Ceph command line flags
+func clientArgs() (arguments []string) {
+	var names []string
+	for name := range clientOverrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		arguments = append(arguments, fmt.Sprintf("--%s=%s", name, clientOverrides[name]))
+	}
+	return arguments
+}
+
+// appliedLocally returns true if the option only changes the benchmark clients of this process
+func (option ConfigOption) appliedLocally() bool {
+	return option.daemonType() == "client" && clientApplyMethod == "args"
+}
+
+// validateClientOptions warns about client options the benchmark does not exercise
+func validateClientOptions(options []ConfigOption) {
+	for _, option := range options {
+		if option.daemonType() != "client" {
+			continue
+		}
+		if benchBackend == "rgw" {
+			log.WithField("option", option.Name).Warn("The rgw benchmark backend uses its own S3 client - client options only affect radosgw through target rgw")
+		}
+		if benchBackend == "rbd" && rbdClient == "krbd" {
+			log.WithField("option", option.Name).Warn("The kernel RBD client does not read Ceph client options")
+		}
+	}
+}
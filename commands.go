@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The command layer shells out to the Ceph CLIs. Built with the goceph tag,
+// the ceph commands in monCommands are sent as mon commands through
+// librados instead, see moncommand_goceph.go.
+
+func runCeph(arguments []string) (output string, err error) {
+	if output, _, handled, err := monCommand(arguments); handled {
+		return output, err
+	}
+	command, wrapped := wrapCommand(cephBinary, append(connectionArgs(), arguments...))
+	ctx, cancel := withTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	if idempotentCephCommand(arguments) {
+		ctx = withRetries(ctx)
+	}
+	return executeCommandContext(ctx, command, wrapped, nil)
+}
+
+func runRados(arguments []string) (output string, err error) {
+	return executeCephTool(radosBinary, append(connectionArgs(), arguments...))
+}
+
+// runRadosContext runs rados like runRados, but kills it when ctx is cancelled
+func runRadosContext(ctx context.Context, arguments []string) (output string, err error) {
+	command, arguments := wrapCommand(radosBinary, append(connectionArgs(), arguments...))
+	return executeCommandContext(ctx, command, arguments, nil)
+}
+
+func runRBD(arguments []string) (output string, err error) {
+	return executeCephTool(rbdBinary, append(connectionArgs(), arguments...))
+}
+
+// executeCephTool runs a Ceph CLI, wrapped according to --exec-mode
+func executeCephTool(command string, arguments []string) (output string, err error) {
+	command, arguments = wrapCommand(command, arguments)
+	return executeCommand(command, arguments)
+}
+
+// wrapCommand prefixes a Ceph CLI invocation for clusters where the CLIs
+// only exist inside containers
+func wrapCommand(command string, arguments []string) (string, []string) {
+	var prefix []string
+	switch execMode {
+	case "cephadm":
+		prefix = []string{"cephadm", "shell", "--"}
+	case "prefix":
+		prefix = strings.Fields(execPrefix)
+	case "rook":
+		pod, err := rookToolsPod()
+		if err != nil {
+			// kubectl fails without a pod name, which the caller gets as error
+			log.WithError(err).Error("Cannot find the Rook toolbox pod")
+		}
+		prefix = []string{"kubectl", "--namespace", rookNamespace, "exec", pod, "--"}
+	}
+	if len(prefix) == 0 {
+		return command, arguments
+	}
+	return prefix[0], append(append(prefix[1:], command), arguments...)
+}
+
+var toolsPod string
+
+// rookToolsPod looks up the name of the Rook toolbox pod once
+func rookToolsPod() (string, error) {
+	if toolsPod != "" {
+		return toolsPod, nil
+	}
+	output, err := executeCommand("kubectl", []string{"--namespace", rookNamespace, "get", "pod", "--selector", rookToolsSelector, "--field-selector", "status.phase=Running", "--output", "jsonpath={.items[0].metadata.name}"})
+	toolsPod = strings.TrimSpace(output)
+	if toolsPod == "" {
+		if err == nil {
+			err = errors.New("no pod is running")
+		}
+		return "", fmt.Errorf("no Rook toolbox pod with selector %s in namespace %s: %w", rookToolsSelector, rookNamespace, err)
+	}
+	return toolsPod, nil
+}
+
+// connectionArgs returns the standard Ceph connection flags that select
+// the cluster and client identity for every Ceph CLI invocation
+func connectionArgs() (arguments []string) {
+	if cephCluster != "" {
+		arguments = append(arguments, "--cluster", cephCluster)
+	}
+	if cephConf != "" {
+		arguments = append(arguments, "--conf", cephConf)
+	}
+	if cephKeyring != "" {
+		arguments = append(arguments, "--keyring", cephKeyring)
+	}
+	if cephName != "" {
+		arguments = append(arguments, "--name", cephName)
+	}
+	return arguments
+}
+
+// cephArgsEnv passes the connection flags and client option overrides to programs linking librados
+// (like fio's rbd engine) through the CEPH_ARGS environment variable
+func cephArgsEnv() []string {
+	arguments := append(connectionArgs(), clientArgs()...)
+	if len(arguments) == 0 {
+		return nil
+	}
+	return []string{"CEPH_ARGS=" + strings.Join(arguments, " ")}
+}
+
+// cephJSON runs a ceph command with JSON output and decodes the response into target
+func cephJSON(target interface{}, arguments []string) error {
+	output, err := runCeph(append(arguments, "-f", "json"))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(output), target); err != nil {
+		return fmt.Errorf("cannot parse output of ceph %s: %w", strings.Join(arguments, " "), err)
+	}
+	return nil
+}
+
+// commandError is a failed command with the details needed to tell why it
+// failed. Output is stdout, or stdout and stderr combined for tryCeph.
+type commandError struct {
+	Command  string
+	ExitCode int
+	Output   string
+	Stderr   string
+}
+
+func (err *commandError) Error() string {
+	detail := err.Stderr
+	if detail == "" {
+		detail = err.Output
+	}
+	if err.Command == "" {
+		return fmt.Sprintf("exit status %d: %s", err.ExitCode, detail)
+	}
+	return fmt.Sprintf("%s: exit status %d: %s", err.Command, err.ExitCode, detail)
+}
+
+// text returns everything the command printed, Ceph writes its error
+// messages to stderr and the CLI warnings to stdout
+func (err *commandError) text() string {
+	return strings.TrimSpace(err.Output + "\n" + err.Stderr)
+}
+
+// newCommandError turns the error of a command that ran but failed into a
+// commandError and adds the command line to any other error
+func newCommandError(command string, arguments []string, err error, output []byte) error {
+	commandLine := command + " " + strings.Join(arguments, " ")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return fmt.Errorf("%s: %w", commandLine, err)
+	}
+	return &commandError{
+		Command:  commandLine,
+		ExitCode: exitErr.ExitCode(),
+		Output:   strings.TrimSpace(string(output)),
+		Stderr:   strings.TrimSpace(string(exitErr.Stderr)),
+	}
+}
+
+// tryCeph runs a ceph command like runCeph, but returns failures
+// to the caller instead of exiting
+func tryCeph(arguments []string) (output string, err error) {
+	if output, info, handled, err := monCommand(arguments); handled {
+		// Like the combined output of the CLI
+		return output + info, err
+	}
+	ctx, cancel := withTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	if idempotentCephCommand(arguments) {
+		ctx = withRetries(ctx)
+	}
+	command, arguments := wrapCommand(cephBinary, append(connectionArgs(), arguments...))
+	log.Debugf("Executing %s %s", command, strings.Join(arguments, " "))
+	start := time.Now()
+	newCmd := func() *exec.Cmd { return exec.CommandContext(ctx, command, arguments...) }
+	cmdoutput, err := runWithRetry(ctx, newCmd, (*exec.Cmd).CombinedOutput)
+	recordSpan("command", start, err, "command", command+" "+strings.Join(arguments, " "))
+	if err != nil && ctx.Err() != nil {
+		return string(cmdoutput), fmt.Errorf("%w: %s ran for %s", errCommandTimeout, command, time.Since(start).Round(time.Second))
+	}
+	if err != nil {
+		return string(cmdoutput), newCommandError(command, arguments, err, cmdoutput)
+	}
+	return string(cmdoutput), nil
+}
+
+// tryCephJSON parses the JSON output of a ceph command run with tryCeph
+func tryCephJSON(target interface{}, arguments []string) error {
+	output, err := tryCeph(append(arguments, "-f", "json"))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(output), target); err != nil {
+		return fmt.Errorf("cannot parse output of ceph %s: %w", strings.Join(arguments, " "), err)
+	}
+	return nil
+}
+
+// errCommandTimeout fails the trial of a command that was killed after its timeout
+var errCommandTimeout = fmt.Errorf("%w: command timed out", errTrialFailed)
+
+// withTimeout limits ctx to seconds, with 0 meaning no limit
+func withTimeout(ctx context.Context, seconds int) (context.Context, context.CancelFunc) {
+	if seconds <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(seconds)*time.Second)
+}
+
+func executeCommand(command string, arguments []string) (output string, err error) {
+	return executeCommandWithEnv(command, arguments, nil)
+}
+
+// executeCommandWithEnv runs command with env added to the environment of this process.
+// It is killed after --command-timeout seconds.
+func executeCommandWithEnv(command string, arguments []string, env []string) (output string, err error) {
+	ctx, cancel := withTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	return executeCommandContext(ctx, command, arguments, env)
+}
+
+// executeCommandContext runs command like executeCommandWithEnv, but kills it
+// when ctx is cancelled. Failures are returned as commandError, callers that
+// accept EINVAL for unknown options and values check its exit code.
+func executeCommandContext(ctx context.Context, command string, arguments []string, env []string) (output string, err error) {
+	// Execute the command
+	log.Debugf("Executing %s %s", command, strings.Join(arguments, " "))
+	newCmd := func() *exec.Cmd {
+		cmd := exec.CommandContext(ctx, command, arguments...)
+		if env != nil {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		return cmd
+	}
+
+	// Capture the output
+	start := time.Now()
+	cmdoutput, err := runWithRetry(ctx, newCmd, (*exec.Cmd).Output)
+	recordSpan("command", start, err, "command", command+" "+strings.Join(arguments, " "))
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.WithField("stdOut", string(cmdoutput)).Errorf("Killed hung command %s %s", command, strings.Join(arguments, " "))
+		return string(cmdoutput), fmt.Errorf("%w: %s ran for %s", errCommandTimeout, command, time.Since(start).Round(time.Second))
+	}
+	if err != nil && ctx.Err() != nil {
+		return string(cmdoutput), fmt.Errorf("%s was aborted: %w", command, ctx.Err())
+	}
+	if err != nil && stopRequested() && !cleaningUp() {
+		// The command was most likely killed by the same Ctrl-C
+		shutdownInterrupted()
+	}
+	if errors.Is(err, errTransient) {
+		log.WithError(err).WithField("stdOut", string(cmdoutput)).Error("Giving up on command")
+		return string(cmdoutput), err
+	}
+	if err != nil {
+		err = newCommandError(command, arguments, err, cmdoutput)
+		log.WithError(err).Debug("Command failed")
+		return string(cmdoutput), err
+	}
+	return string(cmdoutput), nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runCompareCommand implements the compare subcommand, which reports how the
+// outcome of two tuning runs differs, e.g. across Ceph versions or hardware
+func runCompareCommand(args []string) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	output := flags.String("o", "-", "File to write the Markdown comparison to, - for stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s compare runA.json runB.json [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	var runs [2]Results
+	for i, path := range positional {
+		results, err := readResults(path)
+		if err != nil {
+			log.WithError(err).WithField("file", path).Fatal("Cannot read results file")
+		}
+		runs[i] = results
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.WithError(err).WithField("file", *output).Fatal("Cannot create comparison")
+		}
+		defer file.Close()
+		out = file
+	}
+	writeComparison(out, runs[0], runs[1])
+	if *output != "-" {
+		log.WithField("file", *output).Info("Wrote comparison")
+	}
+}
+
+// writeComparison writes a Markdown report of the differences between run a and b
+func writeComparison(out io.Writer, a, b Results) {
+	fmt.Fprintf(out, "## ceph-optimize run %s (A) vs %s (B)\n\n", a.RunID, b.RunID)
+
+	fmt.Fprint(out, "| | A | B |\n|---|---|---|\n")
+	fmt.Fprintf(out, "| Started | %s | %s |\n", a.Started.Format("2006-01-02 15:04"), b.Started.Format("2006-01-02 15:04"))
+	fmt.Fprintf(out, "| Trials | %d (%d failed) | %d (%d failed) |\n", len(a.Trials), failedTrials(a), len(b.Trials), failedTrials(b))
+	fmt.Fprintf(out, "| Baseline score | %.2f | %.2f |\n", a.BaselineScore, b.BaselineScore)
+	fmt.Fprintf(out, "| Best score | %.2f | %.2f |\n", a.BestScore, b.BestScore)
+	fmt.Fprintf(out, "| Improvement | %s | %s |\n", improvement(a), improvement(b))
+	if a.BestScore > 0 {
+		fmt.Fprintf(out, "\nThe best score of B differs by %+.1f%% from A.\n", (b.BestScore-a.BestScore)/a.BestScore*100)
+	}
+
+	if differences := benchmarkDifferences(a.Benchmark, b.Benchmark); len(differences) > 0 {
+		fmt.Fprint(out, "\n### Benchmark settings\n\n")
+		fmt.Fprint(out, "The runs used different benchmarks, their scores are not directly comparable.\n\n")
+		fmt.Fprint(out, "| Setting | A | B |\n|---|---|---|\n")
+		for _, difference := range differences {
+			fmt.Fprintf(out, "| %s | %s | %s |\n", difference[0], markdownCell(difference[1]), markdownCell(difference[2]))
+		}
+	}
+
+	fmt.Fprint(out, "\n### Best config\n\n")
+	valuesA, valuesB := resultValueMap(a.BestConfig), resultValueMap(b.BestConfig)
+	var keys []string
+	for key := range valuesA {
+		keys = append(keys, key)
+	}
+	for key := range valuesB {
+		if _, ok := valuesA[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	same := 0
+	var rows []string
+	for _, key := range keys {
+		valueA, inA := valuesA[key]
+		valueB, inB := valuesB[key]
+		if inA && inB && sameValue(valueA.Value, valueB.Value) {
+			same++
+			continue
+		}
+		value := valueA
+		if !inA {
+			value = valueB
+		}
+		rows = append(rows, fmt.Sprintf("| %s | %s | %s | %s | %s |\n", markdownCell(value.Name), value.Target, markdownCell(strings.Join(value.Sections, ", ")), comparedValue(valueA, inA), comparedValue(valueB, inB)))
+	}
+	if len(rows) == 0 {
+		fmt.Fprintf(out, "Both runs found the same best values for all %d options.\n", same)
+	} else {
+		fmt.Fprint(out, "| Option | Target | Sections | A | B |\n|---|---|---|---|---|\n")
+		fmt.Fprint(out, strings.Join(rows, ""))
+		fmt.Fprintf(out, "\n%d options have the same best value in both runs.\n", same)
+	}
+
+	gainsA, gainsB := map[string]contribution{}, map[string]contribution{}
+	for _, entry := range contributions(a) {
+		gainsA[entry.Option] = entry
+	}
+	for _, entry := range contributions(b) {
+		gainsB[entry.Option] = entry
+	}
+	var options []string
+	for option := range gainsA {
+		options = append(options, option)
+	}
+	for option := range gainsB {
+		if _, ok := gainsA[option]; !ok {
+			options = append(options, option)
+		}
+	}
+	if len(options) > 0 {
+		sort.Strings(options)
+		fmt.Fprint(out, "\n### Contribution per option\n\n")
+		fmt.Fprint(out, "| Option | Score gain A | Score gain B |\n|---|---|---|\n")
+		for _, option := range options {
+			fmt.Fprintf(out, "| %s | %+.2f | %+.2f |\n", markdownCell(option), gainsA[option].Gain, gainsB[option].Gain)
+		}
+	}
+}
+
+func failedTrials(results Results) (failed int) {
+	for _, trial := range results.Trials {
+		if trial.Error != "" {
+			failed++
+		}
+	}
+	return failed
+}
+
+func improvement(results Results) string {
+	if results.BaselineScore <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", (results.BestScore-results.BaselineScore)/results.BaselineScore*100)
+}
+
+// benchmarkDifferences returns setting, value of a and value of b for
+// every benchmark setting that differs between the runs
+func benchmarkDifferences(a, b BenchmarkParameters) (differences [][3]string) {
+	settings := []struct {
+		name string
+		a, b interface{}
+	}{
+		{"Objective", a.Objective, b.Objective},
+		{"Backend", a.Backend, b.Backend},
+		{"Type", a.Type, b.Type},
+		{"Time", a.Time, b.Time},
+		{"Concurrent IOs", a.Scale, b.Scale},
+		{"Block size KB", a.BlockSizeKB, b.BlockSizeKB},
+		{"Object size KB", a.ObjectSizeKB, b.ObjectSizeKB},
+		{"Pool PGs", a.PoolPGs, b.PoolPGs},
+		{"CRUSH rule", a.CrushRule, b.CrushRule},
+		{"RBD client", a.RBDClient, b.RBDClient},
+		{"S3 workload", a.S3Workload, b.S3Workload},
+	}
+	for _, setting := range settings {
+		if valueA, valueB := fmt.Sprint(setting.a), fmt.Sprint(setting.b); valueA != valueB {
+			differences = append(differences, [3]string{setting.name, valueA, valueB})
+		}
+	}
+	return differences
+}
+
+func resultValueMap(values []ResultValue) map[string]ResultValue {
+	byKey := map[string]ResultValue{}
+	for _, value := range values {
+		byKey[resultValueKey(value)] = value
+	}
+	return byKey
+}
+
+func comparedValue(value ResultValue, ok bool) string {
+	if !ok {
+		return "not tuned"
+	}
+	return "`" + markdownCell(value.Value) + "`"
+}
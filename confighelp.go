@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Subset of 'ceph config help <option> -f json'
+type optionHelp struct {
+	Name               string      `json:"name"`
+	Type               string      `json:"type"`
+	Default            interface{} `json:"default"`
+	Min                interface{} `json:"min"`
+	Max                interface{} `json:"max"`
+	EnumValues         []string    `json:"enum_values"`
+	CanUpdateAtRuntime *bool       `json:"can_update_at_runtime"`
+	Flags              []string    `json:"flags"`
+	Level              string      `json:"level"`
+	Desc               string      `json:"desc"`
+}
+
+var optionHelpCache = map[string]optionHelp{}
+
+func getOptionHelp(name string) (help optionHelp, err error) {
+	if help, known := optionHelpCache[name]; known {
+		return help, nil
+	}
+	if err = cephJSON(&help, []string{"config", "help", name}); err != nil {
+		return help, err
+	}
+	optionHelpCache[name] = help
+	return help, nil
+}
+
+// Ceph option types each config file type can be used for
+var compatibleTypes = map[string][]string{
+	"bool":  {"bool"},
+	"enum":  {"str"},
+	"int":   {"int", "uint", "size", "secs", "millisecs"},
+	"float": {"float", "int", "uint", "size", "secs", "millisecs"},
+}
+
+// checkOptionsExist removes options that are limited to other releases, so
+// one config file can be used across releases. Any other option that does
+// not exist in the running Ceph release is most likely a typo and stops the
+// run before it starts.
+func checkOptionsExist(options []ConfigOption) (supported []ConfigOption) {
+	var known []string
+	if err := cephJSON(&known, []string{"config", "ls"}); err != nil {
+		log.WithError(err).Fatal("Cannot list config options of the cluster")
+	}
+	exists := map[string]bool{}
+	for _, name := range known {
+		exists[name] = true
+	}
+	cephRelease = getCephRelease()
+	log.WithField("release", cephRelease).Info("Detected Ceph release")
+
+	var problems []string
+	for _, option := range options {
+		var missing []string
+		for _, name := range option.cephNames() {
+			if !exists[name] {
+				missing = append(missing, name)
+			}
+		}
+		switch {
+		case len(missing) > 0 && option.supportedInRelease(cephRelease):
+			problems = append(problems, fmt.Sprintf("%s does not exist in this Ceph release - set minRelease or maxRelease if it was added or removed in another release", strings.Join(missing, ",")))
+		case len(missing) > 0:
+			log.WithFields(log.Fields{"option": option.Name, "missing": strings.Join(missing, ",")}).Warn("Option does not exist in this Ceph release - skipping it")
+		case !option.supportedInRelease(cephRelease):
+			log.WithFields(log.Fields{"option": option.Name, "minRelease": option.MinRelease, "maxRelease": option.MaxRelease}).Warn("Option is not tuned on this Ceph release - skipping it")
+		default:
+			supported = append(supported, option)
+		}
+	}
+	if len(problems) > 0 {
+		log.WithField("problems", strings.Join(problems, "; ")).Fatal("Unknown config options")
+	}
+	if len(supported) == 0 {
+		log.Fatal("None of the options in the config file can be tuned on this Ceph release")
+	}
+	return supported
+}
+
+// validateOptionTypes makes sure the type of every option in the config file matches Ceph's type
+func validateOptionTypes(options []ConfigOption) {
+	var problems []string
+	for i, option := range options {
+		if option.Type == "enum" && len(option.Values) == 0 {
+			problems = append(problems, fmt.Sprintf("%s is an enum without values", option.Name))
+		}
+		if !option.hasMetadata() {
+			continue
+		}
+		help, err := getOptionHelp(option.Name)
+		if err != nil {
+			log.WithError(err).WithField("option", option.Name).Warn("Cannot get option metadata - skipping type check")
+			continue
+		}
+		if option.Type == "float" && configTypes[help.Type] == "int" {
+			// Some options became integers in later releases, which reject fractional values
+			log.WithFields(log.Fields{"option": option.Name, "cephType": help.Type}).Warn("Option is an integer in this Ceph release - tuning it as int")
+			options[i].Type = "int"
+			continue
+		}
+		compatible := false
+		for _, cephType := range compatibleTypes[option.Type] {
+			if cephType == help.Type {
+				compatible = true
+			}
+		}
+		if !compatible {
+			problems = append(problems, fmt.Sprintf("%s has type %s in Ceph but %s in the config file", option.Name, help.Type, option.Type))
+		}
+	}
+	if len(problems) > 0 {
+		log.WithField("problems", strings.Join(problems, "; ")).Fatal("Config file contains options with invalid types")
+	}
+}
+
+// requiresRestart returns true if Ceph does not apply changes of the option
+// to running daemons. Older releases only report the runtime flag.
+func (help optionHelp) requiresRestart() bool {
+	if help.CanUpdateAtRuntime != nil {
+		return !*help.CanUpdateAtRuntime
+	}
+	if len(help.Flags) == 0 {
+		return false
+	}
+	for _, flag := range help.Flags {
+		if flag == "runtime" {
+			return false
+		}
+	}
+	return true
+}
+
+// detectRestartRequired marks all options that Ceph cannot change at runtime.
+// Without a restart tuning them would only score unchanged clusters, so the
+// run is refused unless restarting the targeted daemons is allowed.
+func detectRestartRequired(options []ConfigOption) {
+	var refused []string
+	for i := range options {
+		restart := false
+		for _, name := range options[i].cephNames() {
+			help, err := getOptionHelp(name)
+			if err != nil {
+				log.WithError(err).WithField("option", name).Warn("Cannot get option metadata - assuming it can be changed at runtime")
+				continue
+			}
+			restart = restart || help.requiresRestart()
+		}
+		if !restart {
+			continue
+		}
+		options[i].Restart = true
+		if !restartAllowed(options[i]) {
+			refused = append(refused, options[i].Name)
+		}
+	}
+	if len(refused) > 0 {
+		log.WithField("options", strings.Join(refused, ",")).Fatal("These options can only be changed with a daemon restart - add the matching --restart-* flag or remove them from the config file")
+	}
+}
+
+// Config file type for each Ceph option type
+var configTypes = map[string]string{
+	"bool":      "bool",
+	"int":       "int",
+	"uint":      "int",
+	"size":      "int",
+	"secs":      "int",
+	"millisecs": "int",
+	"float":     "float",
+}
+
+// metadataNumber converts a min, max or default value from the option
+// metadata, which is either a number or a (possibly empty) string
+func metadataNumber(value interface{}) (number float64, ok bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(v, 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+// maxSampledInt bounds integer ranges so their width fits into an int64
+const maxSampledInt = 1 << 62
+
+// suggestRange derives a search range from the option metadata. With a
+// factor the range is scaled around the default value and clamped to Ceph's
+// limits, otherwise Ceph's limits are used as they are.
+func (help optionHelp) suggestRange(configType string, factor float64) (min, max float64, ok bool) {
+	cephMin, hasMin := metadataNumber(help.Min)
+	cephMax, hasMax := metadataNumber(help.Max)
+	defaultValue, hasDefault := metadataNumber(help.Default)
+	if factor <= 0 && !(hasMin && hasMax) {
+		// Ceph does not limit the option, so fall back to scaling around the default
+		factor = 4
+	}
+	if factor > 0 {
+		if !hasDefault || defaultValue == 0 {
+			return 0, 0, false
+		}
+		min = defaultValue / factor
+		max = defaultValue * factor
+		if hasMin && min < cephMin {
+			min = cephMin
+		}
+		if hasMax && cephMax > 0 && max > cephMax {
+			max = cephMax
+		}
+	} else {
+		min, max = cephMin, cephMax
+	}
+	if configType == "int" {
+		// Unsigned options report limits up to 2^64, keep the range sampleable as int64
+		min = math.Max(math.Round(min), -maxSampledInt)
+		max = math.Min(math.Round(max), maxSampledInt)
+	}
+	return min, max, true
+}
+
+// populateFromMetadata fills in Type, Min and Max of options that do not set
+// them in the config file from Ceph's own option metadata. With
+// --auto-range-factor the range is scaled around the default value and
+// clamped to Ceph's limits, otherwise Ceph's limits are used as they are.
+func populateFromMetadata(options []ConfigOption) {
+	for i := range options {
+		option := &options[i]
+		if option.Type != "" && (option.Type == "bool" || option.Min != 0 || option.Max != 0 || len(option.Values) > 0) {
+			continue
+		}
+		help, err := getOptionHelp(option.Name)
+		if err != nil {
+			log.WithError(err).WithField("option", option.Name).Warn("Cannot get option metadata to fill in missing fields")
+			continue
+		}
+		if option.Type == "" {
+			option.Type = configTypes[help.Type]
+		}
+		if option.Type == "" && len(help.EnumValues) > 0 {
+			option.Type = "enum"
+		}
+		if option.Type == "enum" {
+			if len(help.EnumValues) == 0 {
+				log.WithField("option", option.Name).Fatal("Ceph does not list the values of this option - set values in the config file")
+			}
+			option.Values = help.EnumValues
+			log.WithFields(log.Fields{"option": option.Name, "values": option.Values}).Info("Filled in option values from Ceph metadata")
+			continue
+		}
+		if option.Type == "bool" || option.Type == "" || option.Min != 0 || option.Max != 0 {
+			continue
+		}
+
+		var ok bool
+		if option.Min, option.Max, ok = help.suggestRange(option.Type, autoRangeFactor); !ok {
+			log.WithField("option", option.Name).Fatal("Cannot derive a range for an option without default - set min and max in the config file")
+		}
+		if option.Min >= option.Max {
+			log.WithFields(log.Fields{"option": option.Name, "min": option.Min, "max": option.Max}).Fatal("Ceph metadata gives an empty range - set min and max in the config file")
+		}
+		log.WithFields(log.Fields{"option": option.Name, "type": option.Type, "min": option.Min, "max": option.Max}).Info("Filled in option range from Ceph metadata")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runChanges describes everything the run will change or delete in the cluster
+func runChanges(options []ConfigOption, resumed *checkpoint) (changes []string) {
+	var names []string
+	crush, host := false, false
+	for _, option := range options {
+		names = append(names, option.Name)
+		crush = crush || option.daemonType() == "crush"
+		host = host || option.daemonType() == "host"
+	}
+	restored := "restored from a snapshot when the run ends"
+	if keepBestConfig {
+		restored = "left at the best values found when the run ends (--keep-best-config)"
+	}
+	changes = append(changes, fmt.Sprintf("Change %d options again and again: %s - they are %s", len(options), strings.Join(names, ", "), restored))
+	if restartOSDs || restartRGW || restartMon || restartMgr {
+		changes = append(changes, "Restart daemons whenever an option needs it to take effect")
+	}
+	if crush {
+		changes = append(changes, "Change CRUSH tunables, which moves data in the whole cluster")
+	}
+	if host {
+		changes = append(changes, "Change sysctls and block device settings of the OSD nodes over SSH")
+	}
+	if resumed != nil && resumed.PoolCreated {
+		changes = append(changes, fmt.Sprintf("Delete the pool %s left behind by the interrupted run", poolName))
+	}
+	exists, err := poolExists(poolName)
+	if err != nil {
+		log.WithError(err).Fatal("Cannot list pools")
+	}
+	if exists && !(resumed != nil && resumed.PoolCreated) {
+		changes = append(changes, fmt.Sprintf("Write benchmark data into the existing pool %s, which is kept after the run", poolName))
+	} else {
+		changes = append(changes, fmt.Sprintf("Create the pool %s and delete it after the run, which sets mon_allow_pool_delete=true on all monitors", poolName))
+	}
+	if benchBackend == "rgw" {
+		changes = append(changes, fmt.Sprintf("Create the S3 bucket %s and delete it with all its objects after the run", s3Bucket))
+	}
+	if prefillPercent > 0 {
+		changes = append(changes, fmt.Sprintf("Write data into %s until the cluster is %g%% full", poolName, prefillPercent))
+	}
+	return changes
+}
+
+// confirmRun lists the changes of the run and asks whether to go ahead,
+// unless --yes was given. Without a terminal to ask on, the run is refused.
+func confirmRun(options []ConfigOption, resumed *checkpoint) {
+	if assumeYes {
+		return
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		log.Fatal("Refusing to change the cluster without confirmation - pass --yes to run unattended")
+	}
+	defer suspendTUI()()
+	fmt.Println("This run will:")
+	for _, change := range runChanges(options, resumed) {
+		fmt.Printf("  - %s\n", change)
+	}
+	fmt.Print("Continue? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+	default:
+		log.Info("Aborted - nothing was changed")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "sort"
+
+// contribution is the share of the total improvement attributed to one option
+type contribution struct {
+	Option   string
+	Accepted int     // accepted changes of the option
+	Gain     float64 // sum of the score increases when the changes were accepted
+	Share    float64 // percent of the total improvement over the baseline
+}
+
+// contributions attributes the improvement of a run to the options by the
+// score increase each accepted change brought over the best score before it.
+// Options are ordered by their gain, highest first.
+func contributions(results Results) (list []contribution) {
+	byOption := map[string]*contribution{}
+	best := results.BaselineScore
+	for _, trial := range results.Trials {
+		if !trial.Accepted || trial.Error != "" {
+			continue
+		}
+		name := trial.Option
+		if trial.Class != "" {
+			name += "@" + trial.Class
+		}
+		if byOption[name] == nil {
+			byOption[name] = &contribution{Option: name}
+		}
+		byOption[name].Accepted++
+		byOption[name].Gain += trial.Score - best
+		best = trial.Score
+	}
+	total := best - results.BaselineScore
+	for _, entry := range byOption {
+		if total > 0 {
+			entry.Share = entry.Gain / total * 100
+		}
+		list = append(list, *entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Gain > list[j].Gain })
+	return list
+}
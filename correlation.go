@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// trialID is the UUID of the running trial, empty between trials
+var trialID string
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.WithError(err).Fatal("Cannot generate UUID")
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// correlationHook adds the run ID and the ID of the running trial to every log entry
+type correlationHook struct{}
+
+func (correlationHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (correlationHook) Fire(entry *log.Entry) error {
+	if runID != "" {
+		entry.Data["runID"] = runID
+	}
+	if trialID != "" {
+		entry.Data["trialID"] = trialID
+	}
+	return nil
+}
+
+// expandRunID replaces {run} in the paths of the artifacts of a run with the run ID
+func expandRunID(paths ...*string) {
+	for _, path := range paths {
+		*path = strings.ReplaceAll(*path, "{run}", runID)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errCrashed fails a trial during which daemons crashed or OSDs flapped
+var errCrashed = fmt.Errorf("%w: daemons crashed", errUnhealthy)
+
+// Subset of 'ceph crash ls-new -f json' and 'ceph crash info <id>'
+type crashReport struct {
+	CrashID    string   `json:"crash_id"`
+	EntityName string   `json:"entity_name"`
+	Timestamp  string   `json:"timestamp"`
+	AssertMsg  string   `json:"assert_msg"`
+	Backtrace  []string `json:"backtrace"`
+}
+
+// crashWatch remembers the crashes and OSD starts from before a trial
+type crashWatch struct {
+	crashes map[string]bool
+	upFrom  map[int]int
+}
+
+// Ranges of option.key() values around values that crashed daemons, never tried again
+var blockedRanges = map[string][][2]float64{}
+
+// Crash descriptions of the last checked trial, recorded with the trial
+var trialCrashes []string
+
+// startCrashWatch records the known crashes and since when every OSD is up
+func startCrashWatch() crashWatch {
+	watch := crashWatch{crashes: map[string]bool{}, upFrom: map[int]int{}}
+	var crashes []crashReport
+	if err := tryCephJSON(&crashes, []string{"crash", "ls-new"}); err != nil {
+		log.WithError(err).Debug("Cannot list crashes - crash detection is disabled for this trial")
+		watch.crashes = nil
+	}
+	for _, crash := range crashes {
+		watch.crashes[crash.CrashID] = true
+	}
+	if dump, err := getOSDDump(); err == nil {
+		for _, osd := range dump.OSDs {
+			watch.upFrom[osd.OSD] = osd.UpFrom
+		}
+	}
+	return watch
+}
+
+// check returns an error wrapping errCrashed if daemons crashed since the
+// watch started or OSDs went down and came back without a planned restart
+func (watch crashWatch) check(option ConfigOption) error {
+	trialCrashes = nil
+	if watch.crashes != nil {
+		var crashes []crashReport
+		if err := tryCephJSON(&crashes, []string{"crash", "ls-new"}); err == nil {
+			for _, crash := range crashes {
+				if !watch.crashes[crash.CrashID] {
+					trialCrashes = append(trialCrashes, describeCrash(crash))
+				}
+			}
+		}
+	}
+	if !option.Restart {
+		if dump, err := getOSDDump(); err == nil {
+			for _, osd := range dump.OSDs {
+				if before, known := watch.upFrom[osd.OSD]; known && osd.UpFrom != before {
+					trialCrashes = append(trialCrashes, fmt.Sprintf("osd.%d flapped (up since epoch %d, was %d)", osd.OSD, osd.UpFrom, before))
+				}
+			}
+		}
+	}
+	if len(trialCrashes) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errCrashed, strings.Join(trialCrashes, "; "))
+}
+
+// describeCrash summarizes a crash with the assertion or the top of the backtrace
+func describeCrash(crash crashReport) string {
+	var info crashReport
+	if err := tryCephJSON(&info, []string{"crash", "info", crash.CrashID}); err == nil {
+		crash = info
+	}
+	description := fmt.Sprintf("%s crashed at %s (crash %s)", crash.EntityName, crash.Timestamp, crash.CrashID)
+	switch {
+	case crash.AssertMsg != "":
+		description += ": " + strings.SplitN(strings.TrimSpace(crash.AssertMsg), "\n", 2)[0]
+	case len(crash.Backtrace) > 1:
+		// The first frame is the signal handler
+		description += ": " + strings.TrimSpace(crash.Backtrace[1])
+	}
+	return description
+}
+
+// blockNearbyValues keeps the search away from value and the values around
+// it, --crash-block-range percent of the option's range in both directions
+func blockNearbyValues(option ConfigOption, value string) {
+	number, err := parseQuantity(value, time.Second)
+	if err != nil || option.Max <= option.Min || crashBlockRange <= 0 {
+		return
+	}
+	margin := (option.Max - option.Min) * crashBlockRange / 100
+	blockedRanges[option.key()] = append(blockedRanges[option.key()], [2]float64{number - margin, number + margin})
+	log.WithFields(log.Fields{"option": option.Name, "from": formatQuantity(number - margin), "to": formatQuantity(number + margin)}).Warn("Value crashed daemons - the values around it will not be tried again")
+}
+
+// blocked returns true if value is in a range around a value that crashed daemons
+func (option ConfigOption) blocked(value string) bool {
+	number, err := parseQuantity(value, time.Second)
+	if err != nil {
+		return false
+	}
+	for _, blocked := range blockedRanges[option.key()] {
+		if number >= blocked[0] && number <= blocked[1] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CRUSH tunables that can be tuned with target: crush, the crushtool flag
+// that sets them and the range used when the config file does not set one.
+// choose_tries and chooseleaf_tries are steps of the benchmark pool's rule.
+var crushTunables = map[string]struct {
+	flag     string
+	min, max float64
+}{
+	"choose_local_tries":          {"--set-choose-local-tries", 0, 3},
+	"choose_local_fallback_tries": {"--set-choose-local-fallback-tries", 0, 5},
+	"choose_total_tries":          {"--set-choose-total-tries", 19, 100},
+	"chooseleaf_descend_once":     {"--set-chooseleaf-descend-once", 0, 1},
+	"chooseleaf_vary_r":           {"--set-chooseleaf-vary-r", 0, 1},
+	"chooseleaf_stable":           {"--set-chooseleaf-stable", 0, 1},
+	"straw_calc_version":          {"--set-straw-calc-version", 0, 1},
+	"choose_tries":                {"", 1, 100},
+	"chooseleaf_tries":            {"", 1, 10},
+}
+
+var crushBackupTaken bool
+
+// validateCrushOptions checks CRUSH options and fills in their type and range.
+// Changing the CRUSH map moves data in the whole cluster, so it has to be
+// allowed explicitly.
+func validateCrushOptions(options []ConfigOption) {
+	for i := range options {
+		option := &options[i]
+		if option.daemonType() != "crush" {
+			continue
+		}
+		tunable, known := crushTunables[option.Name]
+		if !known {
+			log.WithField("tunable", option.Name).Fatal("Unsupported CRUSH tunable")
+		}
+		if option.Class != "" || option.Restart {
+			log.WithField("tunable", option.Name).Fatal("CRUSH tunables cannot have a device class or require a restart")
+		}
+		if !allowCrushChanges {
+			log.WithField("tunable", option.Name).Fatal("Tuning CRUSH moves data in the whole cluster - add --allow-crush-changes to do it anyway")
+		}
+		if execMode != "local" {
+			log.Fatal("CRUSH tunables need crushtool and --exec-mode local")
+		}
+		option.Type = "int"
+		if option.Min == 0 && option.Max == 0 {
+			option.Min, option.Max = tunable.min, tunable.max
+		}
+	}
+}
+
+// backupCrushMap saves the CRUSH map before the first change, so it can be
+// restored together with the config snapshot
+func backupCrushMap(options []ConfigOption) error {
+	for _, option := range options {
+		if option.daemonType() != "crush" {
+			continue
+		}
+		if _, err := runCeph([]string{"osd", "getcrushmap", "-o", crushBackupFile}); err != nil {
+			return fmt.Errorf("cannot back up the CRUSH map: %w", err)
+		}
+		crushBackupTaken = true
+		log.WithField("file", crushBackupFile).Info("Saved backup of the CRUSH map")
+		return nil
+	}
+	return nil
+}
+
+// restoreCrushMap sets the CRUSH map from before the run again
+func restoreCrushMap() {
+	if !crushBackupTaken {
+		return
+	}
+	log.WithField("file", crushBackupFile).Info("Restoring CRUSH map from backup")
+	if _, err := runCeph([]string{"osd", "setcrushmap", "-i", crushBackupFile}); err != nil {
+		log.WithError(err).Error("Cannot restore the CRUSH map - restore it with 'ceph osd setcrushmap -i " + crushBackupFile + "'")
+	}
+}
+
+// Subset of 'ceph osd crush rule dump <rule> -f json'
+type crushRuleDump struct {
+	Steps []struct {
+		Op  string `json:"op"`
+		Num int    `json:"num"`
+	} `json:"steps"`
+}
+
+// benchmarkRule returns the name of the CRUSH rule of the benchmark pool
+func benchmarkRule() (string, error) {
+	var rule struct {
+		CrushRule string `json:"crush_rule"`
+	}
+	if err := cephJSON(&rule, []string{"osd", "pool", "get", poolName, "crush_rule"}); err != nil {
+		return "", fmt.Errorf("cannot get the CRUSH rule of the benchmark pool: %w", err)
+	}
+	return rule.CrushRule, nil
+}
+
+// getCrushTunable reads a tunable or a step of the benchmark pool's rule,
+// rule steps that are not set are reported as 0
+func getCrushTunable(name string) string {
+	if crushTunables[name].flag == "" {
+		ruleName, err := benchmarkRule()
+		if err != nil {
+			log.WithError(err).Error("Cannot read the CRUSH rule of the benchmark pool")
+			return ""
+		}
+		var rule crushRuleDump
+		if err := cephJSON(&rule, []string{"osd", "crush", "rule", "dump", ruleName}); err != nil {
+			log.WithError(err).Error("Cannot read the CRUSH rule of the benchmark pool")
+			return ""
+		}
+		for _, step := range rule.Steps {
+			if step.Op == "set_"+name {
+				return fmt.Sprint(step.Num)
+			}
+		}
+		return "0"
+	}
+	var tunables map[string]interface{}
+	if err := cephJSON(&tunables, []string{"osd", "crush", "show-tunables"}); err != nil {
+		log.WithError(err).Error("Cannot read the CRUSH tunables")
+		return ""
+	}
+	return fmt.Sprint(tunables[name])
+}
+
+// setCrushTunable compiles a CRUSH map with the new value, injects it and
+// waits for the resulting data movement to finish
+func setCrushTunable(name, value string) error {
+	dir, err := os.MkdirTemp("", "ceph-optimize-crush")
+	if err != nil {
+		return applyError(name, value, "CRUSH map", err)
+	}
+	defer os.RemoveAll(dir)
+	current, updated := filepath.Join(dir, "current"), filepath.Join(dir, "updated")
+	if _, err := runCeph([]string{"osd", "getcrushmap", "-o", current}); err != nil {
+		return applyError(name, value, "CRUSH map", err)
+	}
+	if flag := crushTunables[name].flag; flag != "" {
+		_, err = executeCommand("crushtool", []string{"-i", current, flag, value, "-o", updated})
+	} else {
+		var rule string
+		if rule, err = benchmarkRule(); err == nil {
+			err = setRuleStep(current, updated, rule, name, value)
+		}
+	}
+	if err != nil {
+		return applyError(name, value, "crushtool", err)
+	}
+	if _, err := runCeph([]string{"osd", "setcrushmap", "-i", updated}); err != nil {
+		return applyError(name, value, "CRUSH map", err)
+	}
+	if err := waitForCleanPGs(time.Duration(restartTimeout) * time.Second); err != nil {
+		log.WithError(err).Warn("Cluster did not settle after changing the CRUSH map")
+	}
+	return nil
+}
+
+var ruleStart = regexp.MustCompile(`^rule (\S+) \{`)
+
+// setRuleStep decompiles the CRUSH map and sets 'step set_<name> <value>' at
+// the start of the rule - a value of 0 removes the step
+func setRuleStep(current, updated, rule, name, value string) error {
+	text := current + ".txt"
+	if _, err := executeCommand("crushtool", []string{"-d", current, "-o", text}); err != nil {
+		return err
+	}
+	content, err := os.ReadFile(text)
+	if err != nil {
+		return err
+	}
+	step := "step set_" + name
+	number, _ := strconv.Atoi(value)
+	var lines []string
+	inRule := false
+	for _, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if match := ruleStart.FindStringSubmatch(trimmed); match != nil {
+			inRule = match[1] == rule
+		}
+		if inRule && strings.HasPrefix(trimmed, step+" ") {
+			continue
+		}
+		if inRule && strings.HasPrefix(trimmed, "step take") && number != 0 {
+			lines = append(lines, fmt.Sprintf("\t%s %d", step, number))
+		}
+		lines = append(lines, line)
+	}
+	if err := os.WriteFile(text, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		return err
+	}
+	_, err = executeCommand("crushtool", []string{"-c", text, "-o", updated})
+	return err
+}
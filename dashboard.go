@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var dashboardActive bool
+
+// startDashboardBanner announces the run as message of the day of the Ceph
+// dashboard, so operators see the experiment in the UI they already use
+func startDashboardBanner(options int) {
+	if !dashboardBanner {
+		return
+	}
+	dashboardActive = true
+	log.RegisterExitHandler(clearDashboardBanner)
+	setDashboardBanner(fmt.Sprintf("ceph-optimize run %s is tuning %d options - benchmarks and config changes in progress", runID, options))
+}
+
+// dashboardProgress shows the state of the search in the dashboard banner
+func dashboardProgress(noNewBest int, baselineScore, highestScore float64) {
+	message := fmt.Sprintf("ceph-optimize run %s: trial %d, %d of %d trials without improvement, best score %.2f", runID, len(trials)+1, noNewBest, timeout, highestScore)
+	if baselineScore > 0 {
+		message += fmt.Sprintf(" (%+.1f%% vs baseline)", (highestScore-baselineScore)/baselineScore*100)
+	}
+	setDashboardBanner(message)
+}
+
+func setDashboardBanner(message string) {
+	if !dashboardActive {
+		return
+	}
+	// An expiry of 0 keeps the banner until it is cleared
+	if _, err := tryCeph([]string{"dashboard", "motd", "set", "warning", "0", message}); err != nil {
+		log.WithError(err).Warn("Cannot set Ceph dashboard banner - not updating it anymore")
+		dashboardActive = false
+	}
+}
+
+// clearDashboardBanner removes the banner when the run ends
+func clearDashboardBanner() {
+	if !dashboardActive {
+		return
+	}
+	dashboardActive = false
+	if _, err := tryCeph([]string{"dashboard", "motd", "clear"}); err != nil {
+		log.WithError(err).Warn("Cannot clear Ceph dashboard banner")
+	}
+}
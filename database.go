@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The results database is a SQLite file that accumulates the trials of all
+// runs. It is written with the sqlite3 CLI so the optimizer stays free of cgo.
+
+const databaseSchema = `CREATE TABLE IF NOT EXISTS runs (
+	run_id TEXT PRIMARY KEY,
+	started TEXT NOT NULL,
+	finished TEXT,
+	benchmark TEXT NOT NULL,
+	baseline_score REAL,
+	best_score REAL,
+	best_config TEXT
+);
+CREATE TABLE IF NOT EXISTS trials (
+	run_id TEXT NOT NULL REFERENCES runs(run_id),
+	timestamp TEXT NOT NULL,
+	option TEXT NOT NULL,
+	target TEXT NOT NULL,
+	class TEXT NOT NULL,
+	old_value TEXT NOT NULL,
+	new_value TEXT NOT NULL,
+	score REAL,
+	accepted INTEGER NOT NULL,
+	error TEXT
+);
+CREATE INDEX IF NOT EXISTS trials_run_id ON trials(run_id);
+`
+
+// runID identifies this run in the results database and in results.json
+var runID string
+
+// newRunID returns an ID that sorts by start time
+func newRunID(started time.Time) string {
+	return fmt.Sprintf("%s-%04x", started.Format("20060102-150405"), r.Intn(0x10000))
+}
+
+// execSQL runs statements against the results database
+func execSQL(statements string) error {
+	cmd := exec.Command(sqliteBinary, "-bail", databasePath)
+	cmd.Stdin = strings.NewReader(statements)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// sqlText quotes text as SQL string literal
+func sqlText(text string) string {
+	return "'" + strings.ReplaceAll(text, "'", "''") + "'"
+}
+
+// sqlJSON quotes the JSON serialization of value as SQL string literal
+func sqlJSON(value interface{}) string {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return "NULL"
+	}
+	return sqlText(string(content))
+}
+
+// openDatabase creates the schema if needed and registers this run,
+// a resumed run keeps its registration
+func openDatabase(started time.Time) {
+	if databasePath == "" {
+		return
+	}
+	statement := databaseSchema + fmt.Sprintf("INSERT OR IGNORE INTO runs (run_id, started, benchmark) VALUES (%s, %s, %s);\n",
+		sqlText(runID), sqlText(started.Format(time.RFC3339)), sqlJSON(benchmarkParameters()))
+	if err := execSQL(statement); err != nil {
+		log.WithError(err).WithField("database", databasePath).Fatal("Cannot open results database")
+	}
+	log.WithFields(log.Fields{"database": databasePath, "runID": runID}).Info("Recording run in results database")
+}
+
+// storeTrial inserts one trial of this run into the results database
+func storeTrial(trial Trial) {
+	if databasePath == "" {
+		return
+	}
+	score := fmt.Sprint(trial.Score)
+	errText := "NULL"
+	if trial.Error != "" {
+		score = "NULL"
+		errText = sqlText(trial.Error)
+	}
+	accepted := 0
+	if trial.Accepted {
+		accepted = 1
+	}
+	statement := fmt.Sprintf("INSERT INTO trials VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %d, %s);\n",
+		sqlText(runID), sqlText(trial.Timestamp.Format(time.RFC3339)), sqlText(trial.Option), sqlText(trial.Target),
+		sqlText(trial.Class), sqlText(trial.OldValue), sqlText(trial.NewValue), score, accepted, errText)
+	if err := execSQL(statement); err != nil {
+		log.WithError(err).WithField("database", databasePath).Error("Cannot store trial in results database")
+	}
+}
+
+// finishRun stores the outcome of this run in the results database
+func finishRun(results Results) {
+	if databasePath == "" {
+		return
+	}
+	statement := fmt.Sprintf("UPDATE runs SET finished = %s, baseline_score = %v, best_score = %v, best_config = %s WHERE run_id = %s;\n",
+		sqlText(results.Finished.Format(time.RFC3339)), results.BaselineScore, results.BestScore, sqlJSON(results.BestConfig), sqlText(runID))
+	if err := execSQL(statement); err != nil {
+		log.WithError(err).WithField("database", databasePath).Error("Cannot store run results in results database")
+	}
+}
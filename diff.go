@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DiffEntry compares the tuned value of an option with the Ceph default and
+// the value from before the run
+type DiffEntry struct {
+	Name            string   `json:"name"`
+	Sections        []string `json:"sections"`
+	Default         string   `json:"default"`
+	PreRun          string   `json:"preRun"`
+	Tuned           string   `json:"tuned"`
+	Changed         bool     `json:"changed"`         // tuned differs from the pre-run value
+	RevertedDefault bool     `json:"revertedDefault"` // tuned is the default while the pre-run value was not
+}
+
+// configDiff compares the best values of all config options with the Ceph
+// defaults and the snapshot from before the run
+func configDiff(best []ResultValue) (diff []DiffEntry) {
+	preRun := map[string]string{}
+	for _, entry := range snapshot.Options {
+		preRun[diffKey(entry.Option.Name, entry.Option.configSections())] = entry.Value
+	}
+	for _, value := range best {
+		if len(value.Sections) == 0 {
+			// Pool, host and other settings have no Ceph default
+			continue
+		}
+		entry := DiffEntry{Name: value.Name, Sections: value.Sections, PreRun: preRun[diffKey(value.Name, value.Sections)], Tuned: value.Value}
+		if help, err := getOptionHelp(value.Name); err == nil {
+			entry.Default = fmt.Sprint(help.Default)
+			if number, ok := metadataNumber(help.Default); ok {
+				entry.Default = formatQuantity(number)
+			}
+		}
+		entry.Changed = !sameValue(entry.Tuned, entry.PreRun)
+		entry.RevertedDefault = entry.Changed && entry.Default != "" && sameValue(entry.Tuned, entry.Default) && !sameValue(entry.PreRun, entry.Default)
+		diff = append(diff, entry)
+	}
+	return diff
+}
+
+func diffKey(name string, sections []string) string {
+	return name + "|" + strings.Join(sections, ",")
+}
+
+// printConfigDiff logs the three-way diff as table
+func printConfigDiff(diff []DiffEntry) {
+	var table strings.Builder
+	fmt.Fprintf(&table, "%-40s %-15s %-15s %-15s %-15s\n", "option", "sections", "default", "pre-run", "tuned")
+	for _, entry := range diff {
+		note := ""
+		switch {
+		case entry.RevertedDefault:
+			note = "reverted to default"
+		case !entry.Changed:
+			note = "unchanged"
+		}
+		fmt.Fprintf(&table, "%-40s %-15s %-15s %-15s %-15s %s\n", entry.Name, strings.Join(entry.Sections, ","), entry.Default, entry.PreRun, entry.Tuned, note)
+	}
+	log.Infof("Best config compared to defaults and the config before the run:\n%s", table.String())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// printDryRun shows what a run would do with the validated options without
+// changing anything: the benchmark commands and a sample of trial values
+func printDryRun(options []ConfigOption) {
+	out := os.Stdout
+	fmt.Fprintln(out, "Dry run - no config values are changed and no pool is created")
+	fmt.Fprintln(out, "\nBenchmark setup:")
+	fmt.Fprintf(out, "  %s\n", plannedCephTool(cephBinary, poolCreateArgs()))
+	switch benchBackend {
+	case "rbd":
+		fmt.Fprintf(out, "  %s\n", plannedCephTool(rbdBinary, append([]string{"create", poolName + "/" + rbdImage, "--size", fmt.Sprint(rbdImageSize)}, imageCreateArgs()...)))
+	case "rgw":
+		fmt.Fprintf(out, "  S3 bucket %s on %s for the %s workload\n", s3Bucket, s3Endpoint, s3Workload)
+	}
+
+	fmt.Fprintln(out, "\nBenchmark of every trial:")
+	switch benchBackend {
+	case "rbd":
+		fmt.Fprintf(out, "  %s\n", plannedCommand(fioBinary, fioBenchArgs()))
+	case "rgw":
+		fmt.Fprintf(out, "  %d S3 workers running %s requests for %ds\n", benchScale, s3Workload, benchTime)
+	default:
+		fmt.Fprintf(out, "  %s\n", plannedCephTool(radosBinary, radosBenchArgs()))
+	}
+	if objective == "recovery-latency" {
+		fmt.Fprintf(out, "  followed by a second benchmark while osd.%d is reweighted to %g to trigger backfill\n", recoveryOSD, recoveryReweight)
+	}
+
+	fmt.Fprintf(out, "\nSample of the first %d trials (the run continues until %d trials in a row bring no improvement):\n", timeout, timeout)
+	for trial := 1; trial <= timeout; trial++ {
+		option := getRandOption(options)
+		value := constrainMClock(option, findNewValueForOption(option))
+		name := option.Name
+		if class := option.deviceClass(); class != "" {
+			name += "@" + class
+		}
+		restart := ""
+		if option.Restart {
+			restart = " (restarts daemons)"
+		}
+		fmt.Fprintf(out, "  %3d. %s: %s -> %s%s\n", trial, name, getCurrentValueForOption(option), value, restart)
+	}
+}
+
+// plannedCephTool renders a Ceph CLI invocation the way it would be executed
+func plannedCephTool(command string, arguments []string) string {
+	return plannedCommand(wrapCommand(command, append(connectionArgs(), arguments...)))
+}
+
+func plannedCommand(command string, arguments []string) string {
+	quoted := []string{shellQuote(command)}
+	for _, argument := range arguments {
+		quoted = append(quoted, shellQuote(argument))
+	}
+	return strings.Join(quoted, " ")
+}
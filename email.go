@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sendMail sends a message with a plain text and an optional HTML part to --smtp-to
+func sendMail(subject, text, html string) error {
+	host, _, err := net.SplitHostPort(smtpServer)
+	if err != nil {
+		return fmt.Errorf("SMTP server must be host:port: %w", err)
+	}
+	var recipients []string
+	for _, recipient := range strings.Split(smtpTo, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("no recipients in --smtp-to")
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	header := []string{
+		"From: " + smtpFrom,
+		"To: " + strings.Join(recipients, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + parts.Boundary(),
+	}
+	message := bytes.NewBufferString(strings.Join(header, "\r\n") + "\r\n\r\n")
+	alternatives := [][2]string{{"text/plain", text}}
+	if html != "" {
+		// The last alternative is the preferred one
+		alternatives = append(alternatives, [2]string{"text/html", html})
+	}
+	for _, alternative := range alternatives {
+		part, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {alternative[0] + "; charset=utf-8"}, "Content-Transfer-Encoding": {"8bit"}})
+		if err != nil {
+			return err
+		}
+		part.Write([]byte(strings.ReplaceAll(alternative[1], "\n", "\r\n")))
+	}
+	parts.Close()
+	message.Write(body.Bytes())
+
+	var auth smtp.Auth
+	if smtpUser != "" {
+		auth = smtp.PlainAuth("", smtpUser, smtpPassword, host)
+	}
+	return smtp.SendMail(smtpServer, auth, smtpFrom, recipients, message.Bytes())
+}
+
+// mailReport sends the Markdown summary and the HTML report of a finished run
+func mailReport(results Results) {
+	if smtpServer == "" {
+		return
+	}
+	var text, html strings.Builder
+	writeMarkdownReport(&text, results)
+	if err := writeHTMLReport(&html, results); err != nil {
+		log.WithError(err).Warn("Cannot render HTML report for the email - sending the summary only")
+		html.Reset()
+	}
+	subject := fmt.Sprintf("ceph-optimize run %s finished: best score %.2f", results.RunID, results.BestScore)
+	if results.BaselineScore > 0 {
+		subject += fmt.Sprintf(" (%+.1f%%)", (results.BestScore-results.BaselineScore)/results.BaselineScore*100)
+	}
+	if err := sendMail(subject, text.String(), html.String()); err != nil {
+		log.WithError(err).WithField("server", smtpServer).Warn("Cannot send report email")
+		return
+	}
+	log.WithField("to", smtpTo).Info("Sent report email")
+}
+
+// mailHook sends an email for every fatal error, which ends the run
+type mailHook struct{}
+
+func (mailHook) Levels() []log.Level {
+	return []log.Level{log.FatalLevel}
+}
+
+func (mailHook) Fire(entry *log.Entry) error {
+	var text strings.Builder
+	fmt.Fprintf(&text, "ceph-optimize run %s was aborted: %s\n\n", runID, entry.Message)
+	var keys []string
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&text, "- %s: %v\n", key, entry.Data[key])
+	}
+	if len(trials) > 0 {
+		fmt.Fprintf(&text, "\n### Trials so far\n\n| Option | Old value | New value | Score | Accepted | Error |\n|---|---|---|---|---|---|\n")
+		for _, trial := range trials {
+			fmt.Fprintf(&text, "| %s | `%s` | `%s` | %.2f | %t | %s |\n", markdownCell(trial.Option), markdownCell(trial.OldValue), markdownCell(trial.NewValue), trial.Score, trial.Accepted, markdownCell(trial.Error))
+		}
+	}
+	if latestBestFile != "" {
+		fmt.Fprintf(&text, "\nThe best config found so far is in %s.\n", latestBestFile)
+	}
+	return sendMail(fmt.Sprintf("ceph-optimize run %s aborted", runID), text.String(), "")
+}
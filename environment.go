@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Environment describes the software and hardware a run was tuned on
+type Environment struct {
+	CephVersions json.RawMessage   `json:"cephVersions,omitempty"` // ceph versions
+	Hosts        []hostEnvironment `json:"hosts"`
+}
+
+type hostEnvironment struct {
+	Hostname   string           `json:"hostname"`
+	Kernel     string           `json:"kernel"`
+	Distro     string           `json:"distro"`
+	CPU        string           `json:"cpu"`
+	MemoryKB   int              `json:"memoryKB"`
+	MTU        map[string]int   `json:"mtu,omitempty"` // MTU of the public and cluster network interfaces
+	OSDs       []osdEnvironment `json:"osds"`
+	interfaces map[string]bool
+}
+
+type osdEnvironment struct {
+	ID          int    `json:"id"`
+	Devices     string `json:"devices"`
+	Models      string `json:"models"` // vendor and model of the devices, from their device IDs
+	Rotational  bool   `json:"rotational"`
+	Objectstore string `json:"objectstore"`
+}
+
+// Subset of 'ceph osd metadata -f json' describing the environment
+type osdEnvironmentMetadata struct {
+	ID                  int    `json:"id"`
+	Hostname            string `json:"hostname"`
+	KernelVersion       string `json:"kernel_version"`
+	DistroDescription   string `json:"distro_description"`
+	CPU                 string `json:"cpu"`
+	MemTotalKB          string `json:"mem_total_kb"`
+	Devices             string `json:"devices"`
+	DeviceIDs           string `json:"device_ids"` // like sda=VENDOR_MODEL_SERIAL
+	Rotational          string `json:"rotational"`
+	BluestoreRotational string `json:"bluestore_bdev_rotational"`
+	OSDObjectstore      string `json:"osd_objectstore"`
+	FrontIface          string `json:"front_iface"`
+	BackIface           string `json:"back_iface"`
+}
+
+var environment Environment
+
+// captureEnvironment collects Ceph versions and the kernel, OS and hardware
+// of the OSD hosts. Missing details are logged and left empty.
+func captureEnvironment() (env Environment) {
+	if err := cephJSON(&env.CephVersions, []string{"versions"}); err != nil {
+		log.WithError(err).Warn("Cannot record Ceph versions")
+	}
+	var metadata []osdEnvironmentMetadata
+	if err := cephJSON(&metadata, []string{"osd", "metadata"}); err != nil {
+		log.WithError(err).Warn("Cannot record OSD hosts")
+		return env
+	}
+	hosts := map[string]*hostEnvironment{}
+	var hostnames []string
+	for _, osd := range metadata {
+		host, known := hosts[osd.Hostname]
+		if !known {
+			memory, _ := strconv.Atoi(osd.MemTotalKB)
+			host = &hostEnvironment{Hostname: osd.Hostname, Kernel: osd.KernelVersion, Distro: osd.DistroDescription, CPU: osd.CPU, MemoryKB: memory, interfaces: map[string]bool{}}
+			hosts[osd.Hostname] = host
+			hostnames = append(hostnames, osd.Hostname)
+		}
+		rotational := osd.Rotational
+		if rotational == "" {
+			rotational = osd.BluestoreRotational
+		}
+		host.OSDs = append(host.OSDs, osdEnvironment{ID: osd.ID, Devices: osd.Devices, Models: deviceModels(osd.DeviceIDs), Rotational: rotational == "1", Objectstore: osd.OSDObjectstore})
+		for _, iface := range []string{osd.FrontIface, osd.BackIface} {
+			if iface != "" {
+				host.interfaces[iface] = true
+			}
+		}
+	}
+	sort.Strings(hostnames)
+	for _, hostname := range hostnames {
+		host := hosts[hostname]
+		host.MTU = interfaceMTUs(hostname, host.interfaces)
+		env.Hosts = append(env.Hosts, *host)
+	}
+	return env
+}
+
+// deviceModels strips the serial numbers from device IDs like sda=VENDOR_MODEL_SERIAL
+func deviceModels(deviceIDs string) string {
+	var models []string
+	for _, entry := range strings.Split(deviceIDs, ",") {
+		id := entry[strings.Index(entry, "=")+1:]
+		if end := strings.LastIndex(id, "_"); end > 0 {
+			models = append(models, id[:end])
+		}
+	}
+	return strings.Join(models, ",")
+}
+
+// interfaceMTUs reads the MTU of network interfaces on a host. Unlike
+// runShellOnHost a host that cannot be reached only leaves the MTU unknown.
+func interfaceMTUs(host string, interfaces map[string]bool) map[string]int {
+	mtus := map[string]int{}
+	for iface := range interfaces {
+		script := "cat /sys/class/net/" + iface + "/mtu"
+		command := exec.Command("ssh", "-o", "BatchMode=yes", host, script)
+		if hostname, _ := os.Hostname(); host == hostname || strings.HasPrefix(hostname, host+".") {
+			command = exec.Command("sh", "-c", script)
+		}
+		output, err := command.Output()
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"host": host, "interface": iface}).Debug("Cannot read MTU")
+			continue
+		}
+		if mtu, err := strconv.Atoi(strings.TrimSpace(string(output))); err == nil {
+			mtus[iface] = mtu
+		}
+	}
+	return mtus
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runExportCommand implements the export subcommand, which hands the trials
+// of a results file to the tooling of data scientists
+func runExportCommand(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "optuna", "Export format - one of optuna (JSON file of trials),mlflow (log runs to a tracking server)")
+	output := flags.String("o", "trials.optuna.json", "File to write the optuna export to")
+	mlflowURL := flags.String("mlflow-url", "http://localhost:5000", "MLflow tracking server")
+	experiment := flags.String("mlflow-experiment", "0", "ID of the MLflow experiment to log the runs in")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s export results.json [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	results, err := readResults(positional[0])
+	if err != nil {
+		log.WithError(err).WithField("file", positional[0]).Fatal("Cannot read results file")
+	}
+
+	switch *format {
+	case "optuna":
+		content, err := json.MarshalIndent(optunaExport(results), "", "  ")
+		if err != nil {
+			log.WithError(err).Fatal("Cannot serialize trials")
+		}
+		if err := os.WriteFile(*output, content, 0644); err != nil {
+			log.WithError(err).WithField("file", *output).Fatal("Cannot write trials")
+		}
+		log.WithFields(log.Fields{"file": *output, "trials": len(results.Trials)}).Info("Wrote trials for optuna")
+	case "mlflow":
+		if err := logToMLflow(results, strings.TrimSuffix(*mlflowURL, "/"), *experiment); err != nil {
+			log.WithError(err).WithField("server", *mlflowURL).Fatal("Cannot log trials to MLflow")
+		}
+		log.WithFields(log.Fields{"server": *mlflowURL, "trials": len(results.Trials)}).Info("Logged trials to MLflow")
+	default:
+		log.WithField("format", *format).Fatal("Unknown export format")
+	}
+}
+
+// trialConfigs returns the complete config every trial measured - the best
+// config at the time with the tried value of one option
+func trialConfigs(results Results) []map[string]string {
+	current := map[string]string{}
+	for _, value := range results.BaselineConfig {
+		current[value.Name] = value.Value
+	}
+	var configs []map[string]string
+	for _, trial := range results.Trials {
+		config := map[string]string{}
+		for name, value := range current {
+			config[name] = value
+		}
+		config[trialParam(trial)] = trial.NewValue
+		configs = append(configs, config)
+		if trial.Accepted {
+			current[trialParam(trial)] = trial.NewValue
+		}
+	}
+	return configs
+}
+
+// trialParam is the parameter name of the option a trial changed
+func trialParam(trial Trial) string {
+	if trial.Class != "" {
+		return trial.Option + "@" + trial.Class
+	}
+	return trial.Option
+}
+
+// Trial in the shape of optuna.trial.create_trial() arguments, distributions
+// are serialized like optuna.distributions.distribution_to_json
+type optunaTrial struct {
+	Number           int                           `json:"number"`
+	State            string                        `json:"state"`
+	Value            *float64                      `json:"value"`
+	Params           map[string]interface{}        `json:"params"`
+	Distributions    map[string]optunaDistribution `json:"distributions"`
+	UserAttrs        map[string]string             `json:"user_attrs"`
+	DatetimeComplete time.Time                     `json:"datetime_complete"`
+}
+
+type optunaDistribution struct {
+	Name       string                 `json:"name"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// optunaExport converts the trials for 'study.add_trials()'. Optuna needs a
+// distribution per parameter, which is derived from the values that were tried:
+// numeric parameters get a float range, all others the set of choices.
+func optunaExport(results Results) (exported []optunaTrial) {
+	configs := trialConfigs(results)
+	values := map[string][]string{}
+	for _, config := range configs {
+		for name, value := range config {
+			values[name] = append(values[name], value)
+		}
+	}
+	distributions := map[string]optunaDistribution{}
+	for name, tried := range values {
+		distributions[name] = optunaDistributionFor(tried)
+	}
+
+	for i, trial := range results.Trials {
+		entry := optunaTrial{
+			Number:           i,
+			State:            "COMPLETE",
+			Params:           map[string]interface{}{},
+			Distributions:    map[string]optunaDistribution{},
+			UserAttrs:        map[string]string{"changed_option": trialParam(trial), "run_id": results.RunID},
+			DatetimeComplete: trial.Timestamp,
+		}
+		if trial.Error != "" {
+			entry.State = "FAIL"
+			entry.UserAttrs["error"] = trial.Error
+		} else {
+			score := trial.Score
+			entry.Value = &score
+		}
+		for name, value := range configs[i] {
+			distribution := distributions[name]
+			entry.Distributions[name] = distribution
+			if distribution.Name == "FloatDistribution" {
+				entry.Params[name], _ = parseQuantity(value, time.Second)
+			} else {
+				entry.Params[name] = value
+			}
+		}
+		exported = append(exported, entry)
+	}
+	return exported
+}
+
+func optunaDistributionFor(tried []string) optunaDistribution {
+	low, high := math.Inf(1), math.Inf(-1)
+	var choices []interface{}
+	seen := map[string]bool{}
+	numeric := true
+	for _, value := range tried {
+		if !seen[value] {
+			seen[value] = true
+			choices = append(choices, value)
+		}
+		number, err := parseQuantity(value, time.Second)
+		if err != nil {
+			numeric = false
+			continue
+		}
+		low, high = math.Min(low, number), math.Max(high, number)
+	}
+	if numeric {
+		return optunaDistribution{Name: "FloatDistribution", Attributes: map[string]interface{}{"low": low, "high": high, "log": false, "step": nil}}
+	}
+	return optunaDistribution{Name: "CategoricalDistribution", Attributes: map[string]interface{}{"choices": choices}}
+}
+
+// logToMLflow creates a parent run for the optimizer run and a nested run
+// with params and score for every trial
+func logToMLflow(results Results, server, experiment string) error {
+	parent, err := createMLflowRun(server, experiment, results.RunID, results.Started, nil)
+	if err != nil {
+		return err
+	}
+	benchmark, _ := json.Marshal(results.Benchmark)
+	err = mlflowCall(server, "runs/log-batch", map[string]interface{}{
+		"run_id": parent,
+		"metrics": []map[string]interface{}{
+			{"key": "baseline_score", "value": results.BaselineScore, "timestamp": results.Started.UnixMilli(), "step": 0},
+			{"key": "best_score", "value": results.BestScore, "timestamp": results.Finished.UnixMilli(), "step": 0},
+		},
+		"params": []map[string]string{{"key": "benchmark", "value": string(benchmark)}},
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	configs := trialConfigs(results)
+	for i, trial := range results.Trials {
+		tags := []map[string]string{{"key": "mlflow.parentRunId", "value": parent}, {"key": "changed_option", "value": trialParam(trial)}}
+		child, err := createMLflowRun(server, experiment, fmt.Sprintf("%s-trial-%d", results.RunID, i+1), trial.Timestamp, tags)
+		if err != nil {
+			return err
+		}
+		var params []map[string]string
+		for name, value := range configs[i] {
+			params = append(params, map[string]string{"key": name, "value": value})
+		}
+		batch := map[string]interface{}{"run_id": child, "params": params}
+		status := "FAILED"
+		if trial.Error == "" {
+			status = "FINISHED"
+			batch["metrics"] = []map[string]interface{}{{"key": "score", "value": trial.Score, "timestamp": trial.Timestamp.UnixMilli(), "step": i + 1}}
+		}
+		if err := mlflowCall(server, "runs/log-batch", batch, nil); err != nil {
+			return err
+		}
+		if err := mlflowCall(server, "runs/update", map[string]interface{}{"run_id": child, "status": status, "end_time": trial.Timestamp.UnixMilli()}, nil); err != nil {
+			return err
+		}
+	}
+	return mlflowCall(server, "runs/update", map[string]interface{}{"run_id": parent, "status": "FINISHED", "end_time": results.Finished.UnixMilli()}, nil)
+}
+
+func createMLflowRun(server, experiment, name string, start time.Time, tags []map[string]string) (id string, err error) {
+	var created struct {
+		Run struct {
+			Info struct {
+				RunID string `json:"run_id"`
+			} `json:"info"`
+		} `json:"run"`
+	}
+	request := map[string]interface{}{"experiment_id": experiment, "run_name": name, "start_time": start.UnixMilli()}
+	if tags != nil {
+		request["tags"] = tags
+	}
+	err = mlflowCall(server, "runs/create", request, &created)
+	return created.Run.Info.RunID, err
+}
+
+// mlflowCall POSTs request to an endpoint of the MLflow REST API and decodes the response into result
+func mlflowCall(server, endpoint string, request interface{}, result interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Post(server+"/api/2.0/mlflow/"+endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s: %s", endpoint, response.Status, strings.TrimSpace(string(content)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(content, result)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Export formats of the best config for applying it outside of the optimizer
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_./:=,@+-]+$`)
+
+// shellQuote quotes text for POSIX shells if it contains special characters
+func shellQuote(text string) string {
+	if shellSafe.MatchString(text) {
+		return text
+	}
+	return "'" + strings.ReplaceAll(text, "'", `'\''`) + "'"
+}
+
+// configScript renders values as shell script of 'ceph config set' commands.
+// Values of targets outside the config database are left as comments.
+func configScript(values []ResultValue) string {
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\n# Best config found by ceph-optimize - review before running\nset -e\n\n")
+	for _, value := range values {
+		if len(value.Sections) == 0 {
+			fmt.Fprintf(&script, "# %s %s = %s is not stored in the config database and has to be applied by hand\n", value.Target, value.Name, value.Value)
+			continue
+		}
+		for _, section := range value.Sections {
+			fmt.Fprintf(&script, "ceph config set %s %s %s\n", shellQuote(section), shellQuote(value.Name), shellQuote(value.Value))
+		}
+	}
+	return script.String()
+}
+
+// assimilateConf renders values as file for 'ceph config assimilate-conf -i'.
+// The format has no way to express masked sections like osd/class:ssd, so
+// those are written as the equivalent config set commands in comments.
+func assimilateConf(values []ResultValue) string {
+	var plain []ResultValue
+	var masked strings.Builder
+	for _, value := range values {
+		var sections []string
+		for _, section := range value.Sections {
+			if strings.Contains(section, "/") {
+				fmt.Fprintf(&masked, "#   ceph config set %s %s %s\n", shellQuote(section), shellQuote(value.Name), shellQuote(value.Value))
+				continue
+			}
+			sections = append(sections, section)
+		}
+		if len(sections) > 0 {
+			value.Sections = sections
+			plain = append(plain, value)
+		}
+	}
+	out := "# Best config found by ceph-optimize - import it with 'ceph config assimilate-conf -i <file>'\n"
+	if masked.Len() > 0 {
+		out += "# Masked sections cannot be imported, set them with:\n" + masked.String()
+	}
+	return out + confSections(plain)
+}
+
+// ansibleOverrides renders values as ceph_conf_overrides variable for ceph-ansible,
+// which writes them to ceph.conf and therefore cannot use masked sections
+func ansibleOverrides(values []ResultValue) string {
+	var sections yaml.MapSlice
+	index := map[string]int{}
+	for _, value := range values {
+		for _, section := range value.Sections {
+			if strings.Contains(section, "/") {
+				log.WithFields(log.Fields{"option": value.Name, "section": section}).Warn("Masked sections cannot be expressed in ceph_conf_overrides - skipping")
+				continue
+			}
+			if _, known := index[section]; !known {
+				index[section] = len(sections)
+				sections = append(sections, yaml.MapItem{Key: section, Value: yaml.MapSlice{}})
+			}
+			options := sections[index[section]].Value.(yaml.MapSlice)
+			sections[index[section]].Value = append(options, yaml.MapItem{Key: value.Name, Value: value.Value})
+		}
+	}
+	content, err := yaml.Marshal(yaml.MapSlice{{Key: "ceph_conf_overrides", Value: sections}})
+	if err != nil {
+		log.WithError(err).Error("Cannot render ceph_conf_overrides")
+		return ""
+	}
+	return "# Best config found by ceph-optimize - add it to group_vars/all.yml\n" + string(content)
+}
+
+// cephadmServiceTypes maps config sections to the cephadm service type
+// whose spec can carry the options in its config section
+var cephadmServiceTypes = map[string]string{"osd": "osd", "mon": "mon", "mgr": "mgr", "mds": "mds", "client.rgw": "rgw"}
+
+// cephadmSpecs renders values as the config sections of cephadm service
+// specs. cephadm sets them for the daemons of the service, so only options
+// set for a whole daemon type can be expressed.
+func cephadmSpecs(values []ResultValue) string {
+	var order []string
+	configs := map[string]yaml.MapSlice{}
+	for _, value := range values {
+		for _, section := range value.Sections {
+			serviceType, known := cephadmServiceTypes[section]
+			if !known {
+				log.WithFields(log.Fields{"option": value.Name, "section": section}).Warn("Section has no cephadm service spec equivalent - skipping")
+				continue
+			}
+			if _, seen := configs[serviceType]; !seen {
+				order = append(order, serviceType)
+			}
+			configs[serviceType] = append(configs[serviceType], yaml.MapItem{Key: value.Name, Value: value.Value})
+		}
+	}
+	out := "# Best config found by ceph-optimize - merge the config sections into your service specs\n"
+	for _, serviceType := range order {
+		content, err := yaml.Marshal(yaml.MapSlice{{Key: "service_type", Value: serviceType}, {Key: "config", Value: configs[serviceType]}})
+		if err != nil {
+			log.WithError(err).Error("Cannot render cephadm service spec")
+			return ""
+		}
+		out += "---\n" + string(content)
+	}
+	return out
+}
+
+// writeExport writes the output of render to file, doing nothing if file is empty
+func writeExport(file, description string, render func([]ResultValue) string, values []ResultValue, mode os.FileMode) {
+	if file == "" {
+		return
+	}
+	if err := os.WriteFile(file, []byte(render(values)), mode); err != nil {
+		log.WithError(err).WithField("file", file).Errorf("Cannot write %s", description)
+		return
+	}
+	log.WithField("file", file).Infof("Wrote %s", description)
+}
+
+// rookPatch renders values as merge patch of the cephConfig of a Rook
+// CephCluster, which Rook applies with 'ceph config set' - including masks
+func rookPatch(values []ResultValue, asJSON bool) string {
+	var sections yaml.MapSlice
+	index := map[string]int{}
+	for _, value := range values {
+		for _, section := range value.Sections {
+			if _, known := index[section]; !known {
+				index[section] = len(sections)
+				sections = append(sections, yaml.MapItem{Key: section, Value: yaml.MapSlice{}})
+			}
+			options := sections[index[section]].Value.(yaml.MapSlice)
+			sections[index[section]].Value = append(options, yaml.MapItem{Key: value.Name, Value: value.Value})
+		}
+	}
+	return rookManifest(yaml.MapSlice{
+		{Key: "apiVersion", Value: "ceph.rook.io/v1"},
+		{Key: "kind", Value: "CephCluster"},
+		{Key: "metadata", Value: yaml.MapSlice{{Key: "name", Value: rookCluster}, {Key: "namespace", Value: rookNamespace}}},
+		{Key: "spec", Value: yaml.MapSlice{{Key: "cephConfig", Value: sections}}},
+	}, "kubectl patch cephcluster "+rookCluster+" -n "+rookNamespace+" --type merge --patch-file <file>", asJSON)
+}
+
+// rookConfigOverride renders values as the rook-config-override ConfigMap,
+// which Rook adds to the ceph.conf of all daemons and therefore cannot use masks
+func rookConfigOverride(values []ResultValue, asJSON bool) string {
+	var plain []ResultValue
+	for _, value := range values {
+		var sections []string
+		for _, section := range value.Sections {
+			if strings.Contains(section, "/") {
+				log.WithFields(log.Fields{"option": value.Name, "section": section}).Warn("Masked sections cannot be expressed in rook-config-override - skipping")
+				continue
+			}
+			sections = append(sections, section)
+		}
+		if len(sections) > 0 {
+			value.Sections = sections
+			plain = append(plain, value)
+		}
+	}
+	return rookManifest(yaml.MapSlice{
+		{Key: "apiVersion", Value: "v1"},
+		{Key: "kind", Value: "ConfigMap"},
+		{Key: "metadata", Value: yaml.MapSlice{{Key: "name", Value: "rook-config-override"}, {Key: "namespace", Value: rookNamespace}}},
+		{Key: "data", Value: yaml.MapSlice{{Key: "config", Value: confSections(plain)}}},
+	}, "kubectl apply -f <file>", asJSON)
+}
+
+// rookManifest serializes a Kubernetes manifest as YAML with a usage comment
+// or as JSON, which has no comments
+func rookManifest(manifest yaml.MapSlice, usage string, asJSON bool) string {
+	if asJSON {
+		content, err := json.MarshalIndent(jsonValue(manifest), "", "  ")
+		if err != nil {
+			log.WithError(err).Error("Cannot render Rook manifest")
+			return ""
+		}
+		return string(content) + "\n"
+	}
+	content, err := yaml.Marshal(manifest)
+	if err != nil {
+		log.WithError(err).Error("Cannot render Rook manifest")
+		return ""
+	}
+	return "# Best config found by ceph-optimize - apply it with '" + usage + "'\n" + string(content)
+}
+
+// jsonValue converts yaml.MapSlice values into maps that encoding/json can serialize
+func jsonValue(value interface{}) interface{} {
+	slice, ok := value.(yaml.MapSlice)
+	if !ok {
+		return value
+	}
+	object := map[string]interface{}{}
+	for _, item := range slice {
+		object[fmt.Sprint(item.Key)] = jsonValue(item.Value)
+	}
+	return object
+}
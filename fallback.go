@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Ceph releases differ in which of config set, tell config set and
+// injectargs they understand and in the exit codes they return, so every
+// way of applying a config option is a step that is tried in order until
+// the cluster accepts the command.
+
+// applyStep is one way of applying a config option to a set of targets
+type applyStep struct {
+	name    string
+	targets func(option *ConfigOption) []string
+	command func(target, name, value string) []string
+}
+
+var (
+	configSetStep = applyStep{
+		name:    "config set",
+		targets: func(option *ConfigOption) []string { return option.configSections() },
+		command: func(target, name, value string) []string { return []string{"config", "set", target, name, value} },
+	}
+	tellConfigSetStep = applyStep{
+		name:    "tell config set",
+		targets: func(option *ConfigOption) []string { return option.tellTargets() },
+		command: func(target, name, value string) []string {
+			return []string{"tell", target, "config", "set", name, value}
+		},
+	}
+	injectargsStep = applyStep{
+		name:    "injectargs",
+		targets: func(option *ConfigOption) []string { return option.tellTargets() },
+		command: func(target, name, value string) []string {
+			return []string{"tell", target, "injectargs", fmt.Sprintf("--%s=%s", name, value)}
+		},
+	}
+)
+
+// storedInDatabase remembers options whose value went to the config database,
+// rollback can only remove overrides that were set there
+var storedInDatabase = map[string]bool{}
+
+// applySteps returns the methods to try for option with the configured --apply-method
+func applySteps(option *ConfigOption) []applyStep {
+	if option.Restart || !option.canInject() {
+		// Only the config database survives the restart
+		return []applyStep{configSetStep}
+	}
+	if applyMethod == "config-set" {
+		return []applyStep{configSetStep, tellConfigSetStep, injectargsStep}
+	}
+	return []applyStep{injectargsStep, tellConfigSetStep}
+}
+
+// applyConfigValue applies a config option with the first method the cluster supports
+func applyConfigValue(option *ConfigOption, value string) error {
+	steps := applySteps(option)
+	if steps[0].name == configSetStep.name {
+		rememberFirstValue(option)
+	}
+	for i, step := range steps {
+		err := step.run(option, value)
+		if err == nil {
+			storedInDatabase[option.key()] = step.name == configSetStep.name
+			return nil
+		}
+		if unsupportedCommand(err) && i < len(steps)-1 {
+			log.WithError(err).Debugf("Cluster does not support %s, falling back to %s", step.name, steps[i+1].name)
+			continue
+		}
+		if unsupportedCommand(err) {
+			return fmt.Errorf("%w: cannot set value %s to %s - the cluster supports none of the apply methods: %v", errTrialFailed, option.Name, value, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// run applies value on all targets of option. It stops at the first
+// target that fails - errors that mean the method is not supported at all
+// are returned as they are, so that the caller can fall back to the next
+// method, all others fail the trial.
+func (step applyStep) run(option *ConfigOption, value string) error {
+	for _, target := range step.targets(option) {
+		output, err := tryCeph(step.command(target, option.Name, value))
+		var commandErr *commandError
+		errors.As(err, &commandErr)
+		switch {
+		case err == nil:
+			if notObserved(output) {
+				log.WithFields(log.Fields{"option": option.Name, "target": target}).Warn("Value is only observed after a restart of the daemon - consider setting restart for this option")
+			}
+		case unsupportedCommand(err):
+			return err
+		case commandErr != nil && notObserved(commandErr.text()):
+			// Older releases fail injectargs with EINVAL when the option
+			// cannot be changed at runtime, even though the value is stored
+			log.WithFields(log.Fields{"option": option.Name, "target": target}).Warn("Value is only observed after a restart of the daemon - consider setting restart for this option")
+		default:
+			return applyError(option.Name, value, target+" with "+step.name, err)
+		}
+	}
+	return nil
+}
+
+// unsupportedCommand reports whether err means that the cluster does not
+// know the command, as opposed to rejecting the option or value
+func unsupportedCommand(err error) bool {
+	var commandErr *commandError
+	if !errors.As(err, &commandErr) {
+		return false
+	}
+	// 95 is EOPNOTSUPP
+	return commandErr.ExitCode == 95 ||
+		strings.Contains(commandErr.text(), "no valid command found") ||
+		strings.Contains(commandErr.text(), "unrecognized command")
+}
+
+// notObserved reports whether Ceph stored a value that the daemon only reads on startup
+func notObserved(output string) bool {
+	return strings.Contains(output, "not observed, change may require restart")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Parameters of the beast frontend that can be tuned with target: frontend.
+// They are part of the rgw_frontends string, num_threads is the thread pool
+// of radosgw that beast uses instead of its own.
+var frontendParams = map[string]ConfigOption{
+	"num_threads":            {Type: "int", Min: 64, Max: 1024},
+	"request_timeout_ms":     {Type: "int", Min: 5000, Max: 120000},
+	"tcp_nodelay":            {Type: "enum", Values: []string{"0", "1"}},
+	"max_connection_backlog": {Type: "int", Min: 128, Max: 4096},
+	"max_header_size":        {Type: "int", Min: 16384, Max: 65536},
+}
+
+// rgw_frontends of every section from before the first change, nil if the
+// section had no override
+var frontendOriginal map[string]*string
+
+// validateFrontendParams checks frontend options and fills in their range.
+// radosgw only reads its frontend settings on start.
+func validateFrontendParams(options []ConfigOption) {
+	for i := range options {
+		option := &options[i]
+		if option.daemonType() != "frontend" {
+			continue
+		}
+		defaults, known := frontendParams[option.Name]
+		if !known {
+			log.WithField("param", option.Name).Fatal("Unsupported beast frontend parameter")
+		}
+		if !restartRGW {
+			log.Fatal("radosgw only reads its frontend settings on start - add --restart-RGW to tune them")
+		}
+		option.Restart = true
+		if option.Type == "" {
+			option.Type = defaults.Type
+		}
+		if option.Type == "enum" && len(option.Values) == 0 {
+			option.Values = defaults.Values
+		}
+		if option.Type != "enum" && option.Min == 0 && option.Max == 0 {
+			option.Min, option.Max = defaults.Min, defaults.Max
+		}
+	}
+}
+
+// frontendSections returns the config sections of the RGW services. cephadm
+// writes rgw_frontends per service, so client.rgw would be overridden.
+func frontendSections() []string {
+	if rgwService != "" {
+		return []string{"client." + rgwService}
+	}
+	var list []orchService
+	if err := cephJSON(&list, []string{"orch", "ls", "rgw"}); err != nil || len(list) == 0 {
+		return []string{"client.rgw"}
+	}
+	var sections []string
+	for _, service := range list {
+		sections = append(sections, "client."+service.ServiceName)
+	}
+	return sections
+}
+
+// frontendParam returns the value of a parameter in an rgw_frontends string
+func frontendParam(frontends, name string) string {
+	for _, field := range strings.Fields(frontends) {
+		if key, value, found := strings.Cut(field, "="); found && key == name {
+			return value
+		}
+	}
+	return ""
+}
+
+// withFrontendParam sets a parameter in an rgw_frontends string
+func withFrontendParam(frontends, name, value string) string {
+	fields := strings.Fields(frontends)
+	for i, field := range fields {
+		if key, _, found := strings.Cut(field, "="); found && key == name {
+			fields[i] = name + "=" + value
+			return strings.Join(fields, " ")
+		}
+	}
+	return strings.Join(append(fields, name+"="+value), " ")
+}
+
+func getFrontendParam(name string) string {
+	section := frontendSections()[0]
+	if name == "num_threads" {
+		output, _ := runCeph([]string{"config", "get", section, "rgw_thread_pool_size"})
+		return strings.TrimSpace(output)
+	}
+	output, _ := runCeph([]string{"config", "get", section, "rgw_frontends"})
+	return frontendParam(strings.TrimSpace(output), name)
+}
+
+// setFrontendParam changes the parameter for all RGW services. They are
+// restarted afterwards by restartIfRequired.
+func setFrontendParam(name, value string) error {
+	rememberFrontends()
+	for _, section := range frontendSections() {
+		if name == "num_threads" {
+			if _, err := runCeph([]string{"config", "set", section, "rgw_thread_pool_size", value}); err != nil {
+				return applyError("rgw_thread_pool_size", value, section, err)
+			}
+			continue
+		}
+		output, _ := runCeph([]string{"config", "get", section, "rgw_frontends"})
+		frontends := withFrontendParam(strings.TrimSpace(output), name, value)
+		if _, err := runCeph([]string{"config", "set", section, "rgw_frontends", frontends}); err != nil {
+			return applyError("rgw_frontends", frontends, section, err)
+		}
+	}
+	return nil
+}
+
+// rememberFrontends records the frontend settings of all RGW services before the first change
+func rememberFrontends() {
+	if frontendOriginal != nil {
+		return
+	}
+	frontendOriginal = map[string]*string{}
+	dump, err := getInitialConfigDump()
+	if err != nil {
+		log.WithError(err).Warn("Cannot read the config database - frontend settings will be removed on restore")
+	}
+	for _, section := range frontendSections() {
+		for _, name := range []string{"rgw_frontends", "rgw_thread_pool_size"} {
+			key := section + "/" + name
+			frontendOriginal[key] = nil
+			for _, entry := range dump {
+				if entry.who() == section && entry.Name == name {
+					value := entry.Value
+					frontendOriginal[key] = &value
+				}
+			}
+		}
+	}
+}
+
+// restoreFrontends restores the frontend settings and restarts radosgw
+func restoreFrontends() {
+	if frontendOriginal == nil {
+		return
+	}
+	log.Info("Restoring RGW frontend settings")
+	for key, value := range frontendOriginal {
+		section, name, _ := strings.Cut(key, "/")
+		var err error
+		if value == nil {
+			_, err = runCeph([]string{"config", "rm", section, name})
+		} else {
+			_, err = runCeph([]string{"config", "set", section, name, *value})
+		}
+		if err != nil {
+			log.WithError(err).Error(fmt.Sprintf("Cannot restore %s of %s", name, section))
+		}
+	}
+	if err := restartRGWs(); err != nil {
+		log.WithError(err).Error("Cannot restart radosgw to restore its frontend settings")
+	}
+}
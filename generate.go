@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Option name prefixes of the subsystems the generate subcommand knows
+var subsystemPrefixes = map[string][]string{
+	"bluestore": {"bluestore_", "bluefs_", "bdev_"},
+	"osd":       {"osd_"},
+	"rgw":       {"rgw_"},
+}
+
+// runGenerateCommand implements the generate subcommand, which writes an
+// options file for a subsystem from the option metadata of the cluster
+func runGenerateCommand(args []string) {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	subsystem := flags.String("subsystem", "", "Subsystem to generate options for - one of bluestore,osd,rgw")
+	output := flags.String("output", "-", "File to write the options to - - writes to stdout")
+	level := flags.String("level", "advanced", "Most detailed option level to include - one of basic,advanced,dev")
+	factor := flags.Float64("range-factor", 0, "Suggest min/max as default/factor and default*factor (0 uses Ceph's own limits)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s generate --subsystem bluestore|osd|rgw [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	prefixes, known := subsystemPrefixes[*subsystem]
+	if !known {
+		flags.Usage()
+		os.Exit(2)
+	}
+	levels := map[string]int{"basic": 0, "advanced": 1, "dev": 2}
+	maxLevel, known := levels[*level]
+	if !known {
+		log.WithField("level", *level).Fatal("Unknown option level")
+	}
+
+	var names []string
+	if err := cephJSON(&names, []string{"config", "ls"}); err != nil {
+		log.WithError(err).Fatal("Cannot list config options of the cluster")
+	}
+	sort.Strings(names)
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.WithError(err).WithField("file", *output).Fatal("Cannot create options file")
+		}
+		defer file.Close()
+		out = file
+	}
+	fmt.Fprintf(out, "# %s options generated by '%s generate' - remove the ones you do not want to tune\n", *subsystem, os.Args[0])
+	count := 0
+	for _, name := range names {
+		if !hasAnyPrefix(name, prefixes) {
+			continue
+		}
+		help, err := getOptionHelp(name)
+		if err != nil {
+			log.WithError(err).WithField("option", name).Warn("Cannot get option metadata - skipping it")
+			continue
+		}
+		if optionLevel, known := levels[help.Level]; known && optionLevel > maxLevel {
+			continue
+		}
+		if entry := generatedEntry(help, *subsystem, *factor); entry != "" {
+			fmt.Fprint(out, entry)
+			count++
+		}
+	}
+	log.WithFields(log.Fields{"subsystem": *subsystem, "options": count}).Info("Generated options file")
+}
+
+func hasAnyPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// generatedEntry renders one option as YAML list entry. Options without a
+// range that can be suggested are written commented out.
+func generatedEntry(help optionHelp, subsystem string, factor float64) string {
+	configType := configTypes[help.Type]
+	if configType == "" && len(help.EnumValues) > 0 {
+		configType = "enum"
+	}
+	if configType == "" {
+		return ""
+	}
+	var lines []string
+	if help.Desc != "" {
+		lines = append(lines, "# "+strings.ReplaceAll(help.Desc, "\n", " "))
+	}
+	entry := []string{"- name: " + help.Name, "  type: " + configType}
+	if subsystem == "rgw" {
+		entry = append(entry, "  target: rgw")
+	}
+	commentOut := false
+	switch configType {
+	case "bool":
+		entry = append(entry, fmt.Sprintf("  startValue: %v", help.Default))
+	case "enum":
+		entry = append(entry, fmt.Sprintf("  startValue: %v", help.Default), "  values: ["+strings.Join(help.EnumValues, ", ")+"]")
+	default:
+		if defaultValue, ok := metadataNumber(help.Default); ok {
+			entry = append(entry, "  startValue: "+formatQuantity(defaultValue))
+		}
+		min, max, ok := help.suggestRange(configType, factor)
+		if !ok || min >= max {
+			lines = append(lines, "# No range can be suggested - set min and max to tune it")
+			commentOut = true
+		}
+		entry = append(entry, "  min: "+formatQuantity(min), "  max: "+formatQuantity(max))
+	}
+	for _, line := range entry {
+		if commentOut {
+			line = "# " + line
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
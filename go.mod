@@ -1,13 +1,14 @@
 module github.com/mulbc/ceph-optimizer
 
-go 1.19
+go 1.25.0
 
 require (
+	github.com/ceph/go-ceph v0.41.0
 	github.com/sirupsen/logrus v1.9.3
 	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
-	github.com/stretchr/testify v1.8.2 // indirect
-	golang.org/x/sys v0.6.0 // indirect
+	github.com/stretchr/testify v1.11.1 // indirect
+	golang.org/x/sys v0.47.0 // indirect
 )
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LinkedOption is a Ceph option whose value is derived from the value of
+// the group it belongs to: parameter*scale+offset, or product/parameter+offset
+// to keep the product of two options constant
+type LinkedOption struct {
+	Name    string  `yaml:"name"`
+	Type    string  `yaml:"type"`  // int or float, taken from Ceph if empty
+	Scale   float64 `yaml:"scale"` // 1 if empty
+	Offset  float64 `yaml:"offset"`
+	Product float64 `yaml:"product"` // used instead of scale if set
+}
+
+// isGroup returns true if the option is a named parameter for linked options
+// rather than a Ceph option itself
+func (option ConfigOption) isGroup() bool {
+	return len(option.Linked) > 0
+}
+
+// cephNames returns the Ceph config options that are changed when the option changes
+func (option ConfigOption) cephNames() []string {
+	if !option.isConfigOption() {
+		return nil
+	}
+	if !option.isGroup() {
+		return []string{option.Name}
+	}
+	var names []string
+	for _, link := range option.Linked {
+		names = append(names, link.Name)
+	}
+	return names
+}
+
+// members returns the linked options of a group, targeted like the group itself
+func (option ConfigOption) members() (members []ConfigOption) {
+	for _, link := range option.Linked {
+		member := ConfigOption{Name: link.Name, Type: link.Type, Restart: option.Restart, Target: option.Target, Class: option.Class}
+		if member.Type == "" {
+			help, err := getOptionHelp(link.Name)
+			if err == nil {
+				member.Type = configTypes[help.Type]
+			}
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+// memberValues derives the value of every member from the group's value
+func (option ConfigOption) memberValues(value string) ([]ConfigOption, []string) {
+	parameter, _ := strconv.ParseFloat(value, 64)
+	members := option.members()
+	values := make([]string, len(members))
+	for i, link := range option.Linked {
+		derived := parameter
+		if link.Product != 0 {
+			derived = link.Product / parameter
+		} else if link.Scale != 0 {
+			derived = parameter * link.Scale
+		}
+		derived += link.Offset
+		if members[i].Type == "int" {
+			derived = math.Round(derived)
+		}
+		values[i] = formatQuantity(derived)
+	}
+	return members, values
+}
+
+// groupValue derives the group's value from the current value of its first
+// member, the inverse of memberValues. It is empty if it cannot be derived.
+func (option ConfigOption) groupValue() string {
+	members := option.members()
+	if len(members) == 0 {
+		return ""
+	}
+	member, err := parseQuantity(getCurrentValueForOption(members[0]), time.Second)
+	if err != nil {
+		return ""
+	}
+	link := option.Linked[0]
+	parameter := member - link.Offset
+	switch {
+	case link.Product != 0 && parameter != 0:
+		parameter = link.Product / parameter
+	case link.Product != 0:
+		return ""
+	case link.Scale != 0:
+		parameter /= link.Scale
+	}
+	if option.Type == "int" {
+		parameter = math.Round(parameter)
+	}
+	return formatQuantity(parameter)
+}
+
+// expandGroups replaces every group with its members
+func expandGroups(options []ConfigOption) (expanded []ConfigOption) {
+	for _, option := range options {
+		if option.isGroup() {
+			expanded = append(expanded, option.members()...)
+		} else {
+			expanded = append(expanded, option)
+		}
+	}
+	return expanded
+}
+
+// validateGroups makes sure every group defines the range of its parameter,
+// since there is no Ceph metadata to derive it from
+func validateGroups(options []ConfigOption) {
+	var problems []string
+	for _, option := range options {
+		if !option.isGroup() {
+			continue
+		}
+		if option.Type != "int" && option.Type != "float" {
+			problems = append(problems, fmt.Sprintf("group %s needs type int or float", option.Name))
+		}
+		if option.Min >= option.Max {
+			problems = append(problems, fmt.Sprintf("group %s needs min lower than max", option.Name))
+		}
+		if option.StartValue == "" {
+			problems = append(problems, fmt.Sprintf("group %s needs a startValue", option.Name))
+		}
+		for _, link := range option.Linked {
+			if link.Name == "" {
+				problems = append(problems, fmt.Sprintf("group %s has a linked option without name", option.Name))
+			}
+			if link.Product != 0 && option.Min <= 0 {
+				problems = append(problems, fmt.Sprintf("group %s uses product for %s and needs a positive min", option.Name, link.Name))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		log.WithField("problems", strings.Join(problems, "; ")).Fatal("Config file contains invalid option groups")
+	}
+}
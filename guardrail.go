@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errHardBound fails the trial of a value beyond the hard bounds of its
+// option, the value is never applied
+var errHardBound = fmt.Errorf("%w: value beyond hard bound", errRejected)
+
+// checkHardBounds returns an error if value crosses the hard bounds of the option
+func (option ConfigOption) checkHardBounds(value string) error {
+	if option.HardMin == nil && option.HardMax == nil {
+		return nil
+	}
+	number, err := parseQuantity(option.cephValue(value), time.Second)
+	if err != nil {
+		return nil
+	}
+	if option.HardMin != nil && number < *option.HardMin {
+		return fmt.Errorf("%s is below the hard minimum %s", value, formatQuantity(*option.HardMin))
+	}
+	if option.HardMax != nil && number > *option.HardMax {
+		return fmt.Errorf("%s is above the hard maximum %s", value, formatQuantity(*option.HardMax))
+	}
+	return nil
+}
+
+// validateHardBounds makes sure the search range and start value of every
+// option stay within its hard bounds, which catches typos in the range
+func validateHardBounds(options []ConfigOption) {
+	var problems []string
+	for _, option := range options {
+		if option.HardMin != nil && option.HardMax != nil && *option.HardMin > *option.HardMax {
+			problems = append(problems, fmt.Sprintf("%s has a hard minimum above its hard maximum", option.Name))
+			continue
+		}
+		if option.Type != "bool" && option.Type != "enum" {
+			if err := option.checkHardBounds(formatQuantity(option.Min)); err != nil {
+				problems = append(problems, fmt.Sprintf("min of %s: %s", option.Name, err))
+			}
+			if err := option.checkHardBounds(formatQuantity(option.Max)); err != nil {
+				problems = append(problems, fmt.Sprintf("max of %s: %s", option.Name, err))
+			}
+		}
+		if option.StartValue != "" {
+			if err := option.checkHardBounds(option.StartValue); err != nil {
+				problems = append(problems, fmt.Sprintf("startValue of %s: %s", option.Name, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		log.WithField("problems", strings.Join(problems, "; ")).Fatal("Options exceed their hard bounds")
+	}
+}
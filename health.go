@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Subset of 'ceph status -f json'
+type cephStatus struct {
+	PGMap struct {
+		NumPGs     int `json:"num_pgs"`
+		PGsByState []struct {
+			StateName string `json:"state_name"`
+			Count     int    `json:"count"`
+		} `json:"pgs_by_state"`
+	} `json:"pgmap"`
+}
+
+// Subset of 'ceph health detail -f json'
+type cephHealth struct {
+	Status string `json:"status"`
+	Checks map[string]struct {
+		Severity string `json:"severity"`
+		Summary  struct {
+			Message string `json:"message"`
+			Count   int    `json:"count"`
+		} `json:"summary"`
+	} `json:"checks"`
+}
+
+// Health checks that report slow or blocked requests - REQUEST_SLOW and
+// REQUEST_STUCK are the names used by older releases
+var slowOpsChecks = []string{"SLOW_OPS", "BLOCKED_OPS", "REQUEST_SLOW", "REQUEST_STUCK"}
+
+func getCephHealth() (health cephHealth, err error) {
+	err = cephJSON(&health, []string{"health", "detail"})
+	return health, err
+}
+
+// watchSlowOps polls the cluster health every slowOpsInterval seconds until
+// stop is closed and then sends the number of slow ops occurrences
+func watchSlowOps(stop <-chan struct{}) <-chan int {
+	result := make(chan int, 1)
+	go func() {
+		occurrences := 0
+		ticker := time.NewTicker(time.Duration(slowOpsInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				result <- occurrences
+				return
+			case <-ticker.C:
+				health, err := getCephHealth()
+				if err != nil {
+					log.WithError(err).Warn("Cannot get cluster health")
+					continue
+				}
+				for _, name := range slowOpsChecks {
+					if check, found := health.Checks[name]; found {
+						log.WithField("check", name).Debug(check.Summary.Message)
+						occurrences++
+					}
+				}
+			}
+		}
+	}()
+	return result
+}
+
+// intentionalBackfill is set while the recovery latency objective backfills
+// on purpose, the inactive PGs it causes do not break the trial
+var intentionalBackfill atomic.Bool
+
+// watchCriticalHealth polls the cluster health every slowOpsInterval seconds
+// until stop is closed and reports the first sign of a broken cluster.
+// Inactive PGs only count once they stay inactive for --inactive-pg-grace
+// seconds outside of an intentional backfill. While memory related options
+// are tuned, OSDs close to running out of memory count as broken as well.
+func watchCriticalHealth(stop <-chan struct{}) <-chan string {
+	problems := make(chan string, 1)
+	go func() {
+		ticker := time.NewTicker(time.Duration(slowOpsInterval) * time.Second)
+		defer ticker.Stop()
+		var inactiveSince time.Time
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				health, err := getCephHealth()
+				if err != nil {
+					log.WithError(err).Warn("Cannot get cluster health")
+					continue
+				}
+				if problem := health.criticalProblem(); problem != "" {
+					problems <- problem
+					return
+				}
+				check, inactive := health.Checks["PG_AVAILABILITY"]
+				switch {
+				case !inactive || intentionalBackfill.Load():
+					inactiveSince = time.Time{}
+				case inactiveSince.IsZero():
+					inactiveSince = time.Now()
+				case time.Since(inactiveSince) >= time.Duration(inactivePGGrace)*time.Second:
+					problems <- fmt.Sprintf("%s for more than %ds", check.Summary.Message, inactivePGGrace)
+					return
+				}
+				if !memoryGuard {
+					continue
+				}
+				if problem := osdMemoryProblem(); problem != "" {
+					problems <- problem
+					return
+				}
+			}
+		}
+	}()
+	return problems
+}
+
+// criticalProblem describes why the cluster is considered broken - empty if
+// it is not. Inactive PGs only count here once Ceph raises them to
+// HEALTH_ERR, watchCriticalHealth gives them a grace period before that.
+func (health cephHealth) criticalProblem() string {
+	if check, found := health.Checks["OSD_DOWN"]; found {
+		return check.Summary.Message
+	}
+	if watchdogSlowOps > 0 {
+		for _, name := range slowOpsChecks {
+			if check, found := health.Checks[name]; found && check.Summary.Count >= watchdogSlowOps {
+				return check.Summary.Message
+			}
+		}
+	}
+	if health.Status != "HEALTH_ERR" {
+		return ""
+	}
+	var messages []string
+	for _, check := range health.Checks {
+		if check.Severity == "HEALTH_ERR" {
+			messages = append(messages, check.Summary.Message)
+		}
+	}
+	sort.Strings(messages)
+	return "HEALTH_ERR: " + strings.Join(messages, "; ")
+}
+
+func getCephStatus() (status cephStatus, err error) {
+	err = cephJSON(&status, []string{"status"})
+	return status, err
+}
+
+// allPGsActiveClean returns true when every PG is in state active+clean
+func (status cephStatus) allPGsActiveClean() bool {
+	clean := 0
+	for _, state := range status.PGMap.PGsByState {
+		if state.StateName == "active+clean" {
+			clean += state.Count
+		}
+	}
+	return clean == status.PGMap.NumPGs
+}
+
+// waitForCleanPGs polls the cluster until all PGs are active+clean
+func waitForCleanPGs(timeout time.Duration) (err error) {
+	span := startSpan("wait for clean PGs")
+	defer func() { span.end(err) }()
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := getCephStatus()
+		if err != nil {
+			log.WithError(err).Warn("Cannot get cluster status")
+		} else if status.allPGsActiveClean() {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("PGs did not become active+clean within %s", timeout)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// waitForHealthy polls the cluster until it reports HEALTH_OK and all PGs are
+// active+clean, so effects of the previous trial do not leak into the next one
+func waitForHealthy(timeout time.Duration) (err error) {
+	span := startSpan("wait for healthy")
+	defer func() { span.end(err) }()
+	deadline := time.Now().Add(timeout)
+	for {
+		health, err := getCephHealth()
+		if err != nil {
+			log.WithError(err).Warn("Cannot get cluster health")
+		} else if health.Status == "HEALTH_OK" {
+			status, err := getCephStatus()
+			if err != nil {
+				log.WithError(err).Warn("Cannot get cluster status")
+			} else if status.allPGsActiveClean() {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cluster did not reach HEALTH_OK with all PGs active+clean within %s", timeout)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Values per option.key() that broke the cluster and are not tried again
+var failedValues = map[string][]string{}
+
+func (option ConfigOption) failed(value string) bool {
+	for _, failed := range failedValues[option.key()] {
+		if failed == value {
+			return true
+		}
+	}
+	return option.blocked(value)
+}
+
+// recoverFromUnhealthyTrial marks value as failed and waits for the abandoned
+// benchmark to end and for the cluster to recover from the reverted change
+func recoverFromUnhealthyTrial(option ConfigOption, value string) error {
+	failedValues[option.key()] = append(failedValues[option.key()], value)
+	log.WithFields(log.Fields{"option": option.Name, "value": value}).Warn("Value broke the cluster - it will not be tried again")
+	if abandonedBenchmark != nil {
+		<-abandonedBenchmark
+		abandonedBenchmark = nil
+	}
+	timeout := time.Duration(restartTimeout) * time.Second
+	deadline := time.Now().Add(timeout)
+	for {
+		health, err := getCephHealth()
+		if err == nil && health.criticalProblem() == "" {
+			if err := waitForCleanPGs(time.Until(deadline)); err != nil {
+				return fmt.Errorf("cluster did not recover from a failed trial: %w", err)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cluster did not recover from a failed trial within %s: %s", timeout, health.criticalProblem())
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
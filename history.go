@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var historyFile *os.File
+var historyWriter *csv.Writer
+
+// Every trial of the run, for the results file
+var trials []Trial
+
+// openHistory creates the CSV file every trial of the run is recorded in.
+// A resumed run appends to the history of the interrupted run.
+func openHistory() {
+	if historyPath == "" {
+		return
+	}
+	var err error
+	if resume != "" {
+		historyFile, err = os.OpenFile(historyPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	} else {
+		historyFile, err = os.Create(historyPath)
+	}
+	if err != nil {
+		log.WithError(err).WithField("file", historyPath).Fatal("Cannot create trial history")
+	}
+	historyWriter = csv.NewWriter(historyFile)
+	if resume != "" {
+		return
+	}
+	writeHistoryRow([]string{"timestamp", "option", "target", "class", "old_value", "new_value", "score", "accepted", "error", "trial_id"})
+}
+
+// recordTrial appends one trial to the history. score is only
+// meaningful if err is nil.
+func recordTrial(option ConfigOption, oldValue, newValue string, score float64, accepted bool, err error) {
+	trial := Trial{ID: trialID, Timestamp: time.Now(), Option: option.Name, Target: option.daemonType(), Class: option.deviceClass(), OldValue: oldValue, NewValue: newValue, Score: score, Accepted: accepted}
+	scoreText := fmt.Sprint(score)
+	if err != nil {
+		trial.Error = err.Error()
+		trial.Unsafe = errors.Is(err, errUnhealthy)
+		trial.Crashes = trialCrashes
+		trial.Score = 0
+		scoreText = ""
+	} else if len(enabledObjectives()) > 1 {
+		trial.Objectives = objectiveValues
+	}
+	trials = append(trials, trial)
+	storeTrial(trial)
+	finishTrialMetrics(trial)
+	pushMetrics()
+	finishTrialAnnotation(trial)
+	if historyWriter == nil {
+		return
+	}
+	writeHistoryRow([]string{trial.Timestamp.Format(time.RFC3339), trial.Option, trial.Target, trial.Class, oldValue, newValue, scoreText, fmt.Sprint(accepted), trial.Error, trial.ID})
+}
+
+// writeHistoryRow writes and flushes a row so the file can be inspected while the run is going
+func writeHistoryRow(row []string) {
+	historyWriter.Write(row)
+	historyWriter.Flush()
+	if err := historyWriter.Error(); err != nil {
+		log.WithError(err).WithField("file", historyPath).Error("Cannot write trial history")
+	}
+}
+
+func closeHistory() {
+	if historyFile != nil {
+		historyFile.Close()
+	}
+}
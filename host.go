@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Host settings of OSD nodes that can be tuned with target: host - sysctls
+// and the block queue settings of the devices backing the OSDs in scope.
+// They are changed over SSH like the admin socket apply method.
+var hostSettings = map[string]ConfigOption{
+	"vm.dirty_ratio":            {Type: "int", Min: 5, Max: 60},
+	"vm.dirty_background_ratio": {Type: "int", Min: 1, Max: 30},
+	"vm.swappiness":             {Type: "int", Min: 0, Max: 100},
+	"read_ahead_kb":             {Type: "int", Min: 0, Max: 4096},
+	"nr_requests":               {Type: "int", Min: 32, Max: 1024},
+	"rq_affinity":               {Type: "enum", Values: []string{"0", "1", "2"}},
+	"scheduler":                 {Type: "enum", Values: []string{"none", "mq-deadline", "kyber", "bfq"}},
+}
+
+// Value of every host setting before the first change, keyed by hostTarget.String()
+var hostSnapshot = map[string]string{}
+var hostSnapshotOrder []hostTarget
+
+// hostTarget is one place a host setting is applied to - a host for
+// sysctls, a device of a host for block queue settings
+type hostTarget struct {
+	host, device, name string
+}
+
+func (target hostTarget) String() string {
+	return target.host + ":" + target.device + ":" + target.name
+}
+
+func isSysctl(name string) bool {
+	return strings.Contains(name, ".")
+}
+
+// validateHostSettings checks host options and fills in their type and
+// range. Host settings are not Ceph settings, so they have to be allowed explicitly.
+func validateHostSettings(options []ConfigOption) {
+	for i := range options {
+		option := &options[i]
+		if option.daemonType() != "host" {
+			continue
+		}
+		defaults, known := hostSettings[option.Name]
+		if !known {
+			log.WithField("setting", option.Name).Fatal("Unsupported host setting")
+		}
+		if !allowHostChanges {
+			log.WithField("setting", option.Name).Fatal("Tuning host settings changes the OSD nodes over SSH - add --allow-host-changes to do it anyway")
+		}
+		if option.Class != "" || option.Restart {
+			log.WithField("setting", option.Name).Fatal("Host settings cannot have a device class or require a restart")
+		}
+		if option.Type == "" {
+			option.Type = defaults.Type
+		}
+		if option.Type == "enum" && len(option.Values) == 0 {
+			option.Values = defaults.Values
+		}
+		if option.Type != "enum" && option.Min == 0 && option.Max == 0 {
+			option.Min, option.Max = defaults.Min, defaults.Max
+		}
+	}
+}
+
+// hostTargets returns every host, or every device of every host, of the
+// OSDs in scope that the setting applies to
+func hostTargets(name string) (targets []hostTarget) {
+	hosts := runtimeOSDHosts()
+	ids := scopedOSDs()
+	if ids == nil {
+		for id := range hosts {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	seen := map[string]bool{}
+	for _, id := range ids {
+		devices := []string{""}
+		if !isSysctl(name) {
+			devices = osdDevices[id]
+		}
+		for _, device := range devices {
+			target := hostTarget{host: hosts[id], device: device, name: name}
+			if !seen[target.String()] {
+				seen[target.String()] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets
+}
+
+// runShellOnHost runs a shell script locally or over SSH
+func runShellOnHost(host, script string) (output string, err error) {
+	if hostname, _ := os.Hostname(); host == hostname || strings.HasPrefix(hostname, host+".") {
+		return executeCommand("sh", []string{"-c", script})
+	}
+	return executeCommand("ssh", []string{host, script})
+}
+
+var selectedScheduler = regexp.MustCompile(`\[(\S+)\]`)
+
+func (target hostTarget) read() string {
+	script := fmt.Sprintf("cat /sys/block/%s/queue/%s", target.device, target.name)
+	if isSysctl(target.name) {
+		script = "sysctl -n " + target.name
+	}
+	output, err := runShellOnHost(target.host, script)
+	if err != nil {
+		log.WithError(err).WithField("target", target.String()).Error("Cannot read host setting")
+		return ""
+	}
+	output = strings.TrimSpace(output)
+	if match := selectedScheduler.FindStringSubmatch(output); match != nil {
+		return match[1]
+	}
+	return output
+}
+
+func (target hostTarget) write(value string) error {
+	script := fmt.Sprintf("echo %s > /sys/block/%s/queue/%s", value, target.device, target.name)
+	if isSysctl(target.name) {
+		script = fmt.Sprintf("sysctl -w %s=%s", target.name, value)
+	}
+	_, err := runShellOnHost(target.host, script)
+	return err
+}
+
+// getHostSetting reads the setting from the first host in scope
+func getHostSetting(name string) string {
+	targets := hostTargets(name)
+	if len(targets) == 0 {
+		return ""
+	}
+	return targets[0].read()
+}
+
+// setHostSetting applies the setting on every host in scope, after
+// recording the original values for restoreHostSettings
+func setHostSetting(name, value string) error {
+	for _, target := range hostTargets(name) {
+		if _, known := hostSnapshot[target.String()]; !known {
+			hostSnapshot[target.String()] = target.read()
+			hostSnapshotOrder = append(hostSnapshotOrder, target)
+		}
+		if err := target.write(value); err != nil {
+			return applyError(name, value, target.String(), err)
+		}
+	}
+	return nil
+}
+
+// restoreHostSettings sets every changed host setting back to its original value
+func restoreHostSettings() {
+	if len(hostSnapshotOrder) > 0 {
+		log.Info("Restoring host settings")
+	}
+	for _, target := range hostSnapshotOrder {
+		if value := hostSnapshot[target.String()]; value != "" {
+			if err := target.write(value); err != nil {
+				log.WithError(err).WithFields(log.Fields{"target": target.String(), "value": value}).Error("Cannot restore host setting")
+			}
+		}
+	}
+}
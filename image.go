@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Creation parameters of the RBD test image that can be tuned with
+// target: image. Object and stripe sizes have to be powers of two, so they
+// are chosen from a list instead of a range.
+var imageProperties = map[string]ConfigOption{
+	"object_size":  {Type: "enum", Values: []string{"1M", "2M", "4M", "8M", "16M"}},
+	"stripe_unit":  {Type: "enum", Values: []string{"64K", "256K", "1M", "4M"}},
+	"stripe_count": {Type: "int", Min: 1, Max: 16},
+	"features": {Type: "enum", Values: []string{
+		"layering",
+		"layering,exclusive-lock",
+		"layering,exclusive-lock,object-map,fast-diff",
+		"layering,exclusive-lock,object-map,fast-diff,deep-flatten",
+	}},
+}
+
+// Image creation parameters of the next test image, keyed by property
+var imageSettings = map[string]string{}
+
+// validateImageProperties checks image options and fills in their type and values
+func validateImageProperties(options []ConfigOption) {
+	for i := range options {
+		option := &options[i]
+		if option.daemonType() != "image" {
+			continue
+		}
+		defaults, known := imageProperties[option.Name]
+		if !known {
+			log.WithField("property", option.Name).Fatal("Unsupported RBD image property")
+		}
+		if benchBackend != "rbd" {
+			log.WithField("property", option.Name).Fatal("RBD image properties can only be tuned with the rbd benchmark backend")
+		}
+		if option.Class != "" || option.Restart {
+			log.WithField("property", option.Name).Fatal("RBD image properties cannot have a device class or require a restart")
+		}
+		if option.Type == "" {
+			option.Type = defaults.Type
+		}
+		if option.Type == "enum" && len(option.Values) == 0 {
+			option.Values = defaults.Values
+		}
+		if option.Type != "enum" && option.Min == 0 && option.Max == 0 {
+			option.Min, option.Max = defaults.Min, defaults.Max
+		}
+	}
+}
+
+// imageCreateArgs returns the 'rbd create' flags for the tuned image properties
+func imageCreateArgs() (arguments []string) {
+	objectSize := imageSettings["object_size"]
+	if objectSize != "" {
+		arguments = append(arguments, "--object-size", objectSize)
+	}
+	if unit := imageSettings["stripe_unit"]; unit != "" {
+		// The stripe unit has to divide the object size
+		if size, err := parseQuantity(objectSize, time.Second); objectSize != "" && err == nil {
+			if unitSize, err := parseQuantity(unit, time.Second); err == nil && unitSize > size {
+				unit = objectSize
+			}
+		}
+		arguments = append(arguments, "--stripe-unit", unit, "--stripe-count", imageSettingOr("stripe_count", "1"))
+	} else if count := imageSettings["stripe_count"]; count != "" {
+		arguments = append(arguments, "--stripe-unit", imageSettingOr("object_size", "4M"), "--stripe-count", count)
+	}
+	if features := imageSettings["features"]; features != "" {
+		arguments = append(arguments, "--image-feature", features)
+	}
+	return arguments
+}
+
+func imageSettingOr(name, fallback string) string {
+	if value := imageSettings[name]; value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Subset of 'rbd info <image> --format json'
+type rbdInfo struct {
+	ObjectSize  int      `json:"object_size"`
+	StripeUnit  int      `json:"stripe_unit"`
+	StripeCount int      `json:"stripe_count"`
+	Features    []string `json:"features"`
+}
+
+// getImageProperty returns the tuned value or the value of the current test image
+func getImageProperty(name string) string {
+	if value, known := imageSettings[name]; known {
+		return value
+	}
+	output, err := runRBD([]string{"info", poolName + "/" + rbdImage, "--format", "json"})
+	var info rbdInfo
+	if err == nil {
+		err = json.Unmarshal([]byte(output), &info)
+	}
+	if err != nil {
+		log.WithError(err).Error("Cannot get info of the RBD test image")
+		return ""
+	}
+	switch name {
+	case "object_size":
+		return imageSize(info.ObjectSize)
+	case "stripe_unit":
+		return imageSize(info.StripeUnit)
+	case "stripe_count":
+		return fmt.Sprint(info.StripeCount)
+	case "features":
+		return strings.Join(info.Features, ",")
+	}
+	return ""
+}
+
+// imageSize formats bytes like the values of object_size and stripe_unit,
+// in whole megabytes where possible
+func imageSize(bytes int) string {
+	if bytes%(1<<20) == 0 {
+		return fmt.Sprintf("%dM", bytes>>20)
+	}
+	return fmt.Sprintf("%dK", bytes>>10)
+}
+
+// setImageProperty recreates the test image with the new creation parameter.
+// If rbd refuses the parameters the value counts as rejected.
+func setImageProperty(name, value string) error {
+	imageSettings[name] = value
+	log.WithField("args", imageCreateArgs()).Debug("Recreating RBD test image")
+	removeRBDImage()
+	if err := setUpRBDImage(); err != nil {
+		return applyError(name, value, "image "+rbdImage, err)
+	}
+	return nil
+}
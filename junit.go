@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     float64         `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitReport judges a run for CI pipelines. It passes if the best score
+// improved by at least --junit-min-improvement percent over the baseline
+// and no trial made the cluster unhealthy.
+func junitReport(results Results) junitTestSuites {
+	suite := junitTestSuite{Name: "ceph-optimize " + results.RunID, Time: results.Finished.Sub(results.Started).Seconds()}
+
+	improvement := junitTestCase{Name: "improvement", ClassName: "ceph-optimize"}
+	percent := 0.0
+	if results.BaselineScore > 0 {
+		percent = (results.BestScore - results.BaselineScore) / results.BaselineScore * 100
+	}
+	improvement.SystemOut = fmt.Sprintf("baseline %.2f, best %.2f, improvement %.1f%%", results.BaselineScore, results.BestScore, percent)
+	if percent < junitMinImprovement {
+		improvement.Failure = &junitFailure{Message: fmt.Sprintf("improvement of %.1f%% is below the required %.1f%%", percent, junitMinImprovement), Text: improvement.SystemOut}
+	}
+
+	health := junitTestCase{Name: "cluster-health", ClassName: "ceph-optimize"}
+	var unhealthy []string
+	for _, trial := range results.Trials {
+		if strings.Contains(trial.Error, errUnhealthy.Error()) {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s = %s: %s", trial.Option, trial.NewValue, trial.Error))
+		}
+	}
+	if len(unhealthy) > 0 {
+		health.Failure = &junitFailure{Message: fmt.Sprintf("%d trials made the cluster unhealthy", len(unhealthy)), Text: strings.Join(unhealthy, "\n")}
+	}
+
+	for _, testCase := range []junitTestCase{improvement, health} {
+		suite.Tests++
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	return junitTestSuites{Suites: []junitTestSuite{suite}}
+}
+
+func writeJUnitReport(results Results) {
+	if junitFile == "" {
+		return
+	}
+	content, err := xml.MarshalIndent(junitReport(results), "", "  ")
+	if err != nil {
+		log.WithError(err).Error("Cannot serialize JUnit report")
+		return
+	}
+	if err := os.WriteFile(junitFile, append([]byte(xml.Header), content...), 0644); err != nil {
+		log.WithError(err).WithField("file", junitFile).Error("Cannot write JUnit report")
+		return
+	}
+	log.WithField("file", junitFile).Info("Wrote JUnit report")
+}
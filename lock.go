@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Concurrent runs against one cluster spoil each other's measurements and
+// rollbacks. A lock file guards the machine and a config-key the cluster.
+// The config-key cannot be taken atomically, but the window between the
+// check and the write is far shorter than a run.
+
+const clusterLockKey = "ceph-optimize/lock"
+
+// runLock identifies the owner of a lock
+type runLock struct {
+	RunID   string    `json:"runId"`
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+}
+
+var lockFileTaken, clusterLockTaken bool
+
+// acquireLocks takes the local and the cluster lock, or exits if another run
+// holds them. With --force the locks of other runs are taken over.
+func acquireLocks() {
+	hostname, _ := os.Hostname()
+	owner := runLock{RunID: runID, Host: hostname, PID: os.Getpid(), Started: time.Now()}
+	content, err := json.Marshal(owner)
+	if err != nil {
+		log.WithError(err).Fatal("Cannot serialize lock")
+	}
+	// The config has to be restored before another run may start
+	log.RegisterExitHandler(func() {
+		restoreSnapshot()
+		releaseLocks()
+	})
+
+	if lockFile != "" {
+		if err := takeLockFile(content); err != nil {
+			log.WithError(err).WithField("file", lockFile).Fatal("Another run is in progress on this machine - use --force if it is gone")
+		}
+		lockFileTaken = true
+	}
+
+	output, err := tryCeph([]string{"config-key", "get", clusterLockKey})
+	var cmdErr *commandError
+	if err != nil && !(errors.As(err, &cmdErr) && cmdErr.ExitCode == int(syscall.ENOENT)) {
+		log.WithError(err).Fatal("Cannot check the cluster lock")
+	}
+	if err == nil {
+		var holder runLock
+		json.Unmarshal([]byte(output), &holder)
+		fields := log.Fields{"runID": holder.RunID, "host": holder.Host, "pid": holder.PID, "started": holder.Started}
+		if !force {
+			releaseLocks()
+			log.WithFields(fields).Fatal("Another run is in progress on this cluster - use --force if it is gone")
+		}
+		log.WithFields(fields).Warn("Taking over the cluster lock of another run")
+	}
+	if _, err := tryCeph([]string{"config-key", "set", clusterLockKey, string(content)}); err != nil {
+		log.WithError(err).Fatal("Cannot take the cluster lock")
+	}
+	clusterLockTaken = true
+}
+
+// takeLockFile creates the lock file exclusively. A lock file of a process
+// that no longer exists, or any lock file with --force, is replaced.
+func takeLockFile(content []byte) error {
+	file, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, os.ErrExist) {
+		existing, _ := os.ReadFile(lockFile)
+		var holder runLock
+		json.Unmarshal(existing, &holder)
+		if !force && holder.PID > 0 && syscall.Kill(holder.PID, 0) == nil {
+			return fmt.Errorf("locked by run %s (pid %d) since %s", holder.RunID, holder.PID, holder.Started.Format(time.RFC3339))
+		}
+		log.WithFields(log.Fields{"file": lockFile, "runID": holder.RunID, "pid": holder.PID}).Warn("Replacing lock file of another run")
+		if err := os.Remove(lockFile); err != nil {
+			return err
+		}
+		file, err = os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(content)
+	return err
+}
+
+// releaseLocks removes the locks this run holds. It is safe to call it multiple times.
+func releaseLocks() {
+	if clusterLockTaken {
+		clusterLockTaken = false
+		// Only remove the lock if no other run took it over with --force
+		if output, err := tryCeph([]string{"config-key", "get", clusterLockKey}); err == nil && strings.Contains(output, strconv.Quote(runID)) {
+			if _, err := tryCeph([]string{"config-key", "rm", clusterLockKey}); err != nil {
+				log.WithError(err).Warn("Cannot release the cluster lock")
+			}
+		}
+	}
+	if lockFileTaken {
+		lockFileTaken = false
+		if err := os.Remove(lockFile); err != nil {
+			log.WithError(err).WithField("file", lockFile).Warn("Cannot remove lock file")
+		}
+	}
+}
@@ -1,166 +1,619 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
-	"os/exec"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
 )
 
 type ConfigOption struct {
 	Name       string
 	Type       string
-	StartValue string
-	Min        float64
-	Max        float64
-}
-
-// Value definition as returned by 'ceph config show osd.0'
-//
-//	in JSON format
-type CurrentConfigValue struct {
-	Name   string
-	Value  string
-	Source string
+	StartValue string         `yaml:"startValue"` // may carry a unit suffix, converted by cephValue
+	Min        float64        `yaml:"-"`          // set from minText, which may carry a unit suffix
+	Max        float64        `yaml:"-"`          // set from maxText, which may carry a unit suffix
+	Restart    bool           `yaml:"restart"`    // the option only takes effect after a daemon restart
+	Target     string         `yaml:"target"`     // daemon type or OSD spec (osd.12, host:node3, class:nvme) the option is applied to, osd if empty
+	Class      string         `yaml:"class"`      // device class OSD options are scoped to, overrides --device-class
+	MinRelease string         `yaml:"minRelease"` // first Ceph release (name or major version) the option is tuned on
+	MaxRelease string         `yaml:"maxRelease"` // last Ceph release (name or major version) the option is tuned on
+	Values     []string       `yaml:"values"`     // choices of enum options, taken from Ceph if empty
+	Linked     []LinkedOption `yaml:"linked"`     // Ceph options derived from this option's value, which makes it a group
+	Classes    []string       `yaml:"classes"`    // device classes that each get their own value of the option
+	HardMin    *float64       `yaml:"-"`          // safety bound no applied value may cross, set from hardMinText
+	HardMax    *float64       `yaml:"-"`          // safety bound no applied value may cross, set from hardMaxText
+
+	minText, maxText, hardMinText, hardMaxText string
 }
 
 var r = rand.New(rand.NewSource(time.Now().UnixNano()))
-var restartOSDs bool
-var configFile, benchType string
-var timeout, confSleep, benchTime, poolPGs, benchScale, benchBlockSize, benchObjectSize int
+var assumeYes, restartOSDs, restartRGW, restartMon, restartMgr, keepBenchObjects, keepBestConfig, allowCrushChanges, allowHostChanges, perClass, orchRedeploy, force, useExistingPool, tui, dashboardBanner, dryRun, noLogFile, quiet, verbose bool
+var cephBinary, radosBinary, rbdBinary, fioBinary, applyMethod, restartMethod, rgwService, deviceClass string
+var poolName, osdList, hostList, crushRule, snapshotFile, resultsFile, latestBestFile, scoreChartFile, paretoFile, historyPath, lockFile, notifyURL, smtpServer, smtpFrom, smtpTo, smtpUser, smtpPassword, junitFile, otlpEndpoint, confSnippetFile, configScriptFile, assimilateConfFile, ansibleFile, cephadmSpecFile, rookPatchFile, rookOverrideFile, rookCluster, metricsListen, pushgateway, grafanaURL, grafanaToken, grafanaDashboard, databasePath, resume, checkpointDir, sqliteBinary, crushBackupFile, clientApplyMethod string
+var cephCluster, cephConf, cephKeyring, cephName, execMode, execPrefix, rookNamespace, rookToolsSelector string
+var logFormat, logPath, logLevel, configFile, preset, benchType, benchBackend, rbdClient, rbdImage, objective string
+var s3Endpoint, s3AccessKey, s3SecretKey, s3Region, s3Bucket, s3Workload string
+var timeout, commandTimeout, benchTimeout, commandRetries, retryDelay, confirmRuns, confSleep, benchTime, poolPGs, benchScale, benchBlockSize, benchObjectSize, rbdImageSize int
+var s3PartSize, s3LargeObjectSize, s3MetadataObjects int
+var recoveryOSD, recoveryTimeout, slowOpsInterval, watchdogSlowOps, inactivePGGrace, restartTimeout, waitHealthy, verifyOSDs, minOSDs int
+var recoveryReweight, crashBlockRange, maxLatencyMs, slowOpsPenalty, prefillPercent, stabilityWeight, autoRangeFactor, memoryHeadroom, memoryGuardPercent, memoryGuardHostPercent, maxScrubbingPercent, junitMinImprovement float64
+var latencyConstraint string
 
 func init() {
 	flag.BoolVar(&restartOSDs, "restart-OSD", false, "Add this to restart OSDs when necessary to apply new configuration")
+	flag.BoolVar(&restartRGW, "restart-RGW", false, "Add this to restart radosgw services through the orchestrator when necessary to apply new configuration")
+	flag.BoolVar(&restartMon, "restart-MON", false, "Add this to do rolling monitor restarts when necessary to apply new configuration")
+	flag.BoolVar(&restartMgr, "restart-MGR", false, "Add this to fail over the active manager when necessary to apply new configuration")
+	flag.StringVar(&rgwService, "rgw-service", "", "Orchestrator service of the radosgw daemons to restart - all rgw services if empty")
+	flag.StringVar(&restartMethod, "restart-method", "auto", "How OSDs and monitors are restarted - one of auto (orch if an orchestrator is available, systemctl otherwise),systemctl,cephadm,orch")
+	flag.BoolVar(&orchRedeploy, "orch-redeploy", false, "Redeploy daemons instead of restarting them with the orch restart method, which also regenerates their container configuration")
+	flag.IntVar(&restartTimeout, "restart-timeout", 600, "Seconds to wait for a restarted OSD to come up and for PGs to become active+clean")
+	flag.StringVar(&snapshotFile, "snapshot-file", "config-snapshot.json", "Where to save the values of all options from before the run")
+	flag.StringVar(&resultsFile, "results", "results.json", "Where to write the results of the run - use it with the apply subcommand. {run} in this and the other output paths is replaced by the run ID")
+	flag.StringVar(&paretoFile, "pareto-front", "pareto.json", "Where to write the Pareto front of runs with several objectives - CSV if it ends with .csv, disabled if empty")
+	flag.StringVar(&latestBestFile, "latest-best", "best-config.latest.yaml", "Where to save the best config found so far whenever it improves - disabled if empty")
+	flag.StringVar(&scoreChartFile, "score-chart", "score.svg", "Where to write the SVG chart of the score per trial - disabled if empty")
+	flag.StringVar(&confSnippetFile, "conf-snippet", "best-config.conf", "Where to write the best config as ceph.conf fragment - empty to disable")
+	flag.StringVar(&configScriptFile, "config-script", "best-config.sh", "Where to write the best config as shell script of 'ceph config set' commands - empty to disable")
+	flag.StringVar(&assimilateConfFile, "assimilate-conf", "", "Where to write the best config for import with 'ceph config assimilate-conf' - disabled if empty")
+	flag.StringVar(&ansibleFile, "ansible-overrides", "", "Where to write the best config as ceph-ansible ceph_conf_overrides YAML - disabled if empty")
+	flag.StringVar(&rookPatchFile, "rook-patch", "", "Where to write the best config as merge patch of the cephConfig of the Rook CephCluster - JSON if it ends with .json, YAML otherwise, disabled if empty")
+	flag.StringVar(&rookOverrideFile, "rook-config-override", "", "Where to write the best config as Rook rook-config-override ConfigMap - JSON if it ends with .json, YAML otherwise, disabled if empty")
+	flag.StringVar(&rookCluster, "rook-cluster", "rook-ceph", "Name of the Rook CephCluster for --rook-patch")
+	flag.StringVar(&cephadmSpecFile, "cephadm-spec", "", "Where to write the best config as config sections of cephadm service specs - disabled if empty")
+	flag.StringVar(&logPath, "log-file", "debug.log", "File all log lines up to --log-level are appended to")
+	flag.StringVar(&logLevel, "log-level", "debug", "Most detailed level written to the log file - one of panic,fatal,error,warning,info,debug,trace")
+	flag.BoolVar(&noLogFile, "no-log-file", false, "Do not write a log file")
+	flag.BoolVar(&quiet, "q", false, "Quiet - only print errors and the best config on stdout")
+	flag.BoolVar(&verbose, "v", false, "Verbose - also print debug messages like every executed command on stdout")
+	flag.StringVar(&logFormat, "log-format", "text", "Format of log lines on stdout and in debug.log - one of text,json")
+	flag.BoolVar(&dashboardBanner, "dashboard-banner", false, "Show that a run is in progress and its current state as banner in the Ceph dashboard")
+	flag.BoolVar(&dryRun, "dry-run", false, "Validate the options against the cluster and print the planned benchmark and sample trials without changing anything")
+	flag.BoolVar(&tui, "tui", false, "Show a live view of the run in the terminal instead of log lines")
+	flag.StringVar(&smtpServer, "smtp-server", "", "SMTP server as host:port to email the report to --smtp-to when the run finishes or aborts - disabled if empty")
+	flag.StringVar(&smtpFrom, "smtp-from", "ceph-optimize@localhost", "Sender address of report emails")
+	flag.StringVar(&smtpTo, "smtp-to", "", "Comma separated recipients of report emails")
+	flag.StringVar(&smtpUser, "smtp-user", "", "User to authenticate at the SMTP server - no authentication if empty")
+	flag.StringVar(&smtpPassword, "smtp-password", os.Getenv("SMTP_PASSWORD"), "Password of --smtp-user, defaults to $SMTP_PASSWORD")
+	flag.StringVar(&notifyURL, "notify-url", "", "Webhook (Slack compatible) that gets JSON POSTs on run start, new best configs, failures and completion")
+	flag.StringVar(&junitFile, "junit", "", "Where to write a JUnit XML verdict of the run for CI pipelines - disabled if empty")
+	flag.Float64Var(&junitMinImprovement, "junit-min-improvement", 0, "Improvement over the baseline in percent the JUnit verdict requires to pass")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP endpoint to export trial, benchmark and command spans to, like http://collector:4318 - disabled if empty")
+	flag.StringVar(&historyPath, "history", "history.csv", "CSV file every trial of the run is recorded in - empty to disable")
+	flag.StringVar(&resume, "resume", "", "Run ID of an interrupted run to continue from its checkpoint")
+	flag.StringVar(&checkpointDir, "checkpoint-dir", ".", "Directory the search state is saved to after every trial, for --resume")
+	flag.StringVar(&metricsListen, "metrics-listen", "", "Address to serve Prometheus metrics about the run on, like :9925 - disabled if empty")
+	flag.StringVar(&pushgateway, "pushgateway", "", "URL of a Prometheus Pushgateway to push the run metrics to after every trial, like http://pushgateway:9091")
+	flag.StringVar(&grafanaURL, "grafana-url", "", "URL of a Grafana to annotate every trial in, like http://grafana:3000 - disabled if empty")
+	flag.StringVar(&grafanaToken, "grafana-token", "", "Service account token for the Grafana annotations API")
+	flag.StringVar(&grafanaDashboard, "grafana-dashboard", "", "UID of the dashboard the annotations are added to - organization wide annotations if empty")
+	flag.StringVar(&databasePath, "database", "", "SQLite database the trials of all runs accumulate in - empty to disable")
+	flag.StringVar(&sqliteBinary, "sqlite-bin", "sqlite3", "sqlite3 CLI used to write the results database - looked up in PATH unless it is a path")
+	flag.StringVar(&crushBackupFile, "crushmap-backup", "crushmap.backup", "Where to save the CRUSH map before tuning CRUSH tunables")
+	flag.BoolVar(&allowCrushChanges, "allow-crush-changes", false, "Allow tuning CRUSH tunables (target: crush), which moves data in the whole cluster")
+	flag.BoolVar(&allowHostChanges, "allow-host-changes", false, "Allow tuning sysctls and block device settings of the OSD nodes (target: host) over SSH")
+	flag.BoolVar(&keepBestConfig, "keep-best-config", false, "Leave the best config applied when the search ends instead of restoring the snapshot")
 	flag.StringVar(&configFile, "conf", "test.yaml", "Location of the config file listing ceph config options to try out")
+	flag.StringVar(&preset, "preset", "", "Tune a built-in option set (bluestore) - options from --conf are added only if it is given explicitly. Options that cannot change at runtime need --restart-OSD")
+	flag.Float64Var(&memoryGuardPercent, "memory-guard", 150, "Revert a trial of memory related options once an OSD uses more than this percentage of its osd_memory_target - 0 disables it")
+	flag.Float64Var(&memoryGuardHostPercent, "memory-guard-host", 90, "Revert a trial of memory related options once the OSDs of a host use more than this percentage of its memory - 0 disables it")
+	flag.Float64Var(&memoryHeadroom, "memory-headroom", 20, "Percentage of host memory kept free for the OS and OSDs overshooting osd_memory_target when capping its range")
+	flag.BoolVar(&assumeYes, "yes", false, "Do not ask for confirmation before changing the cluster and creating and deleting the benchmark pool - needed when stdin is not a terminal")
+	flag.BoolVar(&force, "force", false, "Start the run even if preflight checks of the cluster fail or another run holds the lock")
+	flag.StringVar(&lockFile, "lock-file", "ceph-optimize.lock", "Lock file that prevents concurrent runs from this machine - disabled if empty")
+	flag.IntVar(&minOSDs, "min-osds", 3, "Minimum number of OSDs that need to be up and in for a run")
+	flag.Float64Var(&maxScrubbingPercent, "max-scrubbing-percent", 10, "Refuse to start while more than this percentage of PGs is scrubbing")
+	flag.Float64Var(&autoRangeFactor, "auto-range-factor", 0, "For options without min/max search between default/factor and default*factor (0 uses Ceph's own limits)")
 	flag.IntVar(&timeout, "timeout", 30, "Numbers of unsuccessful optimization attempts until stopping")
+	flag.IntVar(&confirmRuns, "confirm-runs", 1, "Benchmark runs of the best config after the search to confirm its score - 0 to skip")
 	flag.IntVar(&confSleep, "conf-sleep", 2, "Seconds to wait after applying the a new config option")
+	flag.IntVar(&verifyOSDs, "verify-osds", 3, "Number of random OSDs a new value is read back from before benchmarking - 0 disables the check")
+	flag.IntVar(&waitHealthy, "wait-healthy", 0, "Seconds to wait for HEALTH_OK and all PGs active+clean before each benchmark - 0 does not wait")
 	flag.IntVar(&benchTime, "bench-time", 30, "Benchmark length in seconds")
-	flag.IntVar(&poolPGs, "pool-pgs", 64, "pg_num and pgp_num to use for testbench pool creation")
+	flag.IntVar(&commandTimeout, "command-timeout", 300, "Seconds after which a hung Ceph CLI call is killed - 0 disables the timeout")
+	flag.IntVar(&commandRetries, "command-retries", 4, "How often a Ceph command that reads or sets cluster state is retried after a transient error (like a mon election) before the trial is given up - benchmarks are never retried")
+	flag.IntVar(&retryDelay, "retry-delay", 2, "Seconds to wait before the first retry of a failed command - doubled for every further retry up to a minute")
+	flag.IntVar(&benchTimeout, "bench-timeout", 600, "Seconds a benchmark may run longer than --bench-time before it is killed - 0 disables the timeout")
+	flag.StringVar(&poolName, "pool-name", "testbench", "Name of the pool created for the benchmark - a pool that already exists is only used with --use-existing-pool and never deleted")
+	flag.BoolVar(&useExistingPool, "use-existing-pool", false, "Benchmark in the pool named by --pool-name if it already exists - pool properties tuned in it are restored after the run")
+	flag.IntVar(&poolPGs, "pool-pgs", 64, "pg_num and pgp_num to use for benchmark pool creation")
 	flag.StringVar(&benchType, "bench-type", "write", "Benchmark type - one of write,seq,rand")
 	flag.IntVar(&benchScale, "bench-scale", 4, "Number of concurrent IOs in benchmark")
 	flag.IntVar(&benchBlockSize, "bench-block-size", 4000, "Benchmark Block IO size in KB")
 	flag.IntVar(&benchObjectSize, "bench-object-size", 4000, "Benchmark Object IO size in KB")
+	flag.StringVar(&cephCluster, "cluster", "", "Name of the Ceph cluster to connect to")
+	flag.StringVar(&cephConf, "ceph-conf", "", "ceph.conf to use for all Ceph commands")
+	flag.StringVar(&cephKeyring, "keyring", "", "Keyring to use for all Ceph commands")
+	flag.StringVar(&cephName, "name", "", "Client name (e.g. client.admin) to use for all Ceph commands")
+	flag.StringVar(&execMode, "exec-mode", "local", "How Ceph CLIs are run - one of local,cephadm (via 'cephadm shell'),rook (via 'kubectl exec' into the toolbox pod),prefix (via --exec-prefix)")
+	flag.StringVar(&execPrefix, "exec-prefix", "", "Command prepended to every Ceph CLI invocation with --exec-mode prefix, e.g. 'sudo podman exec ceph-tools'")
+	flag.StringVar(&rookNamespace, "rook-namespace", "rook-ceph", "Kubernetes namespace of the Rook toolbox pod with --exec-mode rook")
+	flag.StringVar(&rookToolsSelector, "rook-tools-selector", "app=rook-ceph-tools", "Label selector of the Rook toolbox pod with --exec-mode rook")
+	flag.StringVar(&cephBinary, "ceph-bin", "ceph", "ceph CLI to run - looked up in PATH unless it is a path")
+	flag.StringVar(&radosBinary, "rados-bin", "rados", "rados CLI to run - looked up in PATH unless it is a path")
+	flag.StringVar(&rbdBinary, "rbd-bin", "rbd", "rbd CLI to run - looked up in PATH unless it is a path")
+	flag.StringVar(&fioBinary, "fio-bin", "fio", "fio binary to run - looked up in PATH unless it is a path")
+	flag.StringVar(&applyMethod, "apply-method", "injectargs", "How option values are applied - one of injectargs,config-set,admin-socket. injectargs and config-set fall back to tell config set when the cluster does not support them")
+	flag.StringVar(&clientApplyMethod, "client-apply-method", "config-set", "How client options (target: client) are applied - one of config-set (client section of the config database),args (command line of the benchmark clients only)")
+	flag.BoolVar(&perClass, "per-class", false, "Tune every OSD option separately for each device class in the cluster")
+	flag.StringVar(&deviceClass, "device-class", "", "Only apply OSD options to OSDs of this device class (e.g. ssd or hdd)")
+	flag.StringVar(&osdList, "osds", "", "Comma separated list of OSD ids to restrict all OSD changes to")
+	flag.StringVar(&hostList, "hosts", "", "Comma separated list of hosts whose OSDs all OSD changes are restricted to")
+	flag.StringVar(&crushRule, "crush-rule", "", "CRUSH rule for the benchmark pool - use a rule that only maps to the tuned OSDs to restrict measurements to them")
+	flag.BoolVar(&keepBenchObjects, "keep-bench-objects", false, "Keep the objects written by each benchmark instead of cleaning them up after every trial")
+	flag.Float64Var(&prefillPercent, "prefill-percent", 0, "Write data until the raw cluster utilization reaches this percentage before the search starts")
+	flag.StringVar(&benchBackend, "bench-backend", "rados", "Benchmark backend - one of rados,rbd,rgw")
+	flag.StringVar(&rbdClient, "rbd-client", "librbd", "RBD client stack used by the rbd backend - one of krbd,librbd")
+	flag.StringVar(&rbdImage, "rbd-image", "testimage", "Name of the RBD image created in the benchmark pool by the rbd backend")
+	flag.IntVar(&rbdImageSize, "rbd-image-size", 10240, "Size of the RBD test image in MB")
+	flag.StringVar(&s3Endpoint, "s3-endpoint", "http://localhost:7480", "radosgw endpoint used by the rgw backend")
+	flag.StringVar(&s3AccessKey, "s3-access-key", os.Getenv("AWS_ACCESS_KEY_ID"), "S3 access key used by the rgw backend")
+	flag.StringVar(&s3SecretKey, "s3-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "S3 secret key used by the rgw backend")
+	flag.StringVar(&s3Region, "s3-region", "us-east-1", "S3 region (zonegroup) used to sign requests")
+	flag.StringVar(&s3Bucket, "s3-bucket", "testbench", "Bucket created for the rgw backend")
+	flag.StringVar(&s3Workload, "s3-workload", "put", "S3 workload profile - one of put,get,multipart,large-get,metadata")
+	flag.IntVar(&s3PartSize, "s3-part-size", 8, "Part size in MB for the multipart and large-get S3 profiles")
+	flag.IntVar(&s3LargeObjectSize, "s3-large-object-size", 64, "Object size in MB for the multipart and large-get S3 profiles")
+	flag.IntVar(&s3MetadataObjects, "s3-metadata-objects", 10000, "Number of small objects created in the bucket for the metadata S3 profile")
+	flag.StringVar(&objective, "objective", "iops", "What to optimize for - one of iops,recovery-latency")
+	flag.IntVar(&recoveryOSD, "recovery-osd", 0, "OSD that is reweighted to trigger backfill for the recovery-latency objective")
+	flag.Float64Var(&recoveryReweight, "recovery-reweight", 0.8, "Reweight applied to the recovery OSD during the recovery-latency benchmark")
+	flag.IntVar(&recoveryTimeout, "recovery-timeout", 1800, "Seconds to wait for PGs to become active+clean after a recovery-latency benchmark")
+	flag.Float64Var(&maxLatencyMs, "max-latency-ms", 0, "Treat trials whose tail latency exceeds this many milliseconds as failed (0 disables the constraint)")
+	flag.StringVar(&latencyConstraint, "latency-constraint", "max", "Latency compared against --max-latency-ms - one of max,p99 (p99 falls back to max for rados bench)")
+	flag.Float64Var(&stabilityWeight, "stability-weight", 0, "Weight of the IOPS coefficient of variation as secondary objective - the score is IOPS*(1-weight*cv)")
+	flag.Float64Var(&slowOpsPenalty, "slow-ops-penalty", 0, "Score penalty per health poll that reports slow or blocked requests during a benchmark (0 disables polling)")
+	flag.IntVar(&slowOpsInterval, "slow-ops-interval", 5, "Seconds between health polls during a benchmark")
+	flag.Float64Var(&crashBlockRange, "crash-block-range", 5, "Percent of an option's range around a value that crashed daemons that is not tried again")
+	flag.IntVar(&watchdogSlowOps, "watchdog-slow-ops", 100, "Abort the benchmark and revert the trial once this many ops are slow - 0 disables it")
+	flag.IntVar(&inactivePGGrace, "inactive-pg-grace", 60, "Seconds PGs may stay inactive during a benchmark before the trial counts as broken - peering after a change is expected")
 }
 
 func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "apply" || os.Args[1] == "unapply") {
+		runApplyCommand(os.Args[1], os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		runGenerateCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		runReportCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		runExportCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		runCompareCommand(os.Args[2:])
+		return
+	}
 	flag.Parse()
-	// Create a new logger for writing logs to a file
-	logFile, err := os.OpenFile("debug.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	fileLevel, err := log.ParseLevel(logLevel)
 	if err != nil {
-		log.Fatal(err)
+		log.WithField("logLevel", logLevel).Fatal("Unknown log level")
 	}
-	defer logFile.Close()
 	log.SetOutput(ioutil.Discard) // Send all logs to nowhere by default
+	// Added first so the writers below see the IDs
+	log.AddHook(correlationHook{})
+	if !noLogFile {
+		// Create a new logger for writing logs to a file
+		logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot open log file %s, logging to stdout only: %s\n", logPath, err)
+		} else {
+			defer logFile.Close()
+			log.AddHook(&WriterHook{ // Send logs up to --log-level to the log file
+				Writer:    logFile,
+				LogLevels: levelsUpTo(fileLevel),
+			})
+		}
+	}
 
-	log.AddHook(&WriterHook{ // Send logs with level higher than warning to stderr
-		Writer: logFile,
-		LogLevels: []log.Level{
-			log.PanicLevel,
-			log.FatalLevel,
-			log.ErrorLevel,
-			log.WarnLevel,
-			log.InfoLevel,
-			log.DebugLevel,
-		},
-	})
-	log.AddHook(&WriterHook{ // Send info and debug logs to stdout
-		Writer: os.Stdout,
-		LogLevels: []log.Level{
-			log.PanicLevel,
-			log.FatalLevel,
-			log.ErrorLevel,
-			log.WarnLevel,
-			log.InfoLevel,
-		},
+	var console io.Writer = os.Stdout
+	if tui {
+		console = startTUI()
+	}
+	consoleLevel := log.InfoLevel
+	switch {
+	case quiet && verbose:
+		log.Fatal("-q and -v cannot be combined")
+	case quiet:
+		consoleLevel = log.ErrorLevel
+	case verbose:
+		consoleLevel = log.DebugLevel
+	}
+	log.AddHook(&WriterHook{ // Send logs up to info, error with -q or debug with -v to stdout
+		Writer:    console,
+		LogLevels: levelsUpTo(consoleLevel),
 	})
-	// Set the logger instance to be used globally
-	// log.SetOutput(io.MultiWriter(logFile, os.Stdout)) // Writes logs to both file and stdout
-	log.SetLevel(log.DebugLevel) // Set the global log level to Debug
-
-	optionsFile, _ := os.ReadFile(configFile)
+	// Entries are only passed to the hooks up to the global level
+	if fileLevel > consoleLevel {
+		log.SetLevel(fileLevel)
+	} else {
+		log.SetLevel(consoleLevel)
+	}
+	if notifyURL != "" {
+		log.AddHook(notifyHook{})
+	}
+	if smtpServer != "" {
+		log.AddHook(mailHook{})
+	}
+	switch logFormat {
+	case "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.WithField("logFormat", logFormat).Fatal("Unknown log format")
+	}
 
 	var optionList []ConfigOption
-	var bestConfig []CurrentConfigValue
+	var bestOptionValues []ResultValue
 	var highestScore float64 = 0
+	started := time.Now()
+	runID = newRunID(started)
 
-	if err := yaml.Unmarshal([]byte(optionsFile), &optionList); err != nil {
-		log.WithError(err).Fatal("Unmarshal error for config list")
+	if preset != "" {
+		presetOptions, err := loadPreset(preset)
+		if err != nil {
+			log.WithError(err).Fatal("Cannot load preset")
+		}
+		optionList = presetOptions
+	}
+	if preset == "" || flagGiven("conf") {
+		fileOptions, err := loadOptionsFile(configFile)
+		if err != nil {
+			log.WithError(err).Fatal("Cannot load config options")
+		}
+		optionList = append(optionList, fileOptions...)
 	}
 
 	if len(optionList) == 0 {
 		log.WithField("options", optionList).Fatal("You need to supply at least one config option")
 		return
 	}
+	if benchBackend != "rados" && benchBackend != "rbd" && benchBackend != "rgw" {
+		log.WithField("backend", benchBackend).Fatal("Unknown benchmark backend")
+	}
+	switch applyMethod {
+	case "injectargs", "config-set", "admin-socket":
+	default:
+		log.WithField("applyMethod", applyMethod).Fatal("Unknown apply method")
+	}
+	switch clientApplyMethod {
+	case "config-set", "args":
+	default:
+		log.WithField("clientApplyMethod", clientApplyMethod).Fatal("Unknown client apply method")
+	}
+	switch execMode {
+	case "local", "cephadm":
+	case "rook":
+		if applyMethod == "admin-socket" {
+			log.Fatal("--apply-method admin-socket is not supported with --exec-mode rook")
+		}
+		if _, err := rookToolsPod(); err != nil {
+			log.WithError(err).Fatal("Cannot find a running Rook toolbox pod")
+		}
+	case "prefix":
+		if execPrefix == "" {
+			log.Fatal("--exec-mode prefix needs --exec-prefix")
+		}
+	default:
+		log.WithField("execMode", execMode).Fatal("Unknown exec mode")
+	}
+	switch restartMethod {
+	case "auto", "systemctl", "cephadm", "orch":
+	default:
+		log.WithField("restartMethod", restartMethod).Fatal("Unknown restart method")
+	}
+	if prefillPercent < 0 || prefillPercent >= 85 {
+		log.WithField("prefillPercent", prefillPercent).Fatal("Prefill percentage must be between 0 and the default nearfull ratio of 85")
+	}
+	if memoryHeadroom < 0 || memoryHeadroom >= 100 {
+		log.WithField("memoryHeadroom", memoryHeadroom).Fatal("Memory headroom must be a percentage between 0 and 100")
+	}
+	if latencyConstraint != "max" && latencyConstraint != "p99" {
+		log.WithField("latencyConstraint", latencyConstraint).Fatal("Unknown latency constraint")
+	}
+	if objective != "iops" && objective != "recovery-latency" {
+		log.WithField("objective", objective).Fatal("Unknown objective")
+	}
+	switch s3Workload {
+	case "put", "get", "multipart", "large-get", "metadata":
+	default:
+		log.WithField("workload", s3Workload).Fatal("Unknown S3 workload profile")
+	}
+	if benchBackend == "rgw" {
+		for name, value := range map[string]int{"bench-time": benchTime, "s3-part-size": s3PartSize, "s3-metadata-objects": s3MetadataObjects} {
+			if value <= 0 {
+				log.WithField(name, value).Fatalf("--%s must be above 0 for the rgw backend", name)
+			}
+		}
+	}
+	if rbdClient != "krbd" && rbdClient != "librbd" {
+		log.WithField("client", rbdClient).Fatal("Unknown RBD client stack")
+	}
+	if (osdList != "" || hostList != "") && crushRule == "" {
+		log.Warn("Changes are restricted to a subset of OSDs but the benchmark pool spans the whole cluster - use --crush-rule to restrict measurements as well")
+	}
+	preflight()
+	optionList = expandClasses(optionList)
+	validateTargets(optionList)
+	validateGroups(optionList)
+	validatePoolProperties(optionList)
+	validateCrushOptions(optionList)
+	validateImageProperties(optionList)
+	validateClientOptions(optionList)
+	validateHostSettings(optionList)
+	validateNumaOptions(optionList)
+	validateFrontendParams(optionList)
+	optionList = checkOptionsExist(optionList)
+	convertMillisecondBounds(optionList)
+	populateFromMetadata(optionList)
+	capMemoryTarget(optionList)
+	memoryGuard = guardsMemory(optionList)
+	if memoryGuard {
+		log.WithFields(log.Fields{"osdPercent": memoryGuardPercent, "hostPercent": memoryGuardHostPercent}).Info("Watching OSD memory during trials of memory related options")
+	}
+	validateOptionTypes(optionList)
+	validateHardBounds(optionList)
+	detectRestartRequired(optionList)
 	printConfigOptionList(optionList)
+	if dryRun {
+		printDryRun(optionList)
+		return
+	}
+
+	var resumed *checkpoint
+	if resume != "" {
+		state := loadCheckpoint(resume, optionList)
+		resumed = &state
+		runID, started = state.RunID, state.Started
+	}
+	confirmRun(optionList, resumed)
+	acquireLocks()
+	if resumed != nil {
+		discardBenchmarkData(resumed)
+	}
+
+	expandRunID(&snapshotFile, &resultsFile, &historyPath, &latestBestFile, &scoreChartFile, &paretoFile, &junitFile, &crushBackupFile,
+		&confSnippetFile, &configScriptFile, &assimilateConfFile, &ansibleFile, &cephadmSpecFile, &rookPatchFile, &rookOverrideFile)
 
 	setUpCephPool()
+	if prefillPercent > 0 {
+		if err := prefillCluster(); err != nil {
+			abortRun(err)
+		}
+	}
 
-	for _, option := range optionList {
-		setValueToStart(&option)
+	// Options that are not tuned but must be set for the tuned ones to take effect
+	dependencies := mclockDependencies(optionList)
+	var overrides []configDumpEntry
+	if resumed == nil {
+		environment = captureEnvironment()
+		overrides = reportOverrides(optionList)
+		if err := takeSnapshot(expandGroups(append(optionList, dependencies...))); err != nil {
+			abortRun(err)
+		}
+		if err := backupCrushMap(expandGroups(optionList)); err != nil {
+			abortRun(err)
+		}
+	} else {
+		overrides = resumed.Overrides
+		environment = resumed.Environment
 	}
+	restoreSnapshotOnExit()
+	log.WithField("runID", runID).Info("Search state is saved after every trial - continue an interrupted run with --resume <run ID>")
+	openHistory()
+	openDatabase(started)
+	serveMetrics()
+	notify("started", fmt.Sprintf("run started tuning %d options", len(optionList)), map[string]interface{}{"options": optionKeys(optionList), "benchmark": benchmarkParameters()})
+	startDashboardBanner(len(optionList))
+
+	var baselineScore float64
+	var baselineConfig []ResultValue
+	startNoNewBest := 0
+	if resumed == nil {
+		for _, option := range append(dependencies, optionList...) {
+			if err := setValueToStart(&option); err != nil {
+				abortRun(fmt.Errorf("cannot set start value of %s: %w", option.Name, err))
+			}
+		}
 
-	for noNewBest := 0; noNewBest < timeout; noNewBest++ {
+		var err error
+		baselineScore, err = getScore()
+		if err != nil {
+			if !errors.Is(err, errTrialFailed) {
+				abortRun(fmt.Errorf("cannot get baseline score: %w", err))
+			}
+			log.WithError(err).Warn("Baseline does not meet the constraints - any passing trial will be better")
+			baselineScore = 0
+		}
+		log.WithField("score", baselineScore).Infof("Baseline Avg IOPs %d", int(baselineScore))
+		highestScore = baselineScore
+		baselineConfig = bestValues(expandGroups(append(optionList, dependencies...)))
+		bestOptionValues = baselineConfig
+		writeLatestBest(highestScore, baselineScore, bestOptionValues)
+	} else {
+		// The values may have been restored when the run was interrupted
+		for _, option := range append(dependencies, optionList...) {
+			if value, known := currentValues[option.key()]; known {
+				if err := setValue(&option, value); err != nil {
+					abortRun(fmt.Errorf("cannot restore value of %s: %w", option.Name, err))
+				}
+			}
+		}
+		baselineScore, baselineConfig = resumed.BaselineScore, resumed.BaselineConfig
+		highestScore, bestOptionValues = resumed.HighestScore, resumed.BestOptionValues
+		startNoNewBest = resumed.NoNewBest
+	}
+
+	interimResults = func() Results {
+		return Results{
+			RunID:          runID,
+			Started:        started,
+			Finished:       time.Now(),
+			Benchmark:      benchmarkParameters(),
+			Objectives:     enabledObjectives(),
+			Environment:    environment,
+			BaselineScore:  baselineScore,
+			BaselineConfig: baselineConfig,
+			Trials:         trials,
+			BestScore:      highestScore,
+			BestConfig:     bestOptionValues,
+		}
+	}
+	for noNewBest := startNoNewBest; noNewBest < timeout; noNewBest++ {
+		saveCheckpoint(checkpoint{
+			RunID:            runID,
+			Started:          started,
+			OptionKeys:       optionKeys(optionList),
+			NoNewBest:        noNewBest,
+			HighestScore:     highestScore,
+			BaselineScore:    baselineScore,
+			BaselineConfig:   baselineConfig,
+			BestOptionValues: bestOptionValues,
+			Trials:           trials,
+			Overrides:        overrides,
+		})
+		if stopRequested() {
+			shutdown()
+		}
+		setScoreMetrics(baselineScore, highestScore)
+		logProgress(noNewBest, started)
+		dashboardProgress(noNewBest, baselineScore, highestScore)
 		option := getRandOption(optionList)
 		oldValue := getCurrentValueForOption(option)
 		newValue := findNewValueForOption(option)
-		setValue(&option, newValue)
-		log.Debugf("Setting %s to %s - old value was %s", option.Name, newValue, oldValue)
-
-		newScore, err := getScore()
+		for attempt := 0; attempt < 10 && option.failed(newValue); attempt++ {
+			newValue = findNewValueForOption(option)
+		}
+		newValue = constrainMClock(option, newValue)
+		trialID = newUUID()
+		startTrialMetrics(option, newValue, noNewBest)
+		startTrialAnnotation(option, oldValue, newValue)
+		trialSpan := startSpan("trial", "trial_id", trialID, "option", option.Name, "value", newValue, "old_value", oldValue)
+		trialLog := log.WithFields(log.Fields{"trial": len(trials) + 1, "option": option.Name, "value": newValue})
+		crashes := startCrashWatch()
+		mark := undoMark()
+		err := setValue(&option, newValue)
+		trialLog.Debugf("Setting %s to %s - old value was %s", option.Name, newValue, oldValue)
+
+		var newScore float64
+		if err == nil {
+			err = verifyValue(option, newValue)
+		}
+		if err == nil {
+			newScore, err = getScore()
+		}
+		if err == nil || errors.Is(err, errTrialFailed) {
+			if crashErr := crashes.check(option); crashErr != nil {
+				err = crashErr
+			}
+		}
+		if errors.Is(err, errTrialFailed) {
+			recordTrial(option, oldValue, newValue, newScore, false, err)
+			trialLog.WithError(err).WithField("unsafe", errors.Is(err, errUnhealthy)).Warn("Trial failed - reverting")
+			rollbackTo(mark)
+			if errors.Is(err, errRejected) {
+				avoidRejectedRegion(option, newValue)
+			}
+			if errors.Is(err, errUnhealthy) {
+				notify("attention", fmt.Sprintf("cluster became unhealthy with %s = %s - the value was reverted", option.Name, newValue), map[string]interface{}{"option": option.Name, "value": newValue, "error": err.Error()})
+				if recoverErr := recoverFromUnhealthyTrial(option, newValue); recoverErr != nil {
+					abortRun(recoverErr)
+				}
+				if errors.Is(err, errCrashed) {
+					blockNearbyValues(option, newValue)
+				}
+			}
+			trialSpan.end(err)
+			trialID = ""
+			time.Sleep(time.Duration(confSleep) * time.Second)
+			continue
+		}
 		if err != nil {
-			log.WithError(err).Fatal("Cannot get new score - exiting")
+			abortRun(fmt.Errorf("cannot get new score: %w", err))
 		}
+		recordTrial(option, oldValue, newValue, newScore, newScore > highestScore, nil)
 		if newScore > highestScore {
 			highestScore = newScore
-			log.Info("Found new best config!")
-			log.WithFields(log.Fields{"tunedOption": option.Name, "newValue": newValue}).Infof("New Avg IOPs %d", int(highestScore))
-			bestConfig = getCurrentConfig()
+			trialLog.WithField("score", newScore).Info("Found new best config!")
+			notify("new-best", fmt.Sprintf("new best score %.2f with %s = %s", newScore, option.Name, newValue), map[string]interface{}{"option": option.Name, "value": newValue, "score": newScore, "baselineScore": baselineScore})
+			trialLog.WithField("score", newScore).Infof("New Avg IOPs %d", int(highestScore))
+			bestOptionValues = bestValues(expandGroups(append(optionList, dependencies...)))
+			writeLatestBest(highestScore, baselineScore, bestOptionValues)
 			noNewBest = 0
 		} else {
-			log.Info("No new best config")
-			setValue(&option, oldValue)
+			trialLog.WithField("score", newScore).Info("No new best config")
+			rollbackTo(mark)
 		}
+		trialSpan.end(nil)
+		trialID = ""
 		time.Sleep(time.Duration(confSleep) * time.Second)
 	}
 	log.Infof("Search has ended after %d tries without finding a better config", timeout)
-	printBestConfig(bestConfig)
-	removeCephPool()
-}
-
-func getCurrentConfig() []CurrentConfigValue {
-	output, err := executeCommand("/usr/bin/ceph", strings.Split("config show osd.0 -f json", " "))
-	if err != nil {
-		log.WithError(err).Error("Cannot execute ceph command to get current config for OSD.0")
-		return []CurrentConfigValue{}
+	confirmedScore := confirmScore()
+	stopTUI()
+	printBestConfig(bestOptionValues)
+	diff := configDiff(bestOptionValues)
+	printConfigDiff(diff)
+	closeHistory()
+	results := Results{
+		RunID:                runID,
+		Started:              started,
+		Finished:             time.Now(),
+		Benchmark:            benchmarkParameters(),
+		Objectives:           enabledObjectives(),
+		Topology:             clusterTopology(),
+		Environment:          environment,
+		BaselineScore:        baselineScore,
+		BaselineConfig:       baselineConfig,
+		Trials:               trials,
+		BestScore:            highestScore,
+		BestConfig:           bestOptionValues,
+		ConfirmedScore:       confirmedScore,
+		ConfigDiff:           diff,
+		PreexistingOverrides: overrides,
 	}
-	var currentConfig []CurrentConfigValue
-
-	if err := json.Unmarshal([]byte(output), &currentConfig); err != nil {
-		log.WithError(err).Error("Cannot get current config of OSD.0 as template")
-		return []CurrentConfigValue{}
+	if !quiet {
+		writeSummary(os.Stdout, results)
+	}
+	writeResults(results)
+	writeScoreChart(results)
+	writeParetoFront(results)
+	writeJUnitReport(results)
+	mailReport(results)
+	finishRun(results)
+	removeCheckpoint(runID)
+	releaseLocks()
+	clearDashboardBanner()
+	flushSpans()
+	notify("finished", fmt.Sprintf("run finished after %d trials with best score %.2f (baseline %.2f)", len(trials), highestScore, baselineScore), map[string]interface{}{"bestScore": highestScore, "baselineScore": baselineScore, "bestConfig": bestOptionValues})
+	removeCephPool()
+	if !keepBestConfig {
+		restoreSnapshot()
 	}
-	return currentConfig
 }
 
-func getCurrentValueForOption(option ConfigOption) (value string) {
-	output, err := executeCommand("/usr/bin/ceph", []string{"config", "get", "osd.0", option.Name})
-	if err != nil {
-		log.WithError(err).Errorf("Cannot execute ceph command to get current value for %s", option.Name)
-		return ""
-	}
-	return output
+// flagGiven returns true if the flag was set on the command line
+func flagGiven(name string) (given bool) {
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
 }
 
 func getRandOption(options []ConfigOption) ConfigOption {
@@ -168,106 +621,121 @@ func getRandOption(options []ConfigOption) ConfigOption {
 	return options[randomIndex]
 }
 
+// findNewValueForOption draws a random value of the option. Integer ranges
+// include Max, so 0..1 flags are tried with both values.
 func findNewValueForOption(option ConfigOption) (value string) {
 	if option.Type == "bool" {
 		return fmt.Sprint(r.Intn(2) == 0)
 	}
+	if option.Type == "enum" {
+		return option.Values[r.Intn(len(option.Values))]
+	}
 	valueRange := option.Max - option.Min
-	// check if Max or Min are actually integer
-	if option.Max == float64(int64(option.Max)) && option.Min == float64(int64(option.Min)) {
-		return fmt.Sprint(r.Int63n(int64(valueRange)) + int64(option.Min))
+	if valueRange <= 0 {
+		return formatQuantity(option.Min)
 	}
-	return fmt.Sprint(option.Min + r.Float64()*(option.Max-option.Min))
-}
-
-func setValue(option *ConfigOption, value string) {
-	_, err := executeCommand("/usr/bin/ceph", []string{"tell", "osd.*", "injectargs", fmt.Sprintf("--%s=%s", option.Name, value)})
-	if err != nil {
-		log.WithError(err).Errorf("Issues setting value %s to %s", option.Name, value)
+	// check if Max or Min are actually integer
+	if valueRange <= maxSampledInt && option.Max == float64(int64(option.Max)) && option.Min == float64(int64(option.Min)) {
+		return fmt.Sprint(r.Int63n(int64(valueRange)+1) + int64(option.Min))
 	}
+	return formatQuantity(option.Min + r.Float64()*(option.Max-option.Min))
 }
 
-func setValueToStart(option *ConfigOption) {
-	if option.StartValue == "" {
-		return
+func poolCreateArgs() []string {
+	createArgs := []string{"osd", "pool", "create", poolName, fmt.Sprint(poolPGs), fmt.Sprint(poolPGs)}
+	if crushRule != "" {
+		createArgs = append(createArgs, "replicated", crushRule)
 	}
-	setValue(option, option.StartValue)
+	return createArgs
 }
 
+// setUpCephPool creates the benchmark pool. An existing pool of the same
+// name is only benchmarked in with --use-existing-pool and is kept after the run.
 func setUpCephPool() {
-	executeCommand("/usr/bin/ceph", []string{"osd", "pool", "create", "testbench", fmt.Sprint(poolPGs), fmt.Sprint(poolPGs)})
-	executeCommand("/usr/bin/ceph", strings.Split("osd pool application enable testbench rbd", " "))
-}
-func removeCephPool() {
-	executeCommand("/usr/bin/ceph", strings.Split("tell mon.* injectargs --mon_allow_pool_delete true", " "))
-	executeCommand("/usr/bin/ceph", strings.Split("osd pool delete testbench testbench --yes-i-really-really-mean-it", " "))
-}
-
-func getScore() (number float64, err error) {
-	output, err := executeCommand("/usr/bin/rados", []string{"bench", "-p", "testbench", fmt.Sprint(benchTime), "write", "-t", fmt.Sprint(benchScale), "-b", fmt.Sprint(benchBlockSize * 1024), "-O", fmt.Sprint(benchObjectSize * 1024)})
+	exists, err := poolExists(poolName)
 	if err != nil {
-		log.WithError(err).Error("Error getting score!")
+		log.WithError(err).Fatal("Cannot list pools")
 	}
-
-	// Define the string to search for
-	searchString := "Average IOPS"
-
-	// Regex to match integers and float values
-	pattern := `[-+]?[0-9]*\.?[0-9]+`
-	re := regexp.MustCompile(pattern)
-
-	// Create a scanner to read the output line by line
-	scanner := bufio.NewScanner(strings.NewReader(output))
-
-	// Iterate through each line of the output
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// Check if the line contains the desired string
-		if strings.Contains(line, searchString) {
-			match := re.FindString(line)
-			number, err := strconv.ParseFloat(match, 64)
-			if err != nil {
-				log.WithError(err).Error("Error extracting score")
-			}
-			return number, nil
+	switch {
+	case exists && !useExistingPool:
+		log.WithField("pool", poolName).Fatal("Benchmark pool already exists - choose another --pool-name or use --use-existing-pool to benchmark in it")
+	case exists && prefillPercent > 0:
+		log.WithField("pool", poolName).Fatal("Prefill writes data that is never removed from an existing pool - drop --prefill-percent or choose another --pool-name")
+	case exists:
+		log.WithField("pool", poolName).Warn("Benchmarking in the existing pool - it is kept after the run")
+	default:
+		if _, err := runCeph(poolCreateArgs()); err != nil {
+			log.WithError(err).Fatal("Cannot create the benchmark pool")
 		}
+		poolCreated = true
 	}
-
-	// Check for any scanner errors
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+	if _, err := runCeph([]string{"osd", "pool", "application", "enable", poolName, "rbd"}); err != nil {
+		abortRun(fmt.Errorf("cannot enable the rbd application on the benchmark pool: %w", err))
+	}
+	switch benchBackend {
+	case "rbd":
+		if err := setUpRBDImage(); err != nil {
+			abortRun(err)
+		}
+	case "rgw":
+		if err := setUpS3Bucket(); err != nil {
+			abortRun(err)
+		}
 	}
-	return 0, fmt.Errorf("could not find score in output")
+}
+func removeCephPool() {
+	switch benchBackend {
+	case "rbd":
+		removeRBDImage()
+	case "rgw":
+		removeS3Bucket()
+	}
+	if !poolCreated {
+		return
+	}
+	deleteBenchmarkPool()
+	poolCreated = false
 }
 
-func executeCommand(command string, arguments []string) (output string, err error) {
-	// Execute the command
-	cmd := exec.Command(command, arguments...)
-
-	// Capture the output
-	cmdoutput, err := cmd.Output()
-	if err != nil && fmt.Sprint(err) != "exit status 22" {
-		log.WithError(err).WithField("stdOut", cmdoutput).Fatalf("Issues executing command %s %s", command, strings.Join(arguments, " "))
-		return "", err
+func deleteBenchmarkPool() {
+	if _, err := runCeph(strings.Split("tell mon.* injectargs --mon_allow_pool_delete true", " ")); err != nil {
+		log.WithError(err).Warn("Cannot allow pool deletion")
+	}
+	if _, err := runCeph([]string{"osd", "pool", "delete", poolName, poolName, "--yes-i-really-really-mean-it"}); err != nil {
+		log.WithError(err).Error("Cannot delete the benchmark pool - remove it manually")
 	}
-	return string(cmdoutput), nil
 }
 
 func printConfigOptionList(options []ConfigOption) {
 	var names []string
 	for _, option := range options {
+		if class := option.deviceClass(); class != "" {
+			names = append(names, option.Name+"@"+class)
+			continue
+		}
 		names = append(names, option.Name)
 	}
 	log.WithField("options", names).Info("All config options that will be used to optimize Ceph")
 }
 
-func printBestConfig(configOptions []CurrentConfigValue) {
-	out := ""
-	for _, option := range configOptions {
-		out += fmt.Sprintf("%s = %s\n", option.Name, option.Value)
+// printBestConfig logs the winning values of the tuned options and writes
+// them in the export formats that are enabled
+func printBestConfig(values []ResultValue) {
+	log.Debugf("Best config is:\n%s", confSections(values))
+	if quiet {
+		fmt.Print(confSections(values))
 	}
-	log.Infof("Best config is:\n%s", out)
+	writeExport(confSnippetFile, "best config as ceph.conf snippet", confSections, values, 0644)
+	writeExport(assimilateConfFile, "best config for assimilate-conf", assimilateConf, values, 0644)
+	writeExport(ansibleFile, "best config as ceph-ansible ceph_conf_overrides", ansibleOverrides, values, 0644)
+	writeExport(cephadmSpecFile, "best config as cephadm service spec config", cephadmSpecs, values, 0644)
+	writeExport(rookPatchFile, "best config as Rook CephCluster patch", func(values []ResultValue) string {
+		return rookPatch(values, strings.HasSuffix(rookPatchFile, ".json"))
+	}, values, 0644)
+	writeExport(rookOverrideFile, "best config as Rook config override ConfigMap", func(values []ResultValue) string {
+		return rookConfigOverride(values, strings.HasSuffix(rookOverrideFile, ".json"))
+	}, values, 0644)
+	writeExport(configScriptFile, "best config as config set script", configScript, values, 0755)
 }
 
 // WriterHook is a hook that writes logs of specified LogLevels to specified Writer
@@ -287,6 +755,16 @@ func (hook *WriterHook) Fire(entry *log.Entry) error {
 	return err
 }
 
+// levelsUpTo returns level and all more severe levels
+func levelsUpTo(level log.Level) (levels []log.Level) {
+	for _, candidate := range log.AllLevels {
+		if candidate <= level {
+			levels = append(levels, candidate)
+		}
+	}
+	return levels
+}
+
 // Levels define on which log levels this hook would trigger
 func (hook *WriterHook) Levels() []log.Level {
 	return hook.LogLevels
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// writeMarkdownReport writes a summary of results for tickets and merge requests
+func writeMarkdownReport(out io.Writer, results Results) {
+	failures := map[string]int{}
+	failed := 0
+	for _, trial := range results.Trials {
+		if trial.Error != "" {
+			failed++
+			failures[fmt.Sprintf("%s = %s: %s", trial.Option, trial.NewValue, trial.Error)]++
+		}
+	}
+
+	fmt.Fprintf(out, "## ceph-optimize run %s\n\n", results.RunID)
+	fmt.Fprintf(out, "- Benchmark: %s %s on %s for %ds with %d concurrent IOs\n", results.Benchmark.Objective, results.Benchmark.Type, results.Benchmark.Backend, results.Benchmark.Time, results.Benchmark.Scale)
+	fmt.Fprintf(out, "- Trials: %d (%d failed)\n", len(results.Trials), failed)
+	fmt.Fprintf(out, "- Baseline score: %.2f\n", results.BaselineScore)
+	fmt.Fprintf(out, "- Best score: %.2f", results.BestScore)
+	if results.BaselineScore > 0 {
+		fmt.Fprintf(out, " (%+.1f%% vs baseline)", (results.BestScore-results.BaselineScore)/results.BaselineScore*100)
+	}
+	fmt.Fprint(out, "\n")
+	if results.ConfirmedScore > 0 {
+		fmt.Fprintf(out, "- Confirmed score: %.2f\n", results.ConfirmedScore)
+	}
+	fmt.Fprint(out, "\n")
+
+	fmt.Fprint(out, "### Best config\n\n")
+	fmt.Fprint(out, "| Option | Target | Sections | Value |\n|---|---|---|---|\n")
+	for _, value := range results.BestConfig {
+		fmt.Fprintf(out, "| %s | %s | %s | `%s` |\n", markdownCell(value.Name), value.Target, markdownCell(strings.Join(value.Sections, ", ")), markdownCell(value.Value))
+	}
+
+	if list := contributions(results); len(list) > 0 {
+		fmt.Fprint(out, "\n### Contribution per option\n\n")
+		fmt.Fprint(out, "| Option | Accepted changes | Score gain | Share of improvement |\n|---|---|---|---|\n")
+		for _, entry := range list {
+			fmt.Fprintf(out, "| %s | %d | %+.2f | %.1f%% |\n", markdownCell(entry.Option), entry.Accepted, entry.Gain, entry.Share)
+		}
+	}
+
+	var crashed []Trial
+	for _, trial := range results.Trials {
+		if len(trial.Crashes) > 0 {
+			crashed = append(crashed, trial)
+		}
+	}
+	if len(crashed) > 0 {
+		fmt.Fprint(out, "\n### Crashes\n\n")
+		for _, trial := range crashed {
+			fmt.Fprintf(out, "- %s = `%s`:\n", trial.Option, trial.NewValue)
+			for _, crash := range trial.Crashes {
+				fmt.Fprintf(out, "  - %s\n", crash)
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		fmt.Fprint(out, "\n### Failed trials\n\n")
+		var reasons []string
+		for reason := range failures {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			if failures[reason] > 1 {
+				fmt.Fprintf(out, "- %s (%d times)\n", reason, failures[reason])
+			} else {
+				fmt.Fprintf(out, "- %s\n", reason)
+			}
+		}
+	}
+}
+
+// markdownCell escapes text for a Markdown table cell
+func markdownCell(text string) string {
+	return strings.ReplaceAll(text, "|", `\|`)
+}
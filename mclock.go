@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The mClock scheduler ignores its reservation, weight and limit parameters
+// unless osd_mclock_profile is custom. Reservations of all classes share the
+// OSD's capacity and every limit has to be at least the class' reservation.
+
+const mclockPrefix = "osd_mclock_scheduler_"
+
+// mclockParam splits osd_mclock_scheduler_client_res into client and res
+func mclockParam(name string) (class, param string) {
+	if !strings.HasPrefix(name, mclockPrefix) {
+		return "", ""
+	}
+	rest := strings.TrimPrefix(name, mclockPrefix)
+	separator := strings.LastIndex(rest, "_")
+	if separator < 0 {
+		return "", ""
+	}
+	class, param = rest[:separator], rest[separator+1:]
+	if param != "res" && param != "wgt" && param != "lim" {
+		return "", ""
+	}
+	return class, param
+}
+
+// mclockDependencies returns osd_mclock_profile fixed to custom if any of the
+// custom scheduler parameters are tuned, so their changes take effect
+func mclockDependencies(options []ConfigOption) (dependencies []ConfigOption) {
+	var tuned *ConfigOption
+	for i, option := range options {
+		if option.Name == "osd_mclock_profile" {
+			for _, other := range options {
+				if class, _ := mclockParam(other.Name); class != "" {
+					log.Fatal("osd_mclock_profile and the custom mClock parameters cannot be tuned together - the parameters only apply to the custom profile")
+				}
+			}
+			return nil
+		}
+		if class, _ := mclockParam(option.Name); class != "" && tuned == nil {
+			tuned = &options[i]
+		}
+	}
+	if tuned == nil {
+		return nil
+	}
+	log.Info("Switching osd_mclock_profile to custom to tune the mClock parameters")
+	return []ConfigOption{{Name: "osd_mclock_profile", Type: "enum", StartValue: "custom", Target: tuned.Target, Class: tuned.Class}}
+}
+
+// mclockValue returns the current numeric value of a scheduler parameter
+// for the same daemons as option
+func mclockValue(option ConfigOption, class, param string) float64 {
+	sibling := option
+	sibling.Name = mclockPrefix + class + "_" + param
+	value, _ := strconv.ParseFloat(getCurrentValueForOption(sibling), 64)
+	return value
+}
+
+// constrainMClock adjusts a new value of a custom mClock parameter so the
+// scheduler accepts the combination. Since Reef reservations are fractions
+// of the OSD's capacity, so together they can not exceed 1. A limit of 0
+// means no limit.
+func constrainMClock(option ConfigOption, value string) string {
+	class, param := mclockParam(option.Name)
+	if class == "" {
+		return value
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+	switch param {
+	case "res":
+		if limit := mclockValue(option, class, "lim"); limit > 0 && number > limit {
+			number = limit
+		}
+		if cephRelease >= cephReleases["reef"] {
+			var others float64
+			for _, otherClass := range []string{"client", "background_recovery", "background_best_effort"} {
+				if otherClass != class {
+					others += mclockValue(option, otherClass, "res")
+				}
+			}
+			if number+others > 1 {
+				number = math.Max(0, 1-others)
+			}
+		}
+	case "lim":
+		if reservation := mclockValue(option, class, "res"); number != 0 && number < reservation {
+			number = reservation
+		}
+	}
+	if option.Type == "int" {
+		number = math.Round(number)
+	}
+	if constrained := formatQuantity(number); constrained != value {
+		log.WithFields(log.Fields{"option": option.Name, "value": value, "constrained": constrained}).Debug("Adjusted mClock parameter to keep the reservations and limits consistent")
+		return constrained
+	}
+	return value
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// memoryTargetCeiling returns the largest osd_memory_target that fits
+// every host with OSDs in scope: the host's memory without the headroom
+// for the OS and for OSDs overshooting their target, shared by all OSDs on it
+func memoryTargetCeiling() (ceiling float64, host string) {
+	hosts, err := getOSDHosts()
+	if err != nil {
+		log.WithError(err).Fatal("Cannot get the hosts of the OSDs")
+	}
+	osdsPerHost := map[string]int{}
+	for _, hostname := range hosts {
+		osdsPerHost[hostname]++
+	}
+	ids := scopedOSDs()
+	if ids == nil {
+		for id := range hosts {
+			ids = append(ids, id)
+		}
+	}
+	ceiling = math.Inf(1)
+	for _, id := range ids {
+		hostname := hosts[id]
+		memory, known := hostMemory[hostname]
+		if !known {
+			continue
+		}
+		if perOSD := memory * (1 - memoryHeadroom/100) / float64(osdsPerHost[hostname]); perOSD < ceiling {
+			ceiling, host = perOSD, hostname
+		}
+	}
+	return ceiling, host
+}
+
+// capMemoryTarget lowers the range of osd_memory_target so the search never
+// proposes a value that oversubscribes the RAM of an OSD host
+func capMemoryTarget(options []ConfigOption) {
+	for i := range options {
+		option := &options[i]
+		if option.Name != "osd_memory_target" || option.daemonType() != "osd" {
+			continue
+		}
+		ceiling, host := memoryTargetCeiling()
+		if math.IsInf(ceiling, 1) {
+			log.Warn("Cannot get the memory of the OSD hosts - osd_memory_target is not capped")
+			return
+		}
+		ceiling = math.Floor(ceiling)
+		log.WithFields(log.Fields{"ceiling": formatQuantity(ceiling), "host": host, "headroom": memoryHeadroom}).Info("Computed osd_memory_target ceiling from host memory")
+		if option.Min > ceiling {
+			log.WithFields(log.Fields{"min": formatQuantity(option.Min), "ceiling": formatQuantity(ceiling)}).Fatal("Minimum of osd_memory_target does not fit into the memory of the OSD hosts")
+		}
+		if option.Max > ceiling {
+			log.WithFields(log.Fields{"max": formatQuantity(option.Max), "ceiling": formatQuantity(ceiling)}).Warn("Capping maximum of osd_memory_target to the host memory")
+			option.Max = ceiling
+		}
+		if start, err := parseQuantity(option.StartValue, time.Second); err == nil && start > ceiling {
+			log.WithFields(log.Fields{"startValue": option.StartValue, "ceiling": formatQuantity(ceiling)}).Warn("Capping start value of osd_memory_target to the host memory")
+			option.StartValue = formatQuantity(ceiling)
+		}
+	}
+}
+
+// memoryGuard is true when the run tunes options that change how much
+// memory the OSDs use, see guardsMemory
+var memoryGuard bool
+
+// Name fragments of options that change the memory use of the OSDs
+var memoryOptionFragments = []string{"memory", "cache", "pg_log", "mempool", "buffer"}
+
+// guardsMemory returns true if any of the options (or the options it is
+// linked to) can make the OSDs use more memory
+func guardsMemory(options []ConfigOption) bool {
+	if memoryGuardPercent <= 0 && memoryGuardHostPercent <= 0 {
+		return false
+	}
+	for _, option := range options {
+		names := []string{option.Name}
+		for _, member := range option.Linked {
+			names = append(names, member.Name)
+		}
+		for _, name := range names {
+			for _, fragment := range memoryOptionFragments {
+				if strings.Contains(name, fragment) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Output of 'ceph tell osd.N perf dump prioritycache'
+type priorityCacheDump struct {
+	PriorityCache struct {
+		TargetBytes float64 `json:"target_bytes"`
+		MappedBytes float64 `json:"mapped_bytes"`
+	} `json:"prioritycache"`
+}
+
+// osdMemoryProblem describes an OSD that uses more than --memory-guard
+// percent of its memory target, or a host whose OSDs use more than
+// --memory-guard-host percent of its memory - empty if there is none.
+// All OSDs on the hosts of the tuned OSDs are checked.
+func osdMemoryProblem() string {
+	hosts, err := getOSDHosts()
+	if err != nil {
+		log.WithError(err).Warn("Cannot get the hosts of the OSDs - skipping memory check")
+		return ""
+	}
+	watched := map[string]bool{}
+	ids := scopedOSDs()
+	if ids == nil {
+		for id := range hosts {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range ids {
+		watched[hosts[id]] = true
+	}
+	used := map[string]float64{}
+	for id, host := range hosts {
+		if !watched[host] {
+			continue
+		}
+		var dump priorityCacheDump
+		if err := tryCephJSON(&dump, []string{"tell", fmt.Sprintf("osd.%d", id), "perf", "dump", "prioritycache"}); err != nil {
+			log.WithError(err).WithField("osd", id).Debug("Cannot get memory use of OSD")
+			continue
+		}
+		cache := dump.PriorityCache
+		used[host] += cache.MappedBytes
+		if memoryGuardPercent > 0 && cache.TargetBytes > 0 && cache.MappedBytes > cache.TargetBytes*memoryGuardPercent/100 {
+			return fmt.Sprintf("osd.%d uses %.1fGiB, %.0f%% of its memory target", id, cache.MappedBytes/(1<<30), cache.MappedBytes/cache.TargetBytes*100)
+		}
+	}
+	if memoryGuardHostPercent <= 0 {
+		return ""
+	}
+	for host, bytes := range used {
+		if memory, known := hostMemory[host]; known && bytes > memory*memoryGuardHostPercent/100 {
+			return fmt.Sprintf("OSDs on %s use %.1fGiB of %.1fGiB memory", host, bytes/(1<<30), memory/(1<<30))
+		}
+	}
+	return ""
+}
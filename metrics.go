@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runMetrics is the progress of the run exposed in the Prometheus text format
+type runMetrics struct {
+	Trials          int
+	Failures        int
+	LastScore       float64
+	BestScore       float64
+	BaselineScore   float64
+	NoNewBest       int
+	OptionUnderTest ConfigOption
+	ValueUnderTest  string
+	TrialID         string
+	Testing         bool
+
+	// Only shown by the terminal UI
+	BenchmarkStarted time.Time
+	RecentScores     []float64
+}
+
+// Number of recent scores kept for the sparkline of the terminal UI
+const recentScores = 60
+
+var metrics runMetrics
+var metricsLock sync.Mutex
+
+// serveMetrics starts the /metrics endpoint if --metrics-listen is set
+func serveMetrics() {
+	// A resumed run continues counting the trials of the interrupted run
+	for _, trial := range trials {
+		finishTrialMetrics(trial)
+	}
+	if metricsListen == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", writeMetrics)
+	go func() {
+		if err := http.ListenAndServe(metricsListen, mux); err != nil {
+			log.WithError(err).WithField("address", metricsListen).Error("Metrics endpoint stopped")
+		}
+	}()
+	log.WithField("address", metricsListen).Info("Serving Prometheus metrics on /metrics")
+}
+
+func writeMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	renderMetrics(w)
+}
+
+const metricsContentType = "text/plain; version=0.0.4"
+
+// renderMetrics writes all metrics in the Prometheus text format
+func renderMetrics(w io.Writer) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	writeMetric(w, "ceph_optimize_trials_total", "counter", "Trials run so far", metrics.Trials)
+	writeMetric(w, "ceph_optimize_trial_failures_total", "counter", "Trials that failed and were rolled back", metrics.Failures)
+	writeMetric(w, "ceph_optimize_last_score", "gauge", "Score of the last successful trial", metrics.LastScore)
+	writeMetric(w, "ceph_optimize_best_score", "gauge", "Best score found so far", metrics.BestScore)
+	writeMetric(w, "ceph_optimize_baseline_score", "gauge", "Score of the start values", metrics.BaselineScore)
+	writeMetric(w, "ceph_optimize_trials_without_improvement", "gauge", "Trials since the last new best score", metrics.NoNewBest)
+	fmt.Fprintln(w, "# HELP ceph_optimize_option_under_test Option changed by the running trial")
+	fmt.Fprintln(w, "# TYPE ceph_optimize_option_under_test gauge")
+	if metrics.Testing {
+		option := metrics.OptionUnderTest
+		fmt.Fprintf(w, "ceph_optimize_option_under_test{run_id=%q,trial_id=%q,option=%q,target=%q,class=%q} 1\n", runID, metrics.TrialID, option.Name, option.daemonType(), option.deviceClass())
+	}
+}
+
+func writeMetric(w io.Writer, name, kind, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s{run_id=%q} %v\n", name, help, name, kind, name, runID, value)
+}
+
+// startTrialMetrics marks option as under test
+func startTrialMetrics(option ConfigOption, value string, noNewBest int) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	metrics.OptionUnderTest, metrics.ValueUnderTest, metrics.Testing, metrics.NoNewBest = option, value, true, noNewBest
+	metrics.TrialID = trialID
+	metrics.BenchmarkStarted = time.Time{}
+}
+
+// benchmarkStartedMetrics records the start of the benchmark of the running trial
+func benchmarkStartedMetrics() {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	metrics.BenchmarkStarted = time.Now()
+}
+
+// finishTrialMetrics counts a finished trial
+func finishTrialMetrics(trial Trial) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	metrics.Trials++
+	metrics.Testing = false
+	if trial.Error != "" {
+		metrics.Failures++
+		return
+	}
+	metrics.LastScore = trial.Score
+	metrics.RecentScores = append(metrics.RecentScores, trial.Score)
+	if len(metrics.RecentScores) > recentScores {
+		metrics.RecentScores = metrics.RecentScores[1:]
+	}
+}
+
+// pushMetrics replaces the metrics of this run on the Pushgateway, grouped by run and cluster
+func pushMetrics() {
+	if pushgateway == "" {
+		return
+	}
+	var body bytes.Buffer
+	renderMetrics(&body)
+	target := fmt.Sprintf("%s/metrics/job/ceph-optimize/run_id/%s/cluster/%s", strings.TrimSuffix(pushgateway, "/"), url.PathEscape(runID), url.PathEscape(clusterFSID()))
+	request, err := http.NewRequest(http.MethodPut, target, &body)
+	if err != nil {
+		log.WithError(err).Error("Cannot push metrics")
+		return
+	}
+	request.Header.Set("Content-Type", metricsContentType)
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		log.WithError(err).WithField("pushgateway", pushgateway).Warn("Cannot push metrics")
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		log.WithFields(log.Fields{"pushgateway": pushgateway, "status": response.Status}).Warn("Pushgateway rejected metrics")
+	}
+}
+
+var fsid string
+
+// clusterFSID returns the fsid of the cluster, read once
+func clusterFSID() string {
+	if fsid == "" {
+		output, _ := runCeph([]string{"fsid"})
+		fsid = strings.TrimSpace(output)
+	}
+	return fsid
+}
+
+func setScoreMetrics(baseline, best float64) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	metrics.BaselineScore, metrics.BestScore = baseline, best
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Ceph commands that are sent to the monitors directly through librados
+// when the optimizer is built with the goceph tag, with the names of their
+// positional arguments. All other commands still run the ceph CLI.
+var monCommands = []struct {
+	prefix    string
+	arguments []string
+}{
+	{"config set", []string{"who", "name", "value"}},
+	{"config rm", []string{"who", "name"}},
+	{"config get", []string{"who", "key"}},
+	{"config dump", nil},
+	{"config ls", nil},
+	{"config help", []string{"key"}},
+	{"config-key get", []string{"key"}},
+	{"config-key set", []string{"key", "val"}},
+	{"config-key rm", []string{"key"}},
+	{"versions", nil},
+	{"fsid", nil},
+	{"df", nil},
+	{"status", nil},
+	{"mgr stat", nil},
+	{"mon dump", nil},
+	{"osd dump", nil},
+	{"osd tree", nil},
+	{"osd metadata", nil},
+	{"osd pool ls", nil},
+	{"osd pool get", []string{"pool", "var"}},
+	{"osd pool set", []string{"pool", "var", "val"}},
+	{"osd pool application enable", []string{"pool", "app"}},
+	{"osd crush dump", nil},
+	{"osd crush show-tunables", nil},
+}
+
+// monCommandJSON translates the arguments of a ceph CLI call into the JSON
+// of the equivalent mon command. ok is false for commands that are not in
+// monCommands or use flags other than the output format.
+func monCommandJSON(arguments []string) (command []byte, ok bool) {
+	request := map[string]string{}
+	var words []string
+	for i := 0; i < len(arguments); i++ {
+		switch argument := arguments[i]; {
+		case (argument == "-f" || argument == "--format") && i+1 < len(arguments):
+			request["format"] = arguments[i+1]
+			i++
+		case strings.HasPrefix(argument, "--format="):
+			request["format"] = strings.TrimPrefix(argument, "--format=")
+		case strings.HasPrefix(argument, "-"):
+			return nil, false
+		default:
+			words = append(words, argument)
+		}
+	}
+	if strings.Join(words, " ") == "health detail" {
+		request["prefix"], request["detail"] = "health", "detail"
+		command, err := json.Marshal(request)
+		return command, err == nil
+	}
+	for _, candidate := range monCommands {
+		prefix := strings.Fields(candidate.prefix)
+		if len(words) != len(prefix)+len(candidate.arguments) || strings.Join(words[:len(prefix)], " ") != candidate.prefix {
+			continue
+		}
+		request["prefix"] = candidate.prefix
+		for i, name := range candidate.arguments {
+			request[name] = words[len(prefix)+i]
+		}
+		command, err := json.Marshal(request)
+		return command, err == nil
+	}
+	return nil, false
+}
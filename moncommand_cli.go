@@ -0,0 +1,10 @@
+//go:build !goceph
+
+package main
+
+// monCommand is only available when built with the goceph tag, without it
+// every ceph command runs the CLI. Build with -tags goceph and the librados
+// headers to send mon commands through go-ceph.
+func monCommand(arguments []string) (output, info string, handled bool, err error) {
+	return "", "", false, nil
+}
@@ -0,0 +1,91 @@
+//go:build goceph
+
+package main
+
+// Sending mon commands through go-ceph is opt-in: built with -tags goceph,
+// ceph commands listed in monCommands go to the monitors through librados
+// instead of spawning the ceph CLI. This needs cgo and the librados headers
+// (librados-dev or librados-devel), which is why the default build keeps
+// running the CLI. The CI workflow builds both variants.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+	log "github.com/sirupsen/logrus"
+)
+
+var monConn *rados.Conn
+var monConnOnce sync.Once
+
+// monConnection connects to the cluster once with the --cluster, --ceph-conf,
+// --keyring and --name settings. It returns nil if librados cannot connect,
+// which makes the command layer fall back to the ceph CLI.
+func monConnection() *rados.Conn {
+	monConnOnce.Do(func() {
+		if execMode != "local" {
+			// The CLIs only exist inside containers, so librados may not reach the cluster either
+			return
+		}
+		user := strings.TrimPrefix(cephName, "client.")
+		cluster := cephCluster
+		if cluster == "" {
+			cluster = "ceph"
+		}
+		conn, err := rados.NewConnWithClusterAndUser(cluster, user)
+		if err == nil {
+			if cephConf != "" {
+				err = conn.ReadConfigFile(cephConf)
+			} else {
+				err = conn.ReadDefaultConfigFile()
+			}
+		}
+		if err == nil && cephKeyring != "" {
+			err = conn.SetConfigOption("keyring", cephKeyring)
+		}
+		if err == nil && commandTimeout > 0 {
+			err = conn.SetConfigOption("rados_mon_op_timeout", fmt.Sprint(commandTimeout))
+		}
+		if err == nil {
+			err = conn.Connect()
+		}
+		if err != nil {
+			log.WithError(err).Warn("Cannot connect to the cluster with librados - running the ceph CLI instead")
+			return
+		}
+		monConn = conn
+	})
+	return monConn
+}
+
+// monCommand sends the ceph CLI call as mon command if it can be translated.
+// handled is false if the caller has to run the CLI instead. Output is what
+// the CLI prints on stdout, failures are returned as commandError.
+func monCommand(arguments []string) (output, info string, handled bool, err error) {
+	command, ok := monCommandJSON(arguments)
+	if !ok {
+		return "", "", false, nil
+	}
+	conn := monConnection()
+	if conn == nil {
+		return "", "", false, nil
+	}
+	log.Debugf("Sending mon command %s", command)
+	start := time.Now()
+	buffer, info, err := conn.MonCommand(command)
+	recordSpan("command", start, err, "command", string(command))
+	if err != nil {
+		exitCode := 1
+		var cephErr interface{ ErrorCode() int }
+		if errors.As(err, &cephErr) {
+			// Ceph reports negative errno values, the CLI exits with the positive one
+			exitCode = -cephErr.ErrorCode()
+		}
+		return string(buffer), info, true, &commandError{Command: "ceph " + strings.Join(arguments, " "), ExitCode: exitCode, Output: strings.TrimSpace(string(buffer)), Stderr: strings.TrimSpace(info)}
+	}
+	return string(buffer), info, true, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// notification is the JSON body POSTed to --notify-url. Text makes it
+// readable as Slack incoming webhook message as well.
+type notification struct {
+	Event   string                 `json:"event"`
+	RunID   string                 `json:"runId"`
+	Time    time.Time              `json:"time"`
+	Text    string                 `json:"text"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// notify POSTs a run event to the webhook, failures are only logged
+func notify(event, text string, details map[string]interface{}) {
+	if notifyURL == "" {
+		return
+	}
+	body, err := json.Marshal(notification{Event: event, RunID: runID, Time: time.Now(), Text: fmt.Sprintf("ceph-optimize %s: %s", runID, text), Details: details})
+	if err != nil {
+		log.WithError(err).Warn("Cannot serialize notification")
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Post(notifyURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.WithError(err).WithField("event", event).Warn("Cannot send notification")
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		log.WithFields(log.Fields{"event": event, "status": response.Status}).Warn("Notification was rejected")
+	}
+}
+
+// notifyHook sends a notification for every fatal error, which ends the run
+type notifyHook struct{}
+
+func (notifyHook) Levels() []log.Level {
+	return []log.Level{log.FatalLevel}
+}
+
+func (notifyHook) Fire(entry *log.Entry) error {
+	details := map[string]interface{}{}
+	for key, value := range entry.Data {
+		details[key] = fmt.Sprint(value)
+	}
+	notify("failed", "run aborted - "+entry.Message, details)
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NUMA affinity of the OSDs is tuned with target: numa and name: affinity.
+// A single osd_numa_node for all OSDs makes no sense on multi-socket hosts,
+// so the tunable is the strategy every OSD's osd_numa_node is derived from:
+// none (no pinning), storage (the node of its disks) or network (the node of
+// its NIC). OSDs only apply osd_numa_node at start and are restarted.
+var numaStrategies = []string{"none", "storage", "network"}
+
+// Entry of 'ceph osd numa-status -f json'
+type osdNumaStatus struct {
+	OSD             int    `json:"osd"`
+	Host            string `json:"host"`
+	NetworkNumaNode *int   `json:"network_numa_node"`
+	StorageNumaNode *int   `json:"storage_numa_node"`
+	NumaNode        *int   `json:"numa_node"`
+}
+
+// osd_numa_node overrides per OSD from before the first change, nil if there was none
+var numaOriginal map[int]*string
+
+// validateNumaOptions checks NUMA options and fills in their strategies
+func validateNumaOptions(options []ConfigOption) {
+	for i := range options {
+		option := &options[i]
+		if option.daemonType() != "numa" {
+			continue
+		}
+		if option.Name != "affinity" {
+			log.WithField("option", option.Name).Fatal("The only NUMA option is affinity")
+		}
+		if !restartOSDs {
+			log.Fatal("OSDs only change their NUMA affinity on restart - add --restart-OSD to tune it")
+		}
+		option.Type = "enum"
+		option.Restart = true
+		if len(option.Values) == 0 {
+			option.Values = numaStrategies
+		}
+	}
+}
+
+func getNumaStatus() (status []osdNumaStatus, err error) {
+	err = cephJSON(&status, []string{"osd", "numa-status"})
+	return status, err
+}
+
+// numaNode returns the osd_numa_node the strategy assigns to the OSD
+func (status osdNumaStatus) numaNode(strategy string) int {
+	var node *int
+	switch strategy {
+	case "storage":
+		node = status.StorageNumaNode
+	case "network":
+		node = status.NetworkNumaNode
+	}
+	if node == nil {
+		return -1
+	}
+	return *node
+}
+
+// numaOSDs returns the status of the OSDs in scope
+func numaOSDs() []osdNumaStatus {
+	status, err := getNumaStatus()
+	if err != nil {
+		log.WithError(err).Error("Cannot get the NUMA status of the OSDs")
+		return nil
+	}
+	inScope := map[int]bool{}
+	for _, id := range scopedOSDs() {
+		inScope[id] = true
+	}
+	var osds []osdNumaStatus
+	for _, osd := range status {
+		if scopedOSDs() == nil || inScope[osd.OSD] {
+			osds = append(osds, osd)
+		}
+	}
+	sort.Slice(osds, func(i, j int) bool { return osds[i].OSD < osds[j].OSD })
+	return osds
+}
+
+// getNumaAffinity guesses the strategy from the first OSD in scope
+func getNumaAffinity() string {
+	osds := numaOSDs()
+	if len(osds) == 0 || osds[0].NumaNode == nil || *osds[0].NumaNode < 0 {
+		return "none"
+	}
+	for _, strategy := range []string{"storage", "network"} {
+		if osds[0].numaNode(strategy) == *osds[0].NumaNode {
+			return strategy
+		}
+	}
+	return "none"
+}
+
+// setNumaAffinity sets osd_numa_node of every OSD in scope according to the
+// strategy. The OSDs are restarted afterwards by restartIfRequired.
+func setNumaAffinity(strategy string) error {
+	osds := numaOSDs()
+	rememberNumaOverrides(osds)
+	for _, osd := range osds {
+		section := fmt.Sprintf("osd.%d", osd.OSD)
+		node := fmt.Sprint(osd.numaNode(strategy))
+		if _, err := runCeph([]string{"config", "set", section, "osd_numa_node", node}); err != nil {
+			return applyError("osd_numa_node", node, section, err)
+		}
+	}
+	return nil
+}
+
+// rememberNumaOverrides records the per OSD overrides before the first change
+func rememberNumaOverrides(osds []osdNumaStatus) {
+	if numaOriginal != nil {
+		return
+	}
+	numaOriginal = map[int]*string{}
+	dump, err := getInitialConfigDump()
+	if err != nil {
+		log.WithError(err).Warn("Cannot read the config database - NUMA overrides will be removed on restore")
+	}
+	for _, osd := range osds {
+		numaOriginal[osd.OSD] = nil
+		for _, entry := range dump {
+			if entry.who() == fmt.Sprintf("osd.%d", osd.OSD) && entry.Name == "osd_numa_node" {
+				value := entry.Value
+				numaOriginal[osd.OSD] = &value
+			}
+		}
+	}
+}
+
+// restoreNumaAffinity restores the per OSD overrides and restarts the OSDs
+func restoreNumaAffinity() {
+	if numaOriginal == nil {
+		return
+	}
+	log.Info("Restoring NUMA affinity of the OSDs")
+	var ids []int
+	for id, value := range numaOriginal {
+		section := fmt.Sprintf("osd.%d", id)
+		var err error
+		if value == nil {
+			_, err = runCeph([]string{"config", "rm", section, "osd_numa_node"})
+		} else {
+			_, err = runCeph([]string{"config", "set", section, "osd_numa_node", *value})
+		}
+		if err != nil {
+			log.WithError(err).WithField("osd", id).Error("Cannot restore NUMA affinity")
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	if err := rollingRestartOSDs(ids); err != nil {
+		log.WithError(err).Error("Cannot restart OSDs to restore their NUMA affinity")
+	}
+}
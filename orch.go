@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Subset of 'ceph orch status -f json'
+type orchStatus struct {
+	Available bool   `json:"available"`
+	Backend   string `json:"backend"`
+}
+
+// Entry of 'ceph orch ps -f json'
+type orchDaemon struct {
+	DaemonName string `json:"daemon_name"`
+	Hostname   string `json:"hostname"`
+	StatusDesc string `json:"status_desc"`
+	Started    string `json:"started"`
+}
+
+// resolveRestartMethod replaces the auto restart method with orch if an
+// orchestrator backend is available and with systemctl otherwise
+func resolveRestartMethod() {
+	if restartMethod != "auto" {
+		return
+	}
+	restartMethod = "systemctl"
+	output, err := tryCeph([]string{"orch", "status", "-f", "json"})
+	var status orchStatus
+	if err == nil && json.Unmarshal([]byte(output), &status) == nil && status.Available {
+		restartMethod = "orch"
+	}
+	log.WithFields(log.Fields{"restartMethod": restartMethod, "backend": status.Backend}).Info("Detected restart method")
+}
+
+// orchAction is the orchestrator command that restarts daemons and services
+func orchAction() string {
+	if orchRedeploy {
+		return "redeploy"
+	}
+	return "restart"
+}
+
+// orchDaemons lists the daemons known to the orchestrator, filtered by the 'ceph orch ps' arguments
+func orchDaemons(filter ...string) ([]orchDaemon, error) {
+	var daemons []orchDaemon
+	err := cephJSON(&daemons, append([]string{"orch", "ps", "--refresh"}, filter...))
+	return daemons, err
+}
+
+// startTimes maps the daemons to the time the orchestrator saw them start
+func startTimes(daemons []orchDaemon) map[string]string {
+	started := map[string]string{}
+	for _, daemon := range daemons {
+		started[daemon.DaemonName] = daemon.Started
+	}
+	return started
+}
+
+// restartOrchDaemon restarts a single daemon through the orchestrator and
+// waits until 'ceph orch ps' reports it running again
+func restartOrchDaemon(daemon string) error {
+	daemons, err := orchDaemons()
+	if err != nil {
+		return err
+	}
+	before := startTimes(daemons)
+	if _, known := before[daemon]; !known {
+		return fmt.Errorf("the orchestrator does not manage %s", daemon)
+	}
+	if _, err := runCeph([]string{"orch", "daemon", orchAction(), daemon}); err != nil {
+		return err
+	}
+	return waitForOrchDaemons(map[string]string{daemon: before[daemon]}, time.Duration(restartTimeout)*time.Second)
+}
+
+// restartOrchService restarts all daemons of an orchestrator service and
+// waits until 'ceph orch ps' reports all of them running again
+func restartOrchService(service string) error {
+	daemons, err := orchDaemons("--service_name", service)
+	if err != nil {
+		return err
+	}
+	if _, err := runCeph([]string{"orch", orchAction(), service}); err != nil {
+		return err
+	}
+	return waitForOrchDaemons(startTimes(daemons), time.Duration(restartTimeout)*time.Second)
+}
+
+// waitForOrchDaemons waits until every daemon in before is running with a
+// start time different from the one it had before the restart
+func waitForOrchDaemons(before map[string]string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		time.Sleep(5 * time.Second)
+		daemons, err := orchDaemons()
+		if err != nil {
+			log.WithError(err).Warn("Cannot list orchestrator daemons")
+		} else {
+			restarted := 0
+			for _, daemon := range daemons {
+				started, wanted := before[daemon.DaemonName]
+				if wanted && daemon.StatusDesc == "running" && daemon.Started != started {
+					restarted++
+				}
+			}
+			if restarted == len(before) {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("orchestrator daemons did not return to running within %s", timeout)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// The config database as it was before the optimizer changed anything
+var initialConfigDump []configDumpEntry
+var initialConfigDumpErr error
+var initialConfigDumpRead bool
+
+// getInitialConfigDump reads the config database once, before the first change
+func getInitialConfigDump() ([]configDumpEntry, error) {
+	if !initialConfigDumpRead {
+		initialConfigDumpRead = true
+		initialConfigDumpErr = cephJSON(&initialConfigDump, []string{"config", "dump"})
+	}
+	return initialConfigDump, initialConfigDumpErr
+}
+
+// initialOverride returns the value the config database held for the
+// option in section before the run
+func initialOverride(section, name string) (value string, found bool) {
+	dump, _ := getInitialConfigDump()
+	for _, entry := range dump {
+		if entry.who() == section && entry.Name == name {
+			return entry.Value, true
+		}
+	}
+	return "", false
+}
+
+// restoreOverrides puts back the overrides the option had in its sections
+// before the run and removes the ones the optimizer added
+func restoreOverrides(option *ConfigOption) {
+	for _, section := range option.configSections() {
+		var err error
+		if value, found := initialOverride(section, option.Name); found {
+			_, err = runCeph([]string{"config", "set", section, option.Name, value})
+		} else {
+			_, err = runCeph([]string{"config", "rm", section, option.Name})
+		}
+		if err != nil {
+			log.WithError(err).Errorf("Issues restoring %s for %s in the config database", option.Name, section)
+		}
+	}
+}
+
+// reportOverrides logs the settings that were already changed on the
+// cluster before the run - tuned options with overrides are pointed out,
+// because their results depend on the overrides of other sections
+func reportOverrides(options []ConfigOption) []configDumpEntry {
+	dump, err := getInitialConfigDump()
+	if err != nil {
+		log.WithError(err).Warn("Cannot read the config database to report existing overrides")
+		return nil
+	}
+	tuned := map[string]bool{}
+	for _, option := range expandGroups(options) {
+		tuned[option.Name] = true
+	}
+	for _, entry := range dump {
+		if tuned[entry.Name] {
+			log.WithFields(log.Fields{"option": entry.Name, "section": entry.who(), "value": entry.Value}).Warn("Tuned option is already overridden on the cluster - it will be restored after the run")
+		}
+	}
+	log.WithField("overrides", len(dump)).Info("Found existing overrides in the config database")
+	return dump
+}
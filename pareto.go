@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Objective is one of the measured goals of a run
+type Objective struct {
+	Name     string `json:"name"`
+	Maximize bool   `json:"maximize"`
+}
+
+// objectiveValues of the last benchmark, recorded with the trial
+var objectiveValues map[string]float64
+
+// enabledObjectives returns the goals the run weighs against each other.
+// IOPS are traded against stability with --stability-weight and against
+// tail latency with --max-latency-ms.
+func enabledObjectives() []Objective {
+	if objective != "iops" {
+		return nil
+	}
+	objectives := []Objective{{Name: "iops", Maximize: true}}
+	if maxLatencyMs > 0 {
+		objectives = append(objectives, Objective{Name: "latencyMs"})
+	}
+	if stabilityWeight > 0 {
+		objectives = append(objectives, Objective{Name: "cv"})
+	}
+	return objectives
+}
+
+// measureObjectives saves the values of all objectives of a benchmark result
+func measureObjectives(result BenchResult) {
+	objectiveValues = map[string]float64{"iops": result.IOPS, "latencyMs": result.tailLatency() * 1000, "cv": result.stability()}
+}
+
+// paretoPoint is a trial that no other trial beats in all objectives
+type paretoPoint struct {
+	Trial      int                `json:"trial"` // 1-based number of the trial
+	Objectives map[string]float64 `json:"objectives"`
+	Config     map[string]string  `json:"config"`
+}
+
+// paretoFront returns the non-dominated successful trials of a multi-objective run
+func paretoFront(results Results) (front []paretoPoint) {
+	if len(results.Objectives) < 2 {
+		return nil
+	}
+	configs := trialConfigs(results)
+	var candidates []int
+	for i, trial := range results.Trials {
+		if trial.Error == "" && trial.Objectives != nil {
+			candidates = append(candidates, i)
+		}
+	}
+	for _, i := range candidates {
+		dominated := false
+		for _, j := range candidates {
+			if dominates(results.Objectives, results.Trials[j].Objectives, results.Trials[i].Objectives) {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			front = append(front, paretoPoint{Trial: i + 1, Objectives: results.Trials[i].Objectives, Config: configs[i]})
+		}
+	}
+	primary := results.Objectives[0].Name
+	sort.SliceStable(front, func(i, j int) bool { return front[i].Objectives[primary] > front[j].Objectives[primary] })
+	return front
+}
+
+// dominates returns true if a is at least as good as b in all objectives and better in one
+func dominates(objectives []Objective, a, b map[string]float64) bool {
+	better := false
+	for _, objective := range objectives {
+		difference := a[objective.Name] - b[objective.Name]
+		if !objective.Maximize {
+			difference = -difference
+		}
+		if difference < 0 {
+			return false
+		}
+		if difference > 0 {
+			better = true
+		}
+	}
+	return better
+}
+
+// writeParetoFront saves the Pareto front as JSON, or as CSV if the file ends with .csv
+func writeParetoFront(results Results) {
+	front := paretoFront(results)
+	if paretoFile == "" || front == nil {
+		return
+	}
+	var content []byte
+	if strings.HasSuffix(paretoFile, ".csv") {
+		content = paretoCSV(results.Objectives, front)
+	} else {
+		var err error
+		if content, err = json.MarshalIndent(front, "", "  "); err != nil {
+			log.WithError(err).Error("Cannot serialize Pareto front")
+			return
+		}
+	}
+	if err := os.WriteFile(paretoFile, content, 0644); err != nil {
+		log.WithError(err).WithField("file", paretoFile).Error("Cannot write Pareto front")
+		return
+	}
+	log.WithFields(log.Fields{"file": paretoFile, "configs": len(front)}).Info("Wrote Pareto front")
+}
+
+// paretoCSV renders the front with one column per objective and per tuned option
+func paretoCSV(objectives []Objective, front []paretoPoint) []byte {
+	options := map[string]bool{}
+	for _, point := range front {
+		for name := range point.Config {
+			options[name] = true
+		}
+	}
+	var names []string
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out strings.Builder
+	writer := csv.NewWriter(&out)
+	header := []string{"trial"}
+	for _, objective := range objectives {
+		header = append(header, objective.Name)
+	}
+	writer.Write(append(header, names...))
+	for _, point := range front {
+		row := []string{fmt.Sprint(point.Trial)}
+		for _, objective := range objectives {
+			row = append(row, fmt.Sprint(point.Objectives[objective.Name]))
+		}
+		for _, name := range names {
+			row = append(row, point.Config[name])
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	return []byte(out.String())
+}
+
+// paretoChart plots the first two objectives of all trials and highlights the front
+func paretoChart(results Results, front []paretoPoint) string {
+	x, y := results.Objectives[0].Name, results.Objectives[1].Name
+	trials := chartSeries{Name: "trial", Color: "#1f77b4"}
+	for i, trial := range results.Trials {
+		if trial.Error == "" && trial.Objectives != nil {
+			trials.Points = append(trials.Points, chartPoint{X: trial.Objectives[x], Y: trial.Objectives[y], Label: fmt.Sprintf("trial %d: %s = %s", i+1, trial.Option, trial.NewValue)})
+		}
+	}
+	pareto := chartSeries{Name: "Pareto front", Color: "#d62728"}
+	for _, point := range front {
+		pareto.Points = append(pareto.Points, chartPoint{X: point.Objectives[x], Y: point.Objectives[y], Label: fmt.Sprintf("trial %d", point.Trial)})
+	}
+	return svgChart("Pareto front", x, y, []chartSeries{trials, pareto}, nil)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Properties of the benchmark pool that can be tuned with target: pool,
+// with the type and range used when the config file does not set them.
+// size and min_size list their few sensible values so each is tried, the
+// range applies when the config file declares them as int.
+var poolProperties = map[string]ConfigOption{
+	"compression_mode":           {Type: "enum", Values: []string{"none", "passive", "aggressive", "force"}},
+	"compression_algorithm":      {Type: "enum", Values: []string{"snappy", "zlib", "zstd", "lz4"}},
+	"compression_required_ratio": {Type: "float", Min: 0.5, Max: 1},
+	"compression_min_blob_size":  {Type: "int", Min: 4096, Max: 1 << 20},
+	"pg_num":                     {Type: "int", Min: 8, Max: 1024},
+	"pgp_num":                    {Type: "int", Min: 8, Max: 1024},
+	"size":                       {Type: "enum", Values: []string{"2", "3"}, Min: 2, Max: 3},
+	"min_size":                   {Type: "enum", Values: []string{"1", "2"}, Min: 1, Max: 2},
+}
+
+// Pool properties that move data around - the cluster has to settle before
+// the next benchmark
+var dataMovingPoolProperties = map[string]bool{"pg_num": true, "pgp_num": true, "size": true}
+
+// Pool properties the PG autoscaler changes on its own
+var autoscaledPoolProperties = map[string]bool{"pg_num": true, "pgp_num": true}
+
+// autoscaleModeOriginal is the pg_autoscale_mode of the benchmark pool before
+// the autoscaler was turned off, empty while it was not touched
+var autoscaleModeOriginal string
+
+// validatePoolProperties checks pool options and fills in their type and range
+func validatePoolProperties(options []ConfigOption) {
+	for i := range options {
+		option := &options[i]
+		if option.daemonType() != "pool" {
+			continue
+		}
+		defaults, known := poolProperties[option.Name]
+		if !known {
+			log.WithField("property", option.Name).Fatal("Unsupported pool property")
+		}
+		if option.Class != "" || option.Restart {
+			log.WithField("property", option.Name).Fatal("Pool properties cannot have a device class or require a restart")
+		}
+		if option.Type == "" {
+			option.Type = defaults.Type
+		}
+		if option.Type == "enum" && len(option.Values) == 0 {
+			option.Values = defaults.Values
+		}
+		if option.Type != "enum" && option.Min == 0 && option.Max == 0 {
+			option.Min, option.Max = defaults.Min, defaults.Max
+		}
+	}
+}
+
+// getPoolProperty reads a property of the benchmark pool
+func getPoolProperty(name string) string {
+	var property map[string]interface{}
+	if err := cephJSON(&property, []string{"osd", "pool", "get", poolName, name}); err != nil {
+		log.WithError(err).WithField("property", name).Error("Cannot get property of the benchmark pool")
+		return ""
+	}
+	return fmt.Sprint(property[name])
+}
+
+// setPoolProperty changes a property of the benchmark pool and waits for
+// the data movement it triggers to finish
+func setPoolProperty(name, value string) error {
+	if autoscaledPoolProperties[name] {
+		if err := disablePGAutoscaler(); err != nil {
+			return fmt.Errorf("%w: %v", errTrialFailed, err)
+		}
+	}
+	if _, err := runCeph([]string{"osd", "pool", "set", poolName, name, value}); err != nil {
+		return applyError(name, value, "pool "+poolName, err)
+	}
+	if dataMovingPoolProperties[name] {
+		if err := waitForCleanPGs(time.Duration(restartTimeout) * time.Second); err != nil {
+			log.WithError(err).Warn("Benchmark pool did not settle after changing its layout")
+		}
+	}
+	return nil
+}
+
+// disablePGAutoscaler turns off the PG autoscaler of the benchmark pool
+// before the first PG count change, otherwise it changes the tuned value
+// back while the benchmark runs
+func disablePGAutoscaler() error {
+	if autoscaleModeOriginal != "" {
+		return nil
+	}
+	mode := getPoolProperty("pg_autoscale_mode")
+	if mode == "" {
+		return fmt.Errorf("cannot read pg_autoscale_mode of pool %s", poolName)
+	}
+	if mode != "off" {
+		log.WithFields(log.Fields{"pool": poolName, "mode": mode}).Info("Turning off the PG autoscaler of the benchmark pool")
+		if _, err := runCeph([]string{"osd", "pool", "set", poolName, "pg_autoscale_mode", "off"}); err != nil {
+			return fmt.Errorf("cannot turn off the PG autoscaler of pool %s: %w", poolName, err)
+		}
+	}
+	autoscaleModeOriginal = mode
+	return nil
+}
+
+// restorePGAutoscaler sets pg_autoscale_mode of the benchmark pool back to
+// its value from before the run
+func restorePGAutoscaler() {
+	if autoscaleModeOriginal == "" || autoscaleModeOriginal == "off" {
+		return
+	}
+	log.WithFields(log.Fields{"pool": poolName, "mode": autoscaleModeOriginal}).Info("Restoring the PG autoscaler of the benchmark pool")
+	if _, err := runCeph([]string{"osd", "pool", "set", poolName, "pg_autoscale_mode", autoscaleModeOriginal}); err != nil {
+		log.WithError(err).Error("Cannot restore pg_autoscale_mode of the benchmark pool")
+	}
+}
+
+// poolCreated is true once the run created the benchmark pool. Pools that
+// existed before the run are never deleted.
+var poolCreated bool
+
+// poolExists returns true if a pool with the name exists in the cluster
+func poolExists(name string) (bool, error) {
+	var pools []string
+	if err := cephJSON(&pools, []string{"osd", "pool", "ls"}); err != nil {
+		return false, err
+	}
+	for _, pool := range pools {
+		if pool == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Subset of 'ceph df -f json'
+type cephDF struct {
+	Stats struct {
+		TotalBytes        float64 `json:"total_bytes"`
+		TotalUsedRawBytes float64 `json:"total_used_raw_bytes"`
+	} `json:"stats"`
+}
+
+func getRawUtilization() (percent float64, err error) {
+	var df cephDF
+	if err := cephJSON(&df, []string{"df"}); err != nil {
+		return 0, err
+	}
+	return df.Stats.TotalUsedRawBytes / df.Stats.TotalBytes * 100, nil
+}
+
+// prefillCluster writes objects into the benchmark pool until the raw
+// utilization of the cluster reaches prefillPercent. The objects are written
+// under their own run name so the per-trial cleanup leaves them alone. Only
+// pools created by the run are prefilled, they are deleted afterwards.
+func prefillCluster() error {
+	for {
+		utilization, err := getRawUtilization()
+		if err != nil {
+			return fmt.Errorf("cannot get cluster utilization for prefill: %w", err)
+		}
+		if utilization >= prefillPercent {
+			log.WithField("utilization", utilization).Info("Cluster prefill done")
+			return nil
+		}
+		log.WithFields(log.Fields{"utilization": utilization, "target": prefillPercent}).Info("Prefilling cluster")
+		if _, err := runRados(strings.Fields("bench -p " + poolName + " 60 write -t 16 -b 4194304 -O 4194304 --run-name ceph-optimize-prefill --no-cleanup")); err != nil {
+			return fmt.Errorf("cannot prefill cluster: %w", err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Raw utilization at which Ceph reports OSDs as nearfull by default
+const nearfullPercent = 85
+
+// preflight checks that the cluster and this machine are fit for a run and
+// refuses to start otherwise, unless --force is given
+func preflight() {
+	problems := missingTools()
+	if len(problems) == 0 {
+		problems = append(problems, clusterProblems()...)
+	}
+	if len(problems) == 0 {
+		log.Info("Preflight checks passed")
+		return
+	}
+	for _, problem := range problems {
+		log.WithField("problem", problem).Warn("Preflight check failed")
+	}
+	if !force {
+		log.Fatal("Refusing to start - fix the problems above or use --force")
+	}
+	log.Warn("Starting despite failed preflight checks because of --force")
+}
+
+// requiredTools returns the programs this run executes on the local machine
+func requiredTools() (tools []string) {
+	switch execMode {
+	case "cephadm":
+		tools = append(tools, "cephadm")
+	case "rook":
+		tools = append(tools, "kubectl")
+	case "prefix":
+		tools = append(tools, strings.Fields(execPrefix)[0])
+	default:
+		tools = append(tools, cephBinary, radosBinary)
+		if benchBackend == "rbd" {
+			tools = append(tools, rbdBinary)
+		}
+	}
+	if benchBackend == "rbd" {
+		tools = append(tools, fioBinary)
+	}
+	if databasePath != "" {
+		tools = append(tools, sqliteBinary)
+	}
+	if applyMethod == "admin-socket" || ((restartOSDs || restartMon) && restartMethod != "orch") {
+		tools = append(tools, "ssh")
+	}
+	return tools
+}
+
+func missingTools() (problems []string) {
+	for _, tool := range requiredTools() {
+		if _, err := exec.LookPath(tool); err != nil {
+			problems = append(problems, fmt.Sprintf("%s is not installed: %s", tool, err))
+		}
+	}
+	return problems
+}
+
+// clusterProblems checks the quorum, the number of OSDs, the free capacity
+// and that the cluster is not busy with recovery or scrubbing
+func clusterProblems() (problems []string) {
+	var quorum quorumStatus
+	if err := cephJSON(&quorum, []string{"quorum_status"}); err != nil {
+		problems = append(problems, fmt.Sprintf("cannot get quorum status: %s", err))
+	} else if len(quorum.QuorumNames) < len(quorum.MonMap.Mons) {
+		problems = append(problems, fmt.Sprintf("only %d of %d monitors are in quorum", len(quorum.QuorumNames), len(quorum.MonMap.Mons)))
+	}
+
+	dump, err := getOSDDump()
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("cannot get OSD map: %s", err))
+	} else {
+		active := 0
+		for _, osd := range dump.OSDs {
+			if osd.Up == 1 && osd.In == 1 {
+				active++
+			}
+		}
+		if active < minOSDs {
+			problems = append(problems, fmt.Sprintf("only %d OSDs are up and in, at least %d are required", active, minOSDs))
+		}
+	}
+
+	var df cephDF
+	if err := cephJSON(&df, []string{"df"}); err != nil {
+		problems = append(problems, fmt.Sprintf("cannot get cluster utilization: %s", err))
+	} else {
+		utilization := df.Stats.TotalUsedRawBytes / df.Stats.TotalBytes * 100
+		if prefillPercent > utilization {
+			utilization = prefillPercent
+		}
+		utilization += benchmarkDataBytes() * replicaCount() / df.Stats.TotalBytes * 100
+		if utilization >= nearfullPercent {
+			problems = append(problems, fmt.Sprintf("the benchmark data would raise the raw utilization to %.1f%%, above the nearfull ratio of %d%%", utilization, nearfullPercent))
+		}
+	}
+
+	status, err := getCephStatus()
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("cannot get cluster status: %s", err))
+	} else {
+		problems = append(problems, status.busyProblems()...)
+	}
+	return problems
+}
+
+// busyProblems reports PGs in recovery and scrubbing on more than
+// --max-scrubbing-percent of the PGs
+func (status cephStatus) busyProblems() (problems []string) {
+	recovering, scrubbing := 0, 0
+	for _, state := range status.PGMap.PGsByState {
+		for _, busy := range []string{"recover", "backfill", "degraded", "peering"} {
+			if strings.Contains(state.StateName, busy) {
+				recovering += state.Count
+				break
+			}
+		}
+		if strings.Contains(state.StateName, "scrubbing") {
+			scrubbing += state.Count
+		}
+	}
+	if recovering > 0 {
+		problems = append(problems, fmt.Sprintf("%d PGs are recovering, backfilling or degraded", recovering))
+	}
+	if status.PGMap.NumPGs > 0 && float64(scrubbing)/float64(status.PGMap.NumPGs)*100 > maxScrubbingPercent {
+		problems = append(problems, fmt.Sprintf("%d of %d PGs are scrubbing", scrubbing, status.PGMap.NumPGs))
+	}
+	return problems
+}
+
+// benchmarkDataBytes estimates how much data the benchmark keeps in the
+// cluster during a run. Objects written by rados bench and the S3 write
+// profiles are removed after every trial and are not counted.
+func benchmarkDataBytes() float64 {
+	switch benchBackend {
+	case "rbd":
+		return float64(rbdImageSize) * 1024 * 1024
+	case "rgw":
+		switch s3Workload {
+		case "get":
+			return float64(benchScale) * float64(benchObjectSize) * 1024
+		case "large-get":
+			return float64(benchScale) * float64(s3LargeObjectSize) * 1024 * 1024
+		}
+	}
+	return 0
+}
+
+// replicaCount returns the number of copies new pools keep of every object
+func replicaCount() float64 {
+	output, err := runCeph([]string{"config", "get", "mon", "osd_pool_default_size"})
+	if err != nil {
+		return 3
+	}
+	size, err := strconv.ParseFloat(strings.TrimSpace(output), 64)
+	if err != nil {
+		return 3
+	}
+	return size
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Curated option lists selectable with --preset instead of writing a config file
+//
+//go:embed presets/*.yaml
+var presetFiles embed.FS
+
+func presetNames() (names []string) {
+	entries, _ := presetFiles.ReadDir("presets")
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".yaml"))
+	}
+	return names
+}
+
+// loadPreset returns the options of the named preset
+func loadPreset(name string) (options []ConfigOption, err error) {
+	content, err := presetFiles.ReadFile(path.Join("presets", name+".yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown preset %q - available presets: %s", name, strings.Join(presetNames(), ","))
+	}
+	return parseOptions(content, "preset "+name)
+}
+
+// loadOptionsFile returns the options of the --conf file. Errors name the
+// resolved path, so a typo in the flag is easy to spot.
+func loadOptionsFile(file string) (options []ConfigOption, err error) {
+	resolved, err := filepath.Abs(file)
+	if err != nil {
+		resolved = file
+	}
+	content, err := os.ReadFile(file)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("config file %s does not exist", resolved)
+	case errors.Is(err, fs.ErrPermission):
+		return nil, fmt.Errorf("config file %s is not readable by this user", resolved)
+	case err != nil:
+		return nil, fmt.Errorf("cannot read config file %s: %w", resolved, err)
+	}
+	if options, err = parseOptions(content, "config file "+resolved); err != nil {
+		return nil, err
+	}
+	if len(options) == 0 {
+		return nil, fmt.Errorf("config file %s lists no options", resolved)
+	}
+	return options, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Start of the search loop of this process and the trials run since then,
+// for estimating the duration of a trial
+var searchStarted time.Time
+var searchTrials int
+
+// logProgress reports the trial about to start together with an estimate of
+// the remaining time, assuming no further improvement is found
+func logProgress(noNewBest int, started time.Time) {
+	if searchStarted.IsZero() {
+		searchStarted = time.Now()
+	}
+	fields := log.Fields{}
+	message := "trial %d (%d since last improvement, timeout %d), elapsed %s"
+	arguments := []interface{}{len(trials) + 1, noNewBest, timeout, time.Since(started).Round(time.Minute)}
+	if searchTrials > 0 {
+		perTrial := time.Since(searchStarted) / time.Duration(searchTrials)
+		fields["perTrial"] = perTrial.Round(time.Second)
+		message += ", est. remaining ~%s"
+		arguments = append(arguments, (perTrial * time.Duration(timeout-noNewBest)).Round(time.Minute))
+	}
+	searchTrials++
+	log.WithFields(fields).Infof(message, arguments...)
+}
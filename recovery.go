@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Subset of 'ceph osd dump -f json'
+type osdDump struct {
+	OSDs []struct {
+		OSD      int     `json:"osd"`
+		Up       int     `json:"up"`
+		In       int     `json:"in"`
+		Reweight float64 `json:"weight"`
+		UpFrom   int     `json:"up_from"` // epoch the OSD came up, changes when it restarts
+	} `json:"osds"`
+}
+
+func getOSDDump() (dump osdDump, err error) {
+	err = cephJSON(&dump, []string{"osd", "dump"})
+	return dump, err
+}
+
+func getOSDReweight(id int) (float64, error) {
+	dump, err := getOSDDump()
+	if err != nil {
+		return 0, err
+	}
+	for _, osd := range dump.OSDs {
+		if osd.OSD == id {
+			return osd.Reweight, nil
+		}
+	}
+	return 0, fmt.Errorf("osd.%d not found in osd dump", id)
+}
+
+func reweightOSD(id int, weight float64) error {
+	_, err := runCeph([]string{"osd", "reweight", fmt.Sprint(id), fmt.Sprint(weight)})
+	return err
+}
+
+// getRecoveryLatencyScore measures how much client latency degrades while the
+// cluster backfills. It runs the benchmark on a clean cluster, reweights
+// recoveryOSD to start a controlled backfill and runs the benchmark again.
+// The score is the clean latency in percent of the latency under recovery,
+// so 100 means recovery had no impact on clients.
+func getRecoveryLatencyScore() (number float64, err error) {
+	clean, err := runBenchmark()
+	if err != nil {
+		return 0, err
+	}
+
+	originalWeight, err := getOSDReweight(recoveryOSD)
+	if err != nil {
+		return 0, err
+	}
+	log.WithFields(log.Fields{"osd": recoveryOSD, "reweight": recoveryReweight}).Debug("Reweighting OSD to trigger backfill")
+	intentionalBackfill.Store(true)
+	defer intentionalBackfill.Store(false)
+	if err := reweightOSD(recoveryOSD, recoveryReweight); err != nil {
+		return 0, fmt.Errorf("%w: cannot reweight osd.%d: %v", errTrialFailed, recoveryOSD, err)
+	}
+	degraded, benchErr := runBenchmark()
+
+	if err := reweightOSD(recoveryOSD, originalWeight); err != nil {
+		log.WithError(err).Errorf("Cannot restore the reweight of osd.%d to %v", recoveryOSD, originalWeight)
+	}
+	if err := waitForCleanPGs(time.Duration(recoveryTimeout) * time.Second); err != nil {
+		log.WithError(err).Warn("Cluster did not recover after the latency-under-recovery benchmark")
+	}
+	if benchErr != nil {
+		return 0, benchErr
+	}
+	if degraded.AvgLatency == 0 {
+		return 0, fmt.Errorf("benchmark under recovery did not report a latency")
+	}
+
+	log.WithFields(log.Fields{"cleanLatency": clean.AvgLatency, "recoveryLatency": degraded.AvgLatency}).Debug("Client latency under recovery")
+	return clean.AvgLatency / degraded.AvgLatency * 100, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errRejected fails the trial of a value Ceph refused to apply, so that an
+// unchanged cluster is not benchmarked as if the value was set
+var errRejected = fmt.Errorf("%w: value rejected by Ceph", errTrialFailed)
+
+// rejectedByCeph reports whether the command failed because Ceph refused
+// the value itself - EINVAL for values of the wrong type, ERANGE for
+// values outside the option's own bounds
+func rejectedByCeph(err *commandError) bool {
+	text := err.text()
+	return err.ExitCode == int(syscall.EINVAL) || err.ExitCode == int(syscall.ERANGE) ||
+		strings.Contains(text, "Error EINVAL") || strings.Contains(text, "Error ERANGE")
+}
+
+// applyError turns the failure to set option name to value on target into
+// the error that fails the trial, wrapping errRejected if Ceph refused the
+// value and errTrialFailed otherwise
+func applyError(name, value, target string, err error) error {
+	var commandErr *commandError
+	if errors.As(err, &commandErr) && rejectedByCeph(commandErr) {
+		log.WithError(err).WithFields(log.Fields{"option": name, "value": value, "target": target}).Warn("Ceph rejected the value")
+		return fmt.Errorf("%w: %s=%s on %s: %s", errRejected, name, value, target, commandErr.text())
+	}
+	log.WithError(err).WithFields(log.Fields{"option": name, "value": value, "target": target}).Error("Cannot apply value")
+	return fmt.Errorf("%w: cannot set %s=%s on %s: %v", errTrialFailed, name, value, target, err)
+}
+
+// avoidRejectedRegion keeps the search away from a rejected value. Ceph
+// rejects values beyond its own bounds, so for numbers everything from the
+// value to the nearer end of the search range is avoided as well.
+func avoidRejectedRegion(option ConfigOption, value string) {
+	failedValues[option.key()] = append(failedValues[option.key()], value)
+	number, err := parseQuantity(value, time.Second)
+	if err != nil || option.Type == "bool" || option.Type == "enum" || option.Max <= option.Min {
+		return
+	}
+	region := [2]float64{number, option.Max}
+	if number-option.Min < option.Max-number {
+		region = [2]float64{option.Min, number}
+	}
+	blockedRanges[option.key()] = append(blockedRanges[option.key()], region)
+	log.WithFields(log.Fields{"option": option.Name, "from": formatQuantity(region[0]), "to": formatQuantity(region[1])}).Warn("Value was rejected - the values from it to the end of the range will not be tried again")
+}
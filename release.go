@@ -0,0 +1,83 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Major version of each named Ceph release
+var cephReleases = map[string]int{
+	"luminous": 12,
+	"mimic":    13,
+	"nautilus": 14,
+	"octopus":  15,
+	"pacific":  16,
+	"quincy":   17,
+	"reef":     18,
+	"squid":    19,
+	"tentacle": 20,
+}
+
+// Major version of the oldest daemon in the cluster
+var cephRelease int
+
+var versionPattern = regexp.MustCompile(`ceph version (\d+)\.`)
+
+// Subset of 'ceph versions -f json'
+type cephVersions struct {
+	Overall map[string]int `json:"overall"`
+}
+
+// getCephRelease returns the major version of the oldest daemon in the
+// cluster, so options are only tuned if every daemon knows them
+func getCephRelease() (release int) {
+	var versions cephVersions
+	if err := cephJSON(&versions, []string{"versions"}); err != nil {
+		log.WithError(err).Fatal("Cannot get the Ceph versions of the cluster")
+	}
+	for version := range versions.Overall {
+		match := versionPattern.FindStringSubmatch(version)
+		if match == nil {
+			continue
+		}
+		major, _ := strconv.Atoi(match[1])
+		if release == 0 || major < release {
+			release = major
+		}
+	}
+	if len(versions.Overall) > 1 {
+		log.WithField("versions", versions.Overall).Warn("Cluster runs mixed Ceph versions - options are filtered for the oldest one")
+	}
+	return release
+}
+
+// parseRelease accepts a release name (reef) or its major version (18)
+func parseRelease(text string) (int, bool) {
+	if major, known := cephReleases[strings.ToLower(text)]; known {
+		return major, true
+	}
+	major, err := strconv.Atoi(text)
+	return major, err == nil
+}
+
+// supportedInRelease checks the minRelease and maxRelease of the option against the running release
+func (option ConfigOption) supportedInRelease(release int) bool {
+	if option.MinRelease != "" {
+		if min, ok := parseRelease(option.MinRelease); !ok {
+			log.WithFields(log.Fields{"option": option.Name, "minRelease": option.MinRelease}).Fatal("Unknown Ceph release")
+		} else if release < min {
+			return false
+		}
+	}
+	if option.MaxRelease != "" {
+		if max, ok := parseRelease(option.MaxRelease); !ok {
+			log.WithFields(log.Fields{"option": option.Name, "maxRelease": option.MaxRelease}).Fatal("Unknown Ceph release")
+		} else if release > max {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ceph-optimize run {{.Results.RunID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.charts svg { margin: 0 1em 1em 0; border: 1px solid #eee; }
+</style>
+</head>
+<body>
+<h1>ceph-optimize run {{.Results.RunID}}</h1>
+<p>{{.Results.Started.Format "2006-01-02 15:04:05"}} to {{.Results.Finished.Format "2006-01-02 15:04:05"}}, {{len .Results.Trials}} trials ({{.Failures}} failed).
+Baseline score {{printf "%.2f" .Results.BaselineScore}}, best score {{printf "%.2f" .Results.BestScore}}{{if .Results.ConfirmedScore}}, confirmed {{printf "%.2f" .Results.ConfirmedScore}}{{end}}.</p>
+
+<h2>Score over time</h2>
+<div class="charts">{{.ScoreChart}}</div>
+
+<h2>Best config</h2>
+<table>
+<tr><th>Option</th><th>Target</th><th>Sections</th><th>Value</th></tr>
+{{range .Results.BestConfig}}<tr><td>{{.Name}}</td><td>{{.Target}}</td><td>{{range $i, $s := .Sections}}{{if $i}}, {{end}}{{$s}}{{end}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+
+<h2>Contribution per option</h2>
+<table>
+<tr><th>Option</th><th>Accepted changes</th><th>Score gain</th><th>Share of improvement</th></tr>
+{{range .Contributions}}<tr><td>{{.Option}}</td><td>{{.Accepted}}</td><td>{{printf "%+.2f" .Gain}}</td><td>{{printf "%.1f" .Share}}%</td></tr>
+{{else}}<tr><td colspan="4">No change improved the score</td></tr>
+{{end}}</table>
+
+{{if .Pareto}}<h2>Pareto front</h2>
+<div class="charts">{{.ParetoChart}}</div>
+<table>
+<tr><th>Trial</th>{{range .Results.Objectives}}<th>{{.Name}}</th>{{end}}<th>Differences from the best config</th></tr>
+{{range .Pareto}}<tr><td>{{.Trial}}</td>{{range .Values}}<td>{{.}}</td>{{end}}<td>{{.Differences}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Crashed}}<h2>Crashes</h2>
+<table>
+<tr><th>Option</th><th>Value</th><th>Crashes</th></tr>
+{{range .Crashed}}<tr><td>{{.Option}}</td><td>{{.NewValue}}</td><td>{{range $i, $c := .Crashes}}{{if $i}}<br>{{end}}{{$c}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Score per option value</h2>
+<div class="charts">{{range .OptionCharts}}{{.}}{{end}}</div>
+
+<h2>Benchmark settings</h2>
+<table>
+<tr><th>Objective</th><td>{{.Results.Benchmark.Objective}}</td></tr>
+<tr><th>Backend</th><td>{{.Results.Benchmark.Backend}}</td></tr>
+<tr><th>Type</th><td>{{.Results.Benchmark.Type}}</td></tr>
+<tr><th>Time</th><td>{{.Results.Benchmark.Time}}s</td></tr>
+<tr><th>Concurrent IOs</th><td>{{.Results.Benchmark.Scale}}</td></tr>
+<tr><th>Block size</th><td>{{.Results.Benchmark.BlockSizeKB}} KB</td></tr>
+<tr><th>Object size</th><td>{{.Results.Benchmark.ObjectSizeKB}} KB</td></tr>
+<tr><th>Pool PGs</th><td>{{.Results.Benchmark.PoolPGs}}</td></tr>
+{{with .Results.Benchmark.CrushRule}}<tr><th>CRUSH rule</th><td>{{.}}</td></tr>{{end}}
+{{with .Results.Benchmark.RBDClient}}<tr><th>RBD client</th><td>{{.}}</td></tr>{{end}}
+{{with .Results.Benchmark.S3Workload}}<tr><th>S3 workload</th><td>{{.}}</td></tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+// runReportCommand implements the report subcommand, which renders a
+// results file as a self-contained HTML report or a Markdown summary
+func runReportCommand(args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	output := flags.String("o", "report.html", "File to write the report to")
+	format := flags.String("format", "", "Report format - one of html,markdown (default from the extension of -o)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s report results.json [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	results, err := readResults(positional[0])
+	if err != nil {
+		log.WithError(err).WithField("file", positional[0]).Fatal("Cannot read results file")
+	}
+	if *format == "" {
+		*format = "html"
+		if strings.HasSuffix(*output, ".md") {
+			*format = "markdown"
+		}
+	}
+	if *format != "html" && *format != "markdown" {
+		log.WithField("format", *format).Fatal("Unknown report format")
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		log.WithError(err).WithField("file", *output).Fatal("Cannot create report")
+	}
+	defer file.Close()
+	if *format == "markdown" {
+		writeMarkdownReport(file, results)
+	} else if err := writeHTMLReport(file, results); err != nil {
+		log.WithError(err).WithField("file", *output).Fatal("Cannot write report")
+	}
+	log.WithField("file", *output).Info("Wrote report")
+}
+
+// writeHTMLReport writes results as self-contained HTML page with inline SVG charts
+func writeHTMLReport(out io.Writer, results Results) error {
+	data := struct {
+		Results       Results
+		Failures      int
+		ScoreChart    template.HTML
+		OptionCharts  []template.HTML
+		Contributions []contribution
+		ParetoChart   template.HTML
+		Pareto        []paretoRow
+		Crashed       []Trial
+	}{Results: results, ScoreChart: template.HTML(scoreChart(results)), Contributions: contributions(results)}
+	if front := paretoFront(results); front != nil {
+		data.ParetoChart = template.HTML(paretoChart(results, front))
+		data.Pareto = paretoRows(results, front)
+	}
+	for _, trial := range results.Trials {
+		if trial.Error != "" {
+			data.Failures++
+		}
+		if len(trial.Crashes) > 0 {
+			data.Crashed = append(data.Crashed, trial)
+		}
+	}
+	for _, chart := range optionCharts(results) {
+		data.OptionCharts = append(data.OptionCharts, template.HTML(chart))
+	}
+	return reportTemplate.Execute(out, data)
+}
+
+// paretoRow is a point of the Pareto front as shown in the report
+type paretoRow struct {
+	Trial       int
+	Values      []string // objective values in the order of Results.Objectives
+	Differences string
+}
+
+// paretoRows describes every config of the front by how it differs from the best config
+func paretoRows(results Results, front []paretoPoint) (rows []paretoRow) {
+	best := map[string]string{}
+	for _, value := range results.BestConfig {
+		best[value.Name] = value.Value
+	}
+	for _, point := range front {
+		row := paretoRow{Trial: point.Trial}
+		for _, objective := range results.Objectives {
+			row.Values = append(row.Values, fmt.Sprintf("%.4g", point.Objectives[objective.Name]))
+		}
+		var differences []string
+		for name, value := range point.Config {
+			if bestValue, known := best[name]; known && !sameValue(value, bestValue) {
+				differences = append(differences, name+" = "+value)
+			}
+		}
+		sort.Strings(differences)
+		row.Differences = strings.Join(differences, ", ")
+		if row.Differences == "" {
+			row.Differences = "none"
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// scoreChart plots the score of every successful trial, marks the accepted
+// ones and draws the best score over the trials
+func scoreChart(results Results) string {
+	scores := chartSeries{Name: "trial", Color: "#1f77b4"}
+	accepted := chartSeries{Name: "new best", Color: "#d62728"}
+	best := chartSeries{Name: "best", Color: "#2ca02c", Line: true, Points: []chartPoint{{X: 0, Y: results.BaselineScore}}}
+	highest := results.BaselineScore
+	for i, trial := range results.Trials {
+		if trial.Error == "" {
+			scores.Points = append(scores.Points, chartPoint{X: float64(i + 1), Y: trial.Score, Label: fmt.Sprintf("%s = %s: %.2f", trial.Option, trial.NewValue, trial.Score)})
+			if trial.Accepted {
+				highest = trial.Score
+				accepted.Points = append(accepted.Points, scores.Points[len(scores.Points)-1])
+			}
+		}
+		best.Points = append(best.Points, chartPoint{X: float64(i + 1), Y: highest})
+	}
+	return svgChart("Score per trial", "trial", "score", []chartSeries{scores, accepted, best}, nil)
+}
+
+// optionCharts plots the score against the tried values, one chart per option
+func optionCharts(results Results) (charts []string) {
+	byOption := map[string][]Trial{}
+	for _, trial := range results.Trials {
+		if trial.Error == "" {
+			name := trial.Option
+			if trial.Class != "" {
+				name += "@" + trial.Class
+			}
+			byOption[name] = append(byOption[name], trial)
+		}
+	}
+	var names []string
+	for name := range byOption {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var categories []string
+		series := chartSeries{Color: "#ff7f0e"}
+		for _, trial := range byOption[name] {
+			series.Points = append(series.Points, chartPoint{X: chartValue(trial.NewValue, &categories), Y: trial.Score, Label: fmt.Sprintf("%s: %.2f", trial.NewValue, trial.Score)})
+		}
+		charts = append(charts, svgChart(name, "value", "score", []chartSeries{series}, categories))
+	}
+	return charts
+}
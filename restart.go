@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Subset of 'ceph osd find <id> -f json'
+type osdLocation struct {
+	Host string `json:"host"`
+}
+
+// restartIfRequired restarts the daemons the option targets when the option
+// only takes effect after a restart and restarts are allowed
+func restartIfRequired(option *ConfigOption) error {
+	if !option.Restart {
+		return nil
+	}
+	if !restartAllowed(*option) {
+		log.WithFields(log.Fields{"option": option.Name, "target": option.daemonType()}).Warn("Option requires a daemon restart that is not enabled - the new value will not take effect")
+		return nil
+	}
+	var err error
+	switch option.daemonType() {
+	case "osd":
+		err = rollingRestartOSDs(option.osdIDs())
+	case "numa":
+		err = rollingRestartOSDs(scopedOSDs())
+	case "rgw", "frontend":
+		err = restartRGWs()
+	case "mon":
+		err = rollingRestartMons()
+	case "mgr":
+		err = failoverMgr()
+	}
+	return err
+}
+
+// restartAllowed returns true if the daemons targeted by option may be restarted
+func restartAllowed(option ConfigOption) bool {
+	switch option.daemonType() {
+	case "osd", "numa":
+		return restartOSDs
+	case "rgw", "frontend":
+		return restartRGW
+	case "mon":
+		return restartMon
+	case "mgr":
+		return restartMgr
+	}
+	return false
+}
+
+// rollingRestartOSDs restarts one OSD at a time and waits for it to rejoin
+// and for all PGs to become active+clean before moving on to the next one.
+// Only the OSDs in ids are restarted, nil restarts all OSDs.
+func rollingRestartOSDs(ids []int) error {
+	dump, err := getOSDDump()
+	if err != nil {
+		return err
+	}
+	inScope := map[int]bool{}
+	for _, id := range ids {
+		inScope[id] = true
+	}
+	for _, osd := range dump.OSDs {
+		if osd.Up == 0 || (ids != nil && !inScope[osd.OSD]) {
+			continue
+		}
+		log.WithField("osd", osd.OSD).Debug("Restarting OSD")
+		if err := restartOSD(osd.OSD); err != nil {
+			return err
+		}
+		if err := waitForOSDUp(osd.OSD, osd.UpFrom, time.Duration(restartTimeout)*time.Second); err != nil {
+			return err
+		}
+		if err := waitForCleanPGs(time.Duration(restartTimeout) * time.Second); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restartOSD(id int) error {
+	resolveRestartMethod()
+	var location osdLocation
+	if restartMethod != "orch" {
+		if err := cephJSON(&location, []string{"osd", "find", fmt.Sprint(id)}); err != nil {
+			return err
+		}
+	}
+	return restartDaemon("osd", fmt.Sprint(id), location.Host)
+}
+
+// restartDaemon restarts a single daemon with the selected restart method.
+// host is only needed for the systemctl and cephadm methods.
+func restartDaemon(daemonType, id, host string) error {
+	resolveRestartMethod()
+	daemon := daemonType + "." + id
+	if restartMethod == "orch" {
+		return restartOrchDaemon(daemon)
+	}
+
+	unit := fmt.Sprintf("ceph-%s@%s", daemonType, id)
+	if restartMethod == "cephadm" {
+		fsid, err := runCeph([]string{"fsid"})
+		if err != nil {
+			return err
+		}
+		unit = fmt.Sprintf("ceph-%s@%s", strings.TrimSpace(fsid), daemon)
+	}
+	_, err := runOnHost(host, []string{"systemctl", "restart", unit})
+	return err
+}
+
+// runOnHost runs a command locally if host is this machine and via SSH otherwise
+func runOnHost(host string, command []string) (output string, err error) {
+	if hostname, _ := os.Hostname(); host == hostname || strings.HasPrefix(hostname, host+".") {
+		return executeCommand(command[0], command[1:])
+	}
+	return executeCommand("ssh", append([]string{host}, command...))
+}
+
+// waitForOSDUp waits until the OSD came up after upFrom, the epoch it came up
+// before the restart - right after the restart the OSD map still shows the
+// old instance as up
+func waitForOSDUp(id, upFrom int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		dump, err := getOSDDump()
+		if err != nil {
+			log.WithError(err).Warn("Cannot get OSD map")
+		} else {
+			for _, osd := range dump.OSDs {
+				if osd.OSD == id && osd.Up == 1 && osd.UpFrom != upFrom {
+					return nil
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("osd.%d did not come up within %s", id, timeout)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Entry of 'ceph orch ls -f json'
+type orchService struct {
+	ServiceName string `json:"service_name"`
+}
+
+// restartRGWs restarts all radosgw services through the orchestrator
+// and waits until the S3 endpoint answers again
+func restartRGWs() error {
+	services := []string{rgwService}
+	if rgwService == "" {
+		var list []orchService
+		if err := cephJSON(&list, []string{"orch", "ls", "rgw"}); err != nil {
+			return err
+		}
+		services = nil
+		for _, service := range list {
+			services = append(services, service.ServiceName)
+		}
+	}
+	for _, service := range services {
+		log.WithField("service", service).Debug("Restarting RGW service")
+		if err := restartOrchService(service); err != nil {
+			return err
+		}
+	}
+	return waitForS3Endpoint(time.Duration(restartTimeout) * time.Second)
+}
+
+// waitForS3Endpoint polls the S3 endpoint until it answers any HTTP request
+func waitForS3Endpoint(timeout time.Duration) error {
+	client := &http.Client{Timeout: 2 * time.Second}
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := client.Get(s3Endpoint)
+		if err == nil {
+			resp.Body.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("S3 endpoint %s did not come back within %s: %w", s3Endpoint, timeout, err)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// Subset of 'ceph mon dump -f json'
+type monDump struct {
+	Mons []struct {
+		Name string `json:"name"`
+	} `json:"mons"`
+}
+
+// Subset of 'ceph quorum_status -f json'
+type quorumStatus struct {
+	QuorumNames []string `json:"quorum_names"`
+	MonMap      monDump  `json:"monmap"`
+}
+
+// rollingRestartMons restarts one monitor at a time and waits for it to
+// rejoin the quorum before restarting the next one. Monitors are expected
+// to be named after their host, which is what all deployment tools do.
+func rollingRestartMons() error {
+	var dump monDump
+	if err := cephJSON(&dump, []string{"mon", "dump"}); err != nil {
+		return err
+	}
+	for _, mon := range dump.Mons {
+		log.WithField("mon", mon.Name).Debug("Restarting monitor")
+		if err := restartDaemon("mon", mon.Name, mon.Name); err != nil {
+			return err
+		}
+		if err := waitForQuorum(mon.Name, time.Duration(restartTimeout)*time.Second); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func waitForQuorum(name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	// Give the restarted monitor time to drop out of the quorum
+	time.Sleep(5 * time.Second)
+	for {
+		var status quorumStatus
+		if err := cephJSON(&status, []string{"quorum_status"}); err != nil {
+			log.WithError(err).Warn("Cannot get quorum status")
+		} else {
+			for _, member := range status.QuorumNames {
+				if member == name {
+					return nil
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("mon.%s did not rejoin the quorum within %s", name, timeout)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Subset of 'ceph mgr stat -f json'
+type mgrStat struct {
+	Available  bool   `json:"available"`
+	ActiveName string `json:"active_name"`
+}
+
+// failoverMgr restarts the active manager by failing it over to a standby
+// (or letting it restart if there is none) and waits for a manager to be available
+func failoverMgr() error {
+	var before mgrStat
+	if err := cephJSON(&before, []string{"mgr", "stat"}); err != nil {
+		return err
+	}
+	if _, err := runCeph([]string{"mgr", "fail", before.ActiveName}); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(time.Duration(restartTimeout) * time.Second)
+	for {
+		time.Sleep(5 * time.Second)
+		var stat mgrStat
+		if err := cephJSON(&stat, []string{"mgr", "stat"}); err != nil {
+			log.WithError(err).Warn("Cannot get manager status")
+		} else if stat.Available {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no manager became available within %ds", restartTimeout)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Results is the machine-readable outcome of a run
+type Results struct {
+	RunID                string              `json:"runId"`
+	Started              time.Time           `json:"started"`
+	Finished             time.Time           `json:"finished"`
+	Benchmark            BenchmarkParameters `json:"benchmark"`
+	Objectives           []Objective         `json:"objectives,omitempty"` // goals of a multi-objective run
+	Topology             Topology            `json:"topology"`
+	Environment          Environment         `json:"environment"`
+	BaselineScore        float64             `json:"baselineScore"` // score of the start values before the first trial
+	BaselineConfig       []ResultValue       `json:"baselineConfig"`
+	Trials               []Trial             `json:"trials"`
+	BestScore            float64             `json:"bestScore"`
+	BestConfig           []ResultValue       `json:"bestConfig"`
+	ConfirmedScore       float64             `json:"confirmedScore,omitempty"` // average score of the best config in the confirmation runs
+	ConfigDiff           []DiffEntry         `json:"configDiff"`
+	PreexistingOverrides []configDumpEntry   `json:"preexistingOverrides"` // config database before the run, for context
+}
+
+// BenchmarkParameters describes how every trial was measured
+type BenchmarkParameters struct {
+	Objective    string `json:"objective"`
+	Backend      string `json:"backend"`
+	Type         string `json:"type"`
+	Time         int    `json:"time"`
+	Scale        int    `json:"scale"`
+	BlockSizeKB  int    `json:"blockSizeKB"`
+	ObjectSizeKB int    `json:"objectSizeKB"`
+	PoolPGs      int    `json:"poolPGs"`
+	CrushRule    string `json:"crushRule,omitempty"`
+	RBDClient    string `json:"rbdClient,omitempty"`
+	S3Workload   string `json:"s3Workload,omitempty"`
+}
+
+// Trial is one value tried for one option
+type Trial struct {
+	ID        string    `json:"id"` // UUID found in the log entries and metrics of the trial
+	Timestamp time.Time `json:"timestamp"`
+	Option    string    `json:"option"`
+	Target    string    `json:"target"`
+	Class     string    `json:"class,omitempty"`
+	OldValue  string    `json:"oldValue"`
+	NewValue  string    `json:"newValue"`
+	Score     float64   `json:"score"`
+	Accepted  bool      `json:"accepted"`
+	Error     string    `json:"error,omitempty"`   // why the trial failed, the score is meaningless then
+	Unsafe    bool      `json:"unsafe,omitempty"`  // the health watchdog aborted the trial because the value broke the cluster
+	Crashes   []string  `json:"crashes,omitempty"` // daemon crashes and OSD flaps during the trial
+
+	Objectives map[string]float64 `json:"objectives,omitempty"` // measured goals of a multi-objective run
+}
+
+// ResultValue is the best value found for one option
+type ResultValue struct {
+	Name     string   `json:"name"`
+	Target   string   `json:"target"`
+	Sections []string `json:"sections"` // who-masks for 'ceph config set'
+	Value    string   `json:"value"`
+}
+
+// benchmarkParameters returns the benchmark settings of this run
+func benchmarkParameters() BenchmarkParameters {
+	parameters := BenchmarkParameters{
+		Objective:    objective,
+		Backend:      benchBackend,
+		Type:         benchType,
+		Time:         benchTime,
+		Scale:        benchScale,
+		BlockSizeKB:  benchBlockSize,
+		ObjectSizeKB: benchObjectSize,
+		PoolPGs:      poolPGs,
+		CrushRule:    crushRule,
+	}
+	switch benchBackend {
+	case "rbd":
+		parameters.RBDClient = rbdClient
+	case "rgw":
+		parameters.S3Workload = s3Workload
+	}
+	return parameters
+}
+
+// Value currently applied for every option, keyed by ConfigOption.key()
+var currentValues = map[string]string{}
+
+// bestValues returns the currently applied value of every option
+func bestValues(options []ConfigOption) (values []ResultValue) {
+	for _, option := range options {
+		value, known := currentValues[option.key()]
+		if !known {
+			continue
+		}
+		values = append(values, ResultValue{Name: option.Name, Target: option.daemonType(), Sections: option.configSections(), Value: value})
+	}
+	return values
+}
+
+func writeResults(results Results) {
+	content, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		log.WithError(err).Error("Cannot serialize results")
+		return
+	}
+	if err := os.WriteFile(resultsFile, content, 0644); err != nil {
+		log.WithError(err).WithField("file", resultsFile).Error("Cannot write results")
+		return
+	}
+	log.WithField("file", resultsFile).Info("Wrote results")
+}
+
+// latestBest is the best config found so far, saved whenever it changes
+type latestBest struct {
+	RunID         string        `yaml:"runId"`
+	Updated       time.Time     `yaml:"updated"`
+	Trial         int           `yaml:"trial"` // 0 for the baseline
+	Score         float64       `yaml:"score"`
+	BaselineScore float64       `yaml:"baselineScore"`
+	Config        []ResultValue `yaml:"config"`
+}
+
+// writeLatestBest saves the best config so far, so it survives a run that dies
+func writeLatestBest(score, baselineScore float64, values []ResultValue) {
+	if latestBestFile == "" {
+		return
+	}
+	content, err := yaml.Marshal(latestBest{RunID: runID, Updated: time.Now(), Trial: len(trials), Score: score, BaselineScore: baselineScore, Config: values})
+	if err != nil {
+		log.WithError(err).Error("Cannot serialize best config")
+		return
+	}
+	// Replace the file in one step so it never holds a partial config
+	if err := os.WriteFile(latestBestFile+".tmp", content, 0644); err != nil {
+		log.WithError(err).WithField("file", latestBestFile).Error("Cannot write best config")
+		return
+	}
+	if err := os.Rename(latestBestFile+".tmp", latestBestFile); err != nil {
+		log.WithError(err).WithField("file", latestBestFile).Error("Cannot write best config")
+	}
+}
+
+// writeScoreChart saves the score timeline of the run as SVG
+func writeScoreChart(results Results) {
+	if scoreChartFile == "" {
+		return
+	}
+	if err := os.WriteFile(scoreChartFile, []byte(scoreChart(results)), 0644); err != nil {
+		log.WithError(err).WithField("file", scoreChartFile).Error("Cannot write score chart")
+		return
+	}
+	log.WithField("file", scoreChartFile).Info("Wrote score chart")
+}
+
+func readResults(file string) (results Results, err error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return results, err
+	}
+	err = json.Unmarshal(content, &results)
+	return results, err
+}
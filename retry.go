@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errTransient fails the trial of a command that kept failing with errors
+// that usually go away on their own, like a mon election
+var errTransient = fmt.Errorf("%w: transient command failure", errTrialFailed)
+
+// Exit codes of the Ceph CLIs (negated errno values) that indicate a
+// cluster that is temporarily unreachable rather than a wrong command
+var transientExitCodes = map[int]string{
+	int(syscall.EINTR):        "EINTR",
+	int(syscall.EAGAIN):       "EAGAIN",
+	int(syscall.ENOTCONN):     "ENOTCONN",
+	int(syscall.ETIMEDOUT):    "ETIMEDOUT",
+	int(syscall.ECONNREFUSED): "ECONNREFUSED",
+	int(syscall.EHOSTUNREACH): "EHOSTUNREACH",
+}
+
+// Messages the Ceph CLIs print while no monitor can be reached
+var transientMessages = []string{
+	"monclient(hunting)",
+	"authenticate timed out",
+	"rados timed out",
+	"connection timed out",
+	"connection refused",
+	"resource temporarily unavailable",
+	"no route to host",
+}
+
+type retryKey struct{}
+
+// withRetries marks the command run with ctx as safe to repeat
+func withRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryKey{}, true)
+}
+
+// idempotentCephCommand reports whether the ceph CLI call only reads state
+// or sets it to a fixed value, so running it again after a transient
+// failure does no harm. These are the commands that can be mon commands.
+func idempotentCephCommand(arguments []string) bool {
+	var words []string
+	for i := 0; i < len(arguments); i++ {
+		switch {
+		case arguments[i] == "-f" || arguments[i] == "--format":
+			i++
+		case !strings.HasPrefix(arguments[i], "-"):
+			words = append(words, arguments[i])
+		}
+	}
+	command := strings.Join(words, " ")
+	if command == "health" || command == "health detail" {
+		return true
+	}
+	for _, monCommand := range monCommands {
+		if command == monCommand.prefix || strings.HasPrefix(command, monCommand.prefix+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// transientFailure returns why err of a command looks like a momentary
+// cluster problem that is worth retrying, or "" if it does not
+func transientFailure(err error, output []byte) string {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return ""
+	}
+	if name, found := transientExitCodes[exitErr.ExitCode()]; found {
+		return name
+	}
+	message := strings.ToLower(string(exitErr.Stderr) + string(output))
+	for _, transient := range transientMessages {
+		if strings.Contains(message, transient) {
+			return transient
+		}
+	}
+	return ""
+}
+
+// retryBackoff returns how long to wait before the given retry (starting at 1)
+func retryBackoff(attempt int) time.Duration {
+	backoff := time.Duration(retryDelay) * time.Second << (attempt - 1)
+	if max := time.Minute; backoff > max || backoff <= 0 {
+		return max
+	}
+	return backoff
+}
+
+// runWithRetry runs cmd, created anew by newCmd for every attempt. If ctx
+// comes from withRetries, it repeats it with exponential backoff while it
+// fails with transient errors. After --command-retries retries the failure
+// is returned wrapped in errTransient. Benchmarks and other commands that
+// must not run twice are only run once.
+func runWithRetry(ctx context.Context, newCmd func() *exec.Cmd, run func(*exec.Cmd) ([]byte, error)) (output []byte, err error) {
+	retries := ctx.Value(retryKey{}) != nil
+	for attempt := 0; ; attempt++ {
+		cmd := newCmd()
+		output, err = run(cmd)
+		reason := transientFailure(err, output)
+		if !retries || reason == "" || ctx.Err() != nil || stopRequested() {
+			return output, err
+		}
+		if attempt >= commandRetries {
+			return output, fmt.Errorf("%w: %s failed %d times (%s): %v", errTransient, cmd.Path, attempt+1, reason, err)
+		}
+		backoff := retryBackoff(attempt + 1)
+		log.WithFields(log.Fields{"command": strings.Join(cmd.Args, " "), "reason": reason, "retry": attempt + 1, "backoff": backoff}).Warn("Command failed with a transient error - retrying")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return output, err
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var s3ObjectPayload, s3LargePayload []byte
+
+// runRGWBench runs benchScale S3 workers against radosgw for benchTime
+// seconds and reports the number of completed operations per second.
+// What counts as an operation depends on the selected workload profile,
+// for the metadata profile every PUT and every listed page is one operation.
+func runRGWBench() (result BenchResult, err error) {
+	client := newS3Client()
+	deadline := time.Now().Add(time.Duration(benchTime) * time.Second)
+	var ops, failures int64
+	timeline := make([]int64, benchTime)
+	start := time.Now()
+	var latencies []time.Duration
+	var latencyLock sync.Mutex
+	var wg sync.WaitGroup
+	for worker := 0; worker < benchScale; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; time.Now().Before(deadline) && benchmarkContext.Err() == nil; i++ {
+				opStart := time.Now()
+				if err := rgwOperation(client, worker, i); err != nil {
+					atomic.AddInt64(&failures, 1)
+					log.WithError(err).Debug("S3 operation failed")
+					continue
+				}
+				latency := time.Since(opStart)
+				if second := int(time.Since(start).Seconds()); second < benchTime {
+					atomic.AddInt64(&timeline[second], 1)
+				}
+				latencyLock.Lock()
+				latencies = append(latencies, latency)
+				latencyLock.Unlock()
+				atomic.AddInt64(&ops, 1)
+			}
+		}(worker)
+	}
+	wg.Wait()
+	if ops == 0 {
+		return result, fmt.Errorf("no S3 operation succeeded (%d failures)", failures)
+	}
+	if failures > 0 {
+		log.WithField("failures", failures).Warn("Some S3 operations failed during the benchmark")
+	}
+	result.IOPS = float64(ops) / float64(benchTime)
+	for _, count := range timeline {
+		result.Timeline = append(result.Timeline, float64(count))
+	}
+	setLatencyStats(&result, latencies)
+	return result, nil
+}
+
+// setLatencyStats calculates the latency fields of result from individual operation latencies
+func setLatencyStats(result *BenchResult, latencies []time.Duration) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum float64
+	for _, latency := range latencies {
+		sum += latency.Seconds()
+	}
+	result.AvgLatency = sum / float64(len(latencies))
+	var squares float64
+	for _, latency := range latencies {
+		squares += math.Pow(latency.Seconds()-result.AvgLatency, 2)
+	}
+	result.StddevLatency = math.Sqrt(squares / float64(len(latencies)))
+	result.MaxLatency = latencies[len(latencies)-1].Seconds()
+	result.P99Latency = latencies[(len(latencies)-1)*99/100].Seconds()
+}
+
+func rgwOperation(client *s3Client, worker, iteration int) error {
+	switch s3Workload {
+	case "get":
+		_, err := client.GetObject(s3Bucket, fmt.Sprintf("get-%d", worker))
+		return err
+	case "multipart":
+		return client.MultipartUpload(s3Bucket, fmt.Sprintf("multipart-%d-%d", worker, iteration), s3LargePayload, s3PartSize*1024*1024)
+	case "large-get":
+		_, err := client.GetObject(s3Bucket, fmt.Sprintf("large-%d", worker))
+		return err
+	case "metadata":
+		// Alternate between adding index entries and listing a page of the
+		// bucket index from a random position
+		if iteration%2 == 0 {
+			return client.PutObject(s3Bucket, fmt.Sprintf("meta-%d-%d", worker, iteration), nil)
+		}
+		_, _, err := client.ListObjects(s3Bucket, "", metadataKey(rand.Intn(s3MetadataObjects)), "")
+		return err
+	default:
+		return client.PutObject(s3Bucket, fmt.Sprintf("put-%d-%d", worker, iteration), s3ObjectPayload)
+	}
+}
+
+// setUpS3Bucket creates the benchmark bucket and uploads the objects
+// that the read profiles fetch during the benchmark
+func setUpS3Bucket() error {
+	client := newS3Client()
+	if err := client.CreateBucket(s3Bucket); err != nil {
+		return fmt.Errorf("cannot create S3 benchmark bucket %s: %w", s3Bucket, err)
+	}
+	s3ObjectPayload = make([]byte, benchObjectSize*1024)
+	r.Read(s3ObjectPayload)
+	if s3Workload == "multipart" || s3Workload == "large-get" {
+		s3LargePayload = make([]byte, s3LargeObjectSize*1024*1024)
+		r.Read(s3LargePayload)
+	}
+
+	if s3Workload == "metadata" {
+		if err := populateS3Bucket(client); err != nil {
+			return err
+		}
+	}
+
+	for worker := 0; worker < benchScale; worker++ {
+		var err error
+		switch s3Workload {
+		case "get":
+			err = client.PutObject(s3Bucket, fmt.Sprintf("get-%d", worker), s3ObjectPayload)
+		case "large-get":
+			err = client.MultipartUpload(s3Bucket, fmt.Sprintf("large-%d", worker), s3LargePayload, s3PartSize*1024*1024)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot upload S3 objects for the read benchmark: %w", err)
+		}
+	}
+	return nil
+}
+
+// populateS3Bucket fills the bucket index with s3MetadataObjects empty objects
+// so the metadata profile lists a realistically sized index
+func populateS3Bucket(client *s3Client) error {
+	log.WithField("objects", s3MetadataObjects).Info("Populating S3 benchmark bucket for the metadata profile")
+	var wg sync.WaitGroup
+	var failures int64
+	for worker := 0; worker < benchScale; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := worker; i < s3MetadataObjects; i += benchScale {
+				if err := client.PutObject(s3Bucket, metadataKey(i), nil); err != nil {
+					atomic.AddInt64(&failures, 1)
+					log.WithError(err).Debug("Cannot upload S3 metadata object")
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	if failures > 0 {
+		return fmt.Errorf("cannot populate S3 benchmark bucket: %d uploads failed", failures)
+	}
+	return nil
+}
+
+func metadataKey(i int) string {
+	return fmt.Sprintf("meta/%08d", i)
+}
+
+// emptyS3Bucket deletes all objects in the benchmark bucket that start with prefix
+func emptyS3Bucket(client *s3Client, prefix string) {
+	token := ""
+	for {
+		keys, next, err := client.ListObjects(s3Bucket, prefix, "", token)
+		if err != nil {
+			log.WithError(err).Error("Cannot list objects in S3 benchmark bucket")
+			return
+		}
+		for _, key := range keys {
+			if err := client.DeleteObject(s3Bucket, key); err != nil {
+				log.WithError(err).WithField("key", key).Error("Cannot delete S3 benchmark object")
+			}
+		}
+		if next == "" {
+			return
+		}
+		token = next
+	}
+}
+
+func removeS3Bucket() {
+	client := newS3Client()
+	emptyS3Bucket(client, "")
+	if err := client.DeleteBucket(s3Bucket); err != nil {
+		log.WithError(err).WithField("bucket", s3Bucket).Error("Cannot delete S3 benchmark bucket")
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Client is a minimal S3 client for path-style requests against radosgw,
+// signing every request with AWS signature version 4
+type s3Client struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Region    string
+	HTTP      *http.Client
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+func newS3Client() *s3Client {
+	return &s3Client{
+		Endpoint:  strings.TrimSuffix(s3Endpoint, "/"),
+		AccessKey: s3AccessKey,
+		SecretKey: s3SecretKey,
+		Region:    s3Region,
+		HTTP:      &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// do sends a signed request and returns the response body.
+// Any non-2xx status is returned as error.
+func (c *s3Client) do(method, bucket, key string, query url.Values, body []byte) (respBody []byte, header http.Header, err error) {
+	path := "/" + bucket
+	if key != "" {
+		path += "/" + key
+	}
+	rawQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+	target := c.Endpoint + path
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	c.sign(req, path, rawQuery)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return respBody, resp.Header, fmt.Errorf("%s %s returned %s", method, path, resp.Status)
+	}
+	return respBody, resp.Header, nil
+}
+
+func (c *s3Client) sign(req *http.Request, path, rawQuery string) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQueryString(rawQuery),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD",
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", day, c.Region)
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), day)
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQueryString makes sure that parameters without value
+// (like ?uploads) are signed as "uploads="
+func canonicalQueryString(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		if !strings.Contains(param, "=") {
+			params[i] = param + "="
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func (c *s3Client) CreateBucket(bucket string) error {
+	_, _, err := c.do(http.MethodPut, bucket, "", nil, nil)
+	return err
+}
+
+func (c *s3Client) DeleteBucket(bucket string) error {
+	_, _, err := c.do(http.MethodDelete, bucket, "", nil, nil)
+	return err
+}
+
+func (c *s3Client) PutObject(bucket, key string, body []byte) error {
+	_, _, err := c.do(http.MethodPut, bucket, key, nil, body)
+	return err
+}
+
+func (c *s3Client) GetObject(bucket, key string) (int, error) {
+	body, _, err := c.do(http.MethodGet, bucket, key, nil, nil)
+	return len(body), err
+}
+
+func (c *s3Client) DeleteObject(bucket, key string) error {
+	_, _, err := c.do(http.MethodDelete, bucket, key, nil, nil)
+	return err
+}
+
+// MultipartUpload uploads data in parts of partSize bytes
+func (c *s3Client) MultipartUpload(bucket, key string, data []byte, partSize int) error {
+	body, _, err := c.do(http.MethodPost, bucket, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	var initiated initiateMultipartUploadResult
+	if err := xml.Unmarshal(body, &initiated); err != nil {
+		return fmt.Errorf("could not parse multipart upload id: %w", err)
+	}
+
+	var complete completeMultipartUpload
+	for offset, partNumber := 0, 1; offset < len(data); offset, partNumber = offset+partSize, partNumber+1 {
+		end := offset + partSize
+		if end > len(data) {
+			end = len(data)
+		}
+		query := url.Values{"partNumber": {fmt.Sprint(partNumber)}, "uploadId": {initiated.UploadID}}
+		_, header, err := c.do(http.MethodPut, bucket, key, query, data[offset:end])
+		if err != nil {
+			c.do(http.MethodDelete, bucket, key, url.Values{"uploadId": {initiated.UploadID}}, nil)
+			return err
+		}
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: partNumber, ETag: header.Get("ETag")})
+	}
+
+	completeBody, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	_, _, err = c.do(http.MethodPost, bucket, key, url.Values{"uploadId": {initiated.UploadID}}, completeBody)
+	return err
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// ListObjects returns one page of keys (ListObjectsV2) and the continuation
+// token for the next page - an empty token means there are no more pages.
+// startAfter is only honored for the first page.
+func (c *s3Client) ListObjects(bucket, prefix, startAfter, continuationToken string) (keys []string, next string, err error) {
+	query := url.Values{"list-type": {"2"}}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if startAfter != "" {
+		query.Set("start-after", startAfter)
+	}
+	if continuationToken != "" {
+		query.Set("continuation-token", continuationToken)
+	}
+	body, _, err := c.do(http.MethodGet, bucket, "", query, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	var result listBucketResult
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return nil, "", fmt.Errorf("could not parse bucket listing: %w", err)
+	}
+	for _, object := range result.Contents {
+		keys = append(keys, object.Key)
+	}
+	if result.IsTruncated {
+		next = result.NextContinuationToken
+	}
+	return keys, next, nil
+}
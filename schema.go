@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Option types of the config file, an empty type is taken from Ceph
+var optionTypes = []string{"bool", "int", "float", "enum"}
+
+// parseOptions decodes a list of options and rejects unknown fields and
+// options that cannot be tuned. Errors name source and the line of the option.
+func parseOptions(content []byte, source string) (options []ConfigOption, err error) {
+	if err := yaml.UnmarshalStrict(content, &options); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		// yaml names the internal struct an unknown field was not found in
+		for i, message := range typeErr.Errors {
+			if end := strings.Index(message, " not found in type "); end >= 0 {
+				typeErr.Errors[i] = strings.Replace(message[:end], "field", "unknown field", 1)
+			}
+		}
+		return nil, fmt.Errorf("%s: %s", source, strings.Join(typeErr.Errors, "; "))
+	}
+	lines := optionLines(content)
+	var problems []string
+	for i, option := range options {
+		for _, problem := range option.schemaProblems() {
+			if i < len(lines) {
+				problem = fmt.Sprintf("line %d: %s", lines[i], problem)
+			}
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s: %s", source, strings.Join(problems, "; "))
+	}
+	return options, nil
+}
+
+// optionLines returns the line every item of the top level list starts on
+func optionLines(content []byte) (lines []int) {
+	indent := -1
+	for i, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if !strings.HasPrefix(trimmed, "- ") && trimmed != "-" {
+			continue
+		}
+		switch current := len(line) - len(trimmed); {
+		case indent == -1 || current < indent:
+			indent = current
+			lines = []int{i + 1}
+		case current == indent:
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// schemaProblems describes everything wrong with the option as written in the config file
+func (option ConfigOption) schemaProblems() (problems []string) {
+	name := option.Name
+	if name == "" {
+		name = "option"
+		problems = append(problems, "option without name")
+	}
+	if !validOptionType(option.Type) {
+		problems = append(problems, fmt.Sprintf("%s has unsupported type %q - use one of %s or leave it empty", name, option.Type, strings.Join(optionTypes, ",")))
+	}
+	switch {
+	case (option.minText == "") != (option.maxText == ""):
+		problems = append(problems, fmt.Sprintf("%s sets only one of min and max - set both or leave both out to take the range from Ceph", name))
+	case option.minText != "" && option.Min >= option.Max:
+		problems = append(problems, fmt.Sprintf("%s has min %s not below max %s", name, option.minText, option.maxText))
+	}
+	if option.HardMin != nil && option.HardMax != nil && *option.HardMin > *option.HardMax {
+		problems = append(problems, fmt.Sprintf("%s has hardMin %s above hardMax %s", name, option.hardMinText, option.hardMaxText))
+	}
+	if option.StartValue != "" {
+		if err := option.checkStartValue(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s has startValue %q: %v", name, option.StartValue, err))
+		}
+	}
+	for _, member := range option.Linked {
+		if member.Name == "" {
+			problems = append(problems, fmt.Sprintf("%s links an option without name", name))
+		}
+		if member.Type != "" && member.Type != "int" && member.Type != "float" {
+			problems = append(problems, fmt.Sprintf("%s links %s with unsupported type %q - use int, float or leave it empty", name, member.Name, member.Type))
+		}
+	}
+	return problems
+}
+
+func validOptionType(optionType string) bool {
+	if optionType == "" {
+		return true
+	}
+	for _, valid := range optionTypes {
+		if optionType == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStartValue returns an error if the start value does not fit the declared type
+func (option ConfigOption) checkStartValue() error {
+	switch option.Type {
+	case "bool":
+		if _, err := strconv.ParseBool(option.StartValue); err != nil {
+			return fmt.Errorf("not a bool")
+		}
+	case "int", "float":
+		if _, err := parseQuantity(option.StartValue, time.Second); err != nil {
+			return err
+		}
+	case "enum":
+		if len(option.Values) == 0 {
+			return nil
+		}
+		for _, value := range option.Values {
+			if value == option.StartValue {
+				return nil
+			}
+		}
+		return fmt.Errorf("not one of %s", strings.Join(option.Values, ","))
+	}
+	return nil
+}
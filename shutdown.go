@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The first SIGINT/SIGTERM asks the run to stop at the next safe point,
+// which is the start of the next trial or a command that the signal made
+// fail. A second signal stops the run right away.
+var stopSignal os.Signal
+var shuttingDown bool
+var stopLock, shutdownLock sync.Mutex
+
+// changeLock is held while the search changes the cluster and the undo
+// stack. The second signal takes it before shutting down, so a value is
+// never restored while it is being applied. It is never released then.
+var changeLock sync.Mutex
+
+// abortReason is the error that stopped the run, nil if a signal stopped it
+var abortReason error
+
+// shutdownInterrupted is shutdown, called through a variable because
+// shutdown itself runs commands and rolls back values
+var shutdownInterrupted func()
+
+func init() {
+	shutdownInterrupted = shutdown
+}
+
+// interimResults returns the results of the run so far, set once the search starts
+var interimResults func() Results
+
+func stopRequested() bool {
+	stopLock.Lock()
+	defer stopLock.Unlock()
+	return stopSignal != nil
+}
+
+// cleaningUp returns true once shutdown runs, its commands fail as usual
+func cleaningUp() bool {
+	stopLock.Lock()
+	defer stopLock.Unlock()
+	return shuttingDown
+}
+
+// handleSignals traps SIGINT and SIGTERM for a graceful shutdown
+func handleSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		stopLock.Lock()
+		stopSignal = sig
+		stopLock.Unlock()
+		log.WithField("signal", sig).Warn("Received signal - stopping after the running step, send it again to stop right away")
+		sig = <-signals
+		log.WithField("signal", sig).Warn("Received second signal - stopping now")
+		changeLock.Lock()
+		shutdown()
+	}()
+}
+
+// abortRun ends the run after an error the search cannot continue from,
+// cleaning up like after a signal instead of exiting right away
+func abortRun(err error) {
+	stopLock.Lock()
+	abortReason = err
+	stopLock.Unlock()
+	log.WithError(err).Error("Aborting run")
+	shutdownInterrupted()
+}
+
+// shutdown ends an interrupted run: it restores the config, removes the
+// benchmark pool, writes the results so far and exits with 128 + signal
+// number like a shell would report it. The checkpoint is kept for --resume.
+func shutdown() {
+	// Never unlocked - other goroutines reaching a safe point wait for the exit
+	shutdownLock.Lock()
+	stopLock.Lock()
+	sig := stopSignal
+	shuttingDown = true
+	stopLock.Unlock()
+	stopTUI()
+	log.Warn("Run interrupted - restoring config and cleaning up")
+	restoreSnapshot()
+	if interimResults != nil {
+		results := interimResults()
+		writeResults(results)
+		log.WithFields(log.Fields{"trials": len(results.Trials), "bestScore": results.BestScore}).Info("Saved results of the interrupted run")
+	}
+	removeCephPool()
+	clearDashboardBanner()
+	reason := fmt.Sprint(sig)
+	if abortReason != nil {
+		reason = abortReason.Error()
+	}
+	notify("interrupted", fmt.Sprintf("run stopped by %s after %d trials", reason, len(trials)), nil)
+	flushSpans()
+	releaseLocks()
+	log.WithField("runID", runID).Info("Continue the run with --resume <run ID>")
+	code := 1
+	if number, ok := sig.(syscall.Signal); ok {
+		code = 128 + int(number)
+	}
+	// Give the log hooks a moment to write the last entries
+	time.Sleep(100 * time.Millisecond)
+	os.Exit(code)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// configSnapshot holds the values of all tuned options from before the run
+type configSnapshot struct {
+	Taken   time.Time
+	Options []snapshotEntry
+}
+
+type snapshotEntry struct {
+	Option ConfigOption
+	Value  string
+}
+
+var snapshot configSnapshot
+var snapshotRestored bool
+
+// takeSnapshot records the current value of every option and writes them to
+// snapshotFile, so the cluster can be restored even if the process dies
+func takeSnapshot(options []ConfigOption) error {
+	snapshot = configSnapshot{Taken: time.Now()}
+	for _, option := range options {
+		existingPool := option.daemonType() == "pool" && !poolCreated
+		if !option.isConfigOption() && !existingPool {
+			// The benchmark pool and image are deleted at the end of the
+			// run, the others are restored separately. A pool that existed
+			// before the run is kept, so its properties are restored.
+			continue
+		}
+		option := option
+		snapshot.Options = append(snapshot.Options, snapshotEntry{Option: option, Value: getCurrentValueForOption(option)})
+		if !existingPool {
+			rememberFirstValue(&option)
+		}
+	}
+	content, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot serialize config snapshot: %w", err)
+	}
+	if err := os.WriteFile(snapshotFile, content, 0644); err != nil {
+		return fmt.Errorf("cannot write config snapshot %s: %w", snapshotFile, err)
+	}
+	log.WithField("file", snapshotFile).Info("Saved snapshot of the current config")
+	return nil
+}
+
+// restoreSnapshot sets every option back to its value from the snapshot.
+// It is safe to call it multiple times, only the first call restores.
+func restoreSnapshot() {
+	if snapshotRestored || snapshot.Taken.IsZero() {
+		return
+	}
+	snapshotRestored = true
+	log.Info("Restoring config from snapshot")
+	for _, entry := range snapshot.Options {
+		rollbackValue(&entry.Option, entry.Value)
+	}
+	restorePGAutoscaler()
+	restoreCrushMap()
+	restoreHostSettings()
+	restoreNumaAffinity()
+	restoreFrontends()
+}
+
+// restoreSnapshotOnExit makes sure the snapshot is restored when the
+// process exits through log.Fatal or is stopped with SIGINT/SIGTERM
+func restoreSnapshotOnExit() {
+	log.RegisterExitHandler(restoreSnapshot)
+	handleSignals()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// confirmScore benchmarks the best config confirmRuns more times to see
+// whether its score holds up or was a lucky run. It returns 0 if no
+// confirmation run succeeded.
+func confirmScore() float64 {
+	var sum float64
+	succeeded := 0
+	for run := 1; run <= confirmRuns; run++ {
+		score, err := getScore()
+		if err != nil {
+			if !errors.Is(err, errTrialFailed) {
+				log.WithError(err).Error("Cannot benchmark the best config")
+			} else {
+				log.WithError(err).WithField("run", run).Warn("Confirmation run of the best config failed")
+			}
+			continue
+		}
+		log.WithFields(log.Fields{"run": run, "score": score}).Debug("Confirmation run of the best config")
+		sum += score
+		succeeded++
+	}
+	if succeeded == 0 {
+		return 0
+	}
+	return sum / float64(succeeded)
+}
+
+// writeSummary writes the options the run changed and its scores as table
+func writeSummary(out io.Writer, results Results) {
+	start := map[string]string{}
+	for _, value := range results.BaselineConfig {
+		start[resultValueKey(value)] = value.Value
+	}
+	var rows [][4]string
+	unchanged := 0
+	for _, value := range results.BestConfig {
+		startValue := start[resultValueKey(value)]
+		if sameValue(value.Value, startValue) {
+			unchanged++
+			continue
+		}
+		name := value.Name
+		if value.Target != "" && value.Target != "config" {
+			name += " (" + value.Target + ")"
+		} else if len(value.Sections) > 0 {
+			name += " [" + strings.Join(value.Sections, ",") + "]"
+		}
+		rows = append(rows, [4]string{name, startValue, value.Value, changePercent(startValue, value.Value)})
+	}
+
+	width := [4]int{len("option"), len("start value"), len("best value"), len("change")}
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > width[i] {
+				width[i] = len(cell)
+			}
+		}
+	}
+	line := func(cells [4]string) {
+		fmt.Fprintf(out, "%-*s  %-*s  %-*s  %*s\n", width[0], cells[0], width[1], cells[1], width[2], cells[2], width[3], cells[3])
+	}
+	fmt.Fprintln(out)
+	if len(rows) > 0 {
+		line([4]string{"option", "start value", "best value", "change"})
+		line([4]string{strings.Repeat("-", width[0]), strings.Repeat("-", width[1]), strings.Repeat("-", width[2]), strings.Repeat("-", width[3])})
+		for _, row := range rows {
+			line(row)
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "%d options changed, %d kept their start value, %d trials in %s\n", len(rows), unchanged, len(results.Trials), results.Finished.Sub(results.Started).Round(time.Second))
+	fmt.Fprintf(out, "Baseline score:  %.2f\n", results.BaselineScore)
+	fmt.Fprintf(out, "Best score:      %.2f", results.BestScore)
+	if results.BaselineScore > 0 {
+		fmt.Fprintf(out, " (%+.1f%%)", (results.BestScore-results.BaselineScore)/results.BaselineScore*100)
+	}
+	fmt.Fprintln(out)
+	if results.ConfirmedScore > 0 {
+		fmt.Fprintf(out, "Confirmed score: %.2f", results.ConfirmedScore)
+		if results.BaselineScore > 0 {
+			fmt.Fprintf(out, " (%+.1f%%)", (results.ConfirmedScore-results.BaselineScore)/results.BaselineScore*100)
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+func resultValueKey(value ResultValue) string {
+	return value.Name + "|" + value.Target + "|" + strings.Join(value.Sections, ",")
+}
+
+// changePercent returns the relative change from start to best for numeric values
+func changePercent(start, best string) string {
+	from, err := parseQuantity(start, time.Second)
+	if err != nil || from == 0 {
+		return "-"
+	}
+	to, err := parseQuantity(best, time.Second)
+	if err != nil {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", (to-from)/from*100)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Daemon types options can be targeted at
+var supportedTargets = map[string]bool{"osd": true, "mds": true, "rgw": true, "mon": true, "mgr": true, "pool": true, "crush": true, "image": true, "client": true, "host": true, "numa": true, "frontend": true}
+
+// osdSpec splits OSD target specs (osd.12, host:node3, class:nvme) into
+// their kind and value - both are empty for plain daemon types
+func (option ConfigOption) osdSpec() (kind, value string) {
+	for _, prefix := range []string{"osd.", "host:", "class:"} {
+		if strings.HasPrefix(option.Target, prefix) {
+			return strings.TrimRight(prefix, ".:"), strings.TrimPrefix(option.Target, prefix)
+		}
+	}
+	return "", ""
+}
+
+// daemonType returns the type of daemons the option is applied to
+func (option ConfigOption) daemonType() string {
+	if option.Target == "" {
+		return "osd"
+	}
+	if kind, _ := option.osdSpec(); kind != "" {
+		return "osd"
+	}
+	return option.Target
+}
+
+// deviceClass returns the device class an OSD option is scoped to -
+// the option's own class takes precedence over --device-class
+func (option ConfigOption) deviceClass() string {
+	if option.daemonType() != "osd" {
+		return ""
+	}
+	if kind, value := option.osdSpec(); kind == "class" {
+		return value
+	}
+	if option.Class != "" {
+		return option.Class
+	}
+	return deviceClass
+}
+
+// configSections are the who-masks used for 'ceph config set/rm'. When
+// tuning is restricted to a subset of OSDs every OSD gets its own section.
+func (option ConfigOption) configSections() []string {
+	if !option.isConfigOption() {
+		return nil
+	}
+	if option.daemonType() == "rgw" {
+		return []string{"client.rgw"}
+	}
+	kind, value := option.osdSpec()
+	if option.daemonType() == "osd" && (scopedOSDs() != nil || kind == "osd" || (kind == "host" && option.deviceClass() != "")) {
+		var sections []string
+		for _, id := range option.osdIDs() {
+			sections = append(sections, fmt.Sprintf("osd.%d", id))
+		}
+		return sections
+	}
+	if kind == "host" {
+		return []string{"osd/host:" + value}
+	}
+	if class := option.deviceClass(); class != "" {
+		return []string{"osd/class:" + class}
+	}
+	return []string{option.daemonType()}
+}
+
+// osdIDs returns the OSDs the option is scoped to, nil means all OSDs.
+// A target spec of a single OSD or host replaces --osds and --hosts.
+func (option ConfigOption) osdIDs() []int {
+	if option.daemonType() != "osd" {
+		return nil
+	}
+	ids := scopedOSDs()
+	switch kind, value := option.osdSpec(); kind {
+	case "osd":
+		id, _ := strconv.Atoi(value)
+		ids = []int{id}
+	case "host":
+		ids = runtimeOSDs(getHostOSDs(value))
+	}
+	class := option.deviceClass()
+	if class == "" {
+		return ids
+	}
+	if ids == nil {
+		return runtimeOSDs(getClassOSDs(class))
+	}
+	inClass := map[int]bool{}
+	for _, id := range runtimeOSDs(getClassOSDs(class)) {
+		inClass[id] = true
+	}
+	scoped := []int{}
+	for _, id := range ids {
+		if inClass[id] {
+			scoped = append(scoped, id)
+		}
+	}
+	return scoped
+}
+
+// runtimeOSDs returns ids, or aborts the run if they could not be listed.
+// During the shutdown no OSDs are returned so the rest of the cleanup runs.
+func runtimeOSDs(ids []int, err error) []int {
+	if err == nil {
+		return ids
+	}
+	if cleaningUp() {
+		log.WithError(err).Error("Cannot list OSDs")
+		return []int{}
+	}
+	abortRun(err)
+	return nil
+}
+
+// canInject returns false for daemons that cannot be reached with
+// 'ceph tell' - their options are always written to the config database
+func (option ConfigOption) canInject() bool {
+	return option.daemonType() != "rgw" && option.daemonType() != "client"
+}
+
+// readTarget is the who-mask used to read the current value. OSDs are
+// read from the first OSD in scope since they are all tuned together.
+func (option ConfigOption) readTarget() string {
+	if option.daemonType() == "osd" {
+		if ids := option.osdIDs(); len(ids) > 0 {
+			return fmt.Sprintf("osd.%d", ids[0])
+		}
+		return "osd.0"
+	}
+	return option.configSections()[0]
+}
+
+// Targets that are not daemons and thus not stored in the config database
+var nonConfigTargets = map[string]bool{"pool": true, "crush": true, "image": true, "host": true, "numa": true, "frontend": true}
+
+// isConfigOption returns false for pool and image properties, CRUSH
+// tunables and host settings, which are not stored in the config database
+func (option ConfigOption) isConfigOption() bool {
+	return !nonConfigTargets[option.daemonType()]
+}
+
+// hasMetadata returns true if Ceph knows the option - groups and options
+// outside of the config database have no entry in 'ceph config help'
+func (option ConfigOption) hasMetadata() bool {
+	return !option.isGroup() && option.isConfigOption()
+}
+
+// tellTargets addresses all daemons in scope of the option for 'ceph tell'.
+// Only the active manager serves requests, so it is the only one told.
+func (option ConfigOption) tellTargets() []string {
+	if option.daemonType() == "mgr" {
+		return []string{"mgr"}
+	}
+	if ids := option.osdIDs(); ids != nil {
+		var targets []string
+		for _, id := range ids {
+			targets = append(targets, fmt.Sprintf("osd.%d", id))
+		}
+		return targets
+	}
+	return []string{option.daemonType() + ".*"}
+}
+
+// key identifies the option together with its target
+func (option ConfigOption) key() string {
+	spec := option.Target
+	if spec == "" {
+		spec = "osd"
+	}
+	return spec + "/" + option.deviceClass() + "/" + option.Name
+}
+
+var subsetOSDs []int
+var subsetResolved bool
+
+// scopedOSDs resolves --osds and --hosts to the OSDs all changes are
+// restricted to, nil means the whole cluster is in scope
+func scopedOSDs() []int {
+	if subsetResolved {
+		return subsetOSDs
+	}
+	subsetResolved = true
+	if osdList != "" {
+		subsetOSDs = []int{}
+		for _, id := range strings.Split(osdList, ",") {
+			id = strings.TrimPrefix(strings.TrimSpace(id), "osd.")
+			number, err := strconv.Atoi(id)
+			if err != nil {
+				log.WithError(err).WithField("osds", osdList).Fatal("Cannot parse OSD list")
+			}
+			subsetOSDs = append(subsetOSDs, number)
+		}
+	}
+	if hostList != "" {
+		if subsetOSDs == nil {
+			subsetOSDs = []int{}
+		}
+		for _, host := range strings.Split(hostList, ",") {
+			ids, err := getHostOSDs(strings.TrimSpace(host))
+			if err != nil {
+				log.WithError(err).Fatal("Cannot restrict changes to the given hosts")
+			}
+			subsetOSDs = append(subsetOSDs, ids...)
+		}
+	}
+	if subsetOSDs != nil {
+		log.WithField("osds", subsetOSDs).Info("Restricting OSD changes to a subset of OSDs")
+	}
+	return subsetOSDs
+}
+
+var hostOSDs = map[string][]int{}
+
+// getHostOSDs returns the OSDs of the given host
+func getHostOSDs(host string) ([]int, error) {
+	if ids, known := hostOSDs[host]; known {
+		return ids, nil
+	}
+	ids := []int{}
+	if err := cephJSON(&ids, []string{"osd", "ls-tree", host}); err != nil {
+		return nil, fmt.Errorf("cannot list OSDs of host %s: %w", host, err)
+	}
+	hostOSDs[host] = ids
+	return ids, nil
+}
+
+var classOSDs = map[string][]int{}
+
+// getClassOSDs returns the OSDs with the given device class
+func getClassOSDs(class string) ([]int, error) {
+	if ids, known := classOSDs[class]; known {
+		return ids, nil
+	}
+	var ids []int
+	if err := cephJSON(&ids, []string{"osd", "crush", "class", "ls-osd", class}); err != nil {
+		return nil, fmt.Errorf("cannot list OSDs of device class %s: %w", class, err)
+	}
+	classOSDs[class] = ids
+	return ids, nil
+}
+
+// expandClasses turns every OSD option with several device classes into one
+// option per class, so the search finds a separate value for each class.
+// With --per-class this is done for every OSD option without a class.
+func expandClasses(options []ConfigOption) (expanded []ConfigOption) {
+	var clusterClasses []string
+	if perClass {
+		if err := cephJSON(&clusterClasses, []string{"osd", "crush", "class", "ls"}); err != nil {
+			log.WithError(err).Fatal("Cannot list the device classes of the cluster")
+		}
+	}
+	for _, option := range options {
+		classes := option.Classes
+		if len(classes) == 0 && perClass && (option.Target == "" || option.Target == "osd") && option.Class == "" && deviceClass == "" {
+			classes = clusterClasses
+		}
+		if len(classes) == 0 {
+			expanded = append(expanded, option)
+			continue
+		}
+		if kind, _ := option.osdSpec(); option.daemonType() != "osd" || option.Class != "" || kind == "class" {
+			log.WithField("option", option.Name).Fatal("classes can only be used for OSD options without class")
+		}
+		for _, class := range classes {
+			perClassOption := option
+			perClassOption.Class = class
+			perClassOption.Classes = nil
+			expanded = append(expanded, perClassOption)
+		}
+	}
+	return expanded
+}
+
+func validateTargets(options []ConfigOption) {
+	for _, option := range options {
+		if !supportedTargets[option.daemonType()] {
+			log.WithFields(log.Fields{"option": option.Name, "target": option.Target}).Fatal("Unsupported option target")
+		}
+		switch kind, value := option.osdSpec(); {
+		case kind == "osd":
+			if _, err := strconv.Atoi(value); err != nil {
+				log.WithFields(log.Fields{"option": option.Name, "target": option.Target}).Fatal("Cannot parse OSD id of option target")
+			}
+		case kind == "class" && option.Class != "":
+			log.WithFields(log.Fields{"option": option.Name, "target": option.Target}).Fatal("Option has a class target and a class")
+		case kind != "" && value == "":
+			log.WithFields(log.Fields{"option": option.Name, "target": option.Target}).Fatal("Option target is incomplete")
+		}
+		if ids := option.osdIDs(); ids != nil && len(ids) == 0 {
+			log.WithFields(log.Fields{"option": option.Name, "class": option.deviceClass()}).Fatal("No OSDs in scope of this option")
+		}
+		if option.Class != "" && option.daemonType() != "osd" {
+			log.WithField("option", option.Name).Fatal("Device classes can only be used for OSD options")
+		}
+		if option.daemonType() == "rgw" && benchBackend != "rgw" {
+			log.WithField("option", option.Name).Warn("Tuning an RGW option without the rgw benchmark backend - the benchmark will not exercise radosgw")
+		}
+		if option.daemonType() == "pool" && benchBackend == "rgw" {
+			log.WithField("property", option.Name).Warn("Pool properties are tuned on the benchmark pool, which the rgw benchmark backend does not use")
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Topology is the cluster layout a run was tuned on
+type Topology struct {
+	OSDTree json.RawMessage `json:"osdTree,omitempty"` // ceph osd tree
+	Pools   json.RawMessage `json:"pools,omitempty"`   // ceph osd pool ls detail
+	Crush   crushSummary    `json:"crush"`
+}
+
+// crushSummary condenses 'ceph osd crush dump' to what describes the layout
+type crushSummary struct {
+	Buckets       map[string]int  `json:"buckets"`       // number of buckets per type
+	DeviceClasses map[string]int  `json:"deviceClasses"` // number of OSDs per device class
+	Rules         json.RawMessage `json:"rules,omitempty"`
+	Tunables      json.RawMessage `json:"tunables,omitempty"`
+}
+
+// Subset of 'ceph osd crush dump -f json'
+type crushDump struct {
+	Devices []struct {
+		Class string `json:"class"`
+	} `json:"devices"`
+	Buckets []struct {
+		TypeName string `json:"type_name"`
+	} `json:"buckets"`
+	Rules    json.RawMessage `json:"rules"`
+	Tunables json.RawMessage `json:"tunables"`
+}
+
+// clusterTopology records OSD tree, pools and CRUSH map of the cluster.
+// Parts that cannot be read are left empty.
+func clusterTopology() (topology Topology) {
+	if err := cephJSON(&topology.OSDTree, []string{"osd", "tree"}); err != nil {
+		log.WithError(err).Warn("Cannot record OSD tree")
+	}
+	if err := cephJSON(&topology.Pools, []string{"osd", "pool", "ls", "detail"}); err != nil {
+		log.WithError(err).Warn("Cannot record pool definitions")
+	}
+	var dump crushDump
+	if err := cephJSON(&dump, []string{"osd", "crush", "dump"}); err != nil {
+		log.WithError(err).Warn("Cannot record CRUSH map")
+		return topology
+	}
+	topology.Crush = crushSummary{Buckets: map[string]int{}, DeviceClasses: map[string]int{}, Rules: dump.Rules, Tunables: dump.Tunables}
+	for _, bucket := range dump.Buckets {
+		topology.Crush.Buckets[bucket.TypeName]++
+	}
+	for _, device := range dump.Devices {
+		topology.Crush.DeviceClasses[device.Class]++
+	}
+	return topology
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Spans of trials, their phases and every executed command are exported as
+// OTLP/HTTP JSON. The tuning loop runs on one goroutine, so the open spans
+// form a stack and new spans are children of the innermost open one.
+
+type span struct {
+	traceID, spanID, parentID string
+	name                      string
+	start, finish             time.Time
+	attributes                map[string]string
+	err                       string
+}
+
+var tracer struct {
+	sync.Mutex
+	open     []*span
+	finished []*span
+}
+
+func randomID(bytes int) string {
+	id := make([]byte, bytes)
+	r.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// newSpan creates a span as child of the innermost open span. tracer must be locked.
+func newSpan(name string, attributes []string) *span {
+	s := &span{spanID: randomID(8), name: name, start: time.Now(), attributes: map[string]string{}}
+	if len(tracer.open) > 0 {
+		parent := tracer.open[len(tracer.open)-1]
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		s.traceID = randomID(16)
+	}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		s.attributes[attributes[i]] = attributes[i+1]
+	}
+	return s
+}
+
+// startSpan opens a span for a phase of the tuning loop. attributes are
+// key value pairs. It returns nil if tracing is disabled, end works on nil.
+func startSpan(name string, attributes ...string) *span {
+	if otlpEndpoint == "" {
+		return nil
+	}
+	tracer.Lock()
+	defer tracer.Unlock()
+	s := newSpan(name, attributes)
+	tracer.open = append(tracer.open, s)
+	return s
+}
+
+// end closes the span and exports the trace once its root span ended
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	tracer.Lock()
+	s.finish = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	for i := len(tracer.open) - 1; i >= 0; i-- {
+		if tracer.open[i] == s {
+			tracer.open = append(tracer.open[:i], tracer.open[i+1:]...)
+			break
+		}
+	}
+	tracer.finished = append(tracer.finished, s)
+	root := len(tracer.open) == 0
+	tracer.Unlock()
+	if root {
+		flushSpans()
+	}
+}
+
+// recordSpan records a finished span, like an executed command, that can
+// run on any goroutine and never has children
+func recordSpan(name string, start time.Time, err error, attributes ...string) {
+	if otlpEndpoint == "" {
+		return
+	}
+	tracer.Lock()
+	defer tracer.Unlock()
+	s := newSpan(name, attributes)
+	s.start, s.finish = start, time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	tracer.finished = append(tracer.finished, s)
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+func otlpAttributes(attributes map[string]string) (list []otlpAttribute) {
+	for key, value := range attributes {
+		attribute := otlpAttribute{Key: key}
+		attribute.Value.StringValue = value
+		list = append(list, attribute)
+	}
+	return list
+}
+
+// flushSpans exports all finished spans to the OTLP endpoint
+func flushSpans() {
+	if otlpEndpoint == "" {
+		return
+	}
+	tracer.Lock()
+	finished := tracer.finished
+	tracer.finished = nil
+	tracer.Unlock()
+	if len(finished) == 0 {
+		return
+	}
+
+	var spans []otlpSpan
+	for _, s := range finished {
+		exported := otlpSpan{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: fmt.Sprint(s.start.UnixNano()),
+			EndTimeUnixNano:   fmt.Sprint(s.finish.UnixNano()),
+			Attributes:        otlpAttributes(s.attributes),
+		}
+		if s.err != "" {
+			exported.Status.Code, exported.Status.Message = 2, s.err // STATUS_CODE_ERROR
+		}
+		spans = append(spans, exported)
+	}
+	request := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource":   map[string]interface{}{"attributes": otlpAttributes(map[string]string{"service.name": "ceph-optimize", "ceph_optimize.run_id": runID})},
+			"scopeSpans": []interface{}{map[string]interface{}{"scope": map[string]string{"name": "ceph-optimize"}, "spans": spans}},
+		}},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		log.WithError(err).Warn("Cannot serialize spans")
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Post(strings.TrimSuffix(otlpEndpoint, "/")+"/v1/traces", "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.WithError(err).WithField("endpoint", otlpEndpoint).Warn("Cannot export spans")
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		log.WithFields(log.Fields{"endpoint": otlpEndpoint, "status": response.Status}).Warn("OTLP endpoint rejected spans")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The terminal UI redraws a status view every second. Log lines that would
+// go to stdout are kept in a tail that is shown below the status.
+
+const tuiLogLines = 12
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// logTail keeps the last log lines for the terminal UI, or passes them
+// to stdout once the UI stopped
+type logTail struct {
+	sync.Mutex
+	lines  []string
+	direct bool
+}
+
+func (tail *logTail) Write(p []byte) (int, error) {
+	tail.Lock()
+	defer tail.Unlock()
+	if tail.direct {
+		return os.Stdout.Write(p)
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		tail.lines = append(tail.lines, line)
+	}
+	if len(tail.lines) > tuiLogLines {
+		tail.lines = tail.lines[len(tail.lines)-tuiLogLines:]
+	}
+	return len(p), nil
+}
+
+var tuiTail *logTail
+var tuiStop chan struct{}
+var tuiDone chan struct{}
+var tuiStarted time.Time
+
+// startTUI starts redrawing the status view and returns the writer for log lines
+func startTUI() io.Writer {
+	tuiTail = &logTail{}
+	tuiStarted = time.Now()
+	// Leave the last log lines readable when the run ends through log.Fatal
+	log.RegisterExitHandler(stopTUI)
+	redrawTUI()
+	return tuiTail
+}
+
+// redrawTUI starts redrawing the status view every second until tuiStop is closed
+func redrawTUI() {
+	tuiStop = make(chan struct{})
+	tuiDone = make(chan struct{})
+	go func() {
+		defer close(tuiDone)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			drawTUI()
+			select {
+			case <-tuiStop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// suspendTUI stops redrawing so the terminal can be used for a prompt and
+// returns the function that brings the status view back. Log lines are
+// still kept in the tail meanwhile.
+func suspendTUI() (resume func()) {
+	if tuiStop == nil {
+		return func() {}
+	}
+	close(tuiStop)
+	<-tuiDone
+	tuiStop = nil
+	fmt.Print("\x1b[H\x1b[2J")
+	return redrawTUI
+}
+
+// stopTUI stops redrawing and prints the last log lines once more so they
+// stay in the terminal. Later log lines go to stdout directly.
+func stopTUI() {
+	if tuiStop == nil {
+		return
+	}
+	close(tuiStop)
+	<-tuiDone
+	tuiStop = nil
+	tuiTail.Lock()
+	defer tuiTail.Unlock()
+	fmt.Println()
+	for _, line := range tuiTail.lines {
+		fmt.Println(line)
+	}
+	tuiTail.lines = nil
+	tuiTail.direct = true
+}
+
+func drawTUI() {
+	metricsLock.Lock()
+	state := metrics
+	state.RecentScores = append([]float64(nil), metrics.RecentScores...)
+	metricsLock.Unlock()
+
+	var screen strings.Builder
+	// Move the cursor home and clear the screen
+	screen.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&screen, "ceph-optimize run %s - elapsed %s\n\n", runID, time.Since(tuiStarted).Round(time.Second))
+	if state.Testing {
+		option := state.OptionUnderTest
+		fmt.Fprintf(&screen, "Testing      %s (%s) = %s\n", option.Name, option.daemonType(), state.ValueUnderTest)
+		if !state.BenchmarkStarted.IsZero() {
+			elapsed := time.Since(state.BenchmarkStarted).Seconds()
+			fmt.Fprintf(&screen, "Benchmark    %s %.0f/%ds\n", progressBar(elapsed/float64(benchTime), 30), math.Min(elapsed, float64(benchTime)), benchTime)
+		} else {
+			screen.WriteString("Benchmark    applying value\n")
+		}
+	} else {
+		screen.WriteString("Testing      -\n\n")
+	}
+	fmt.Fprintf(&screen, "Best score   %.2f (baseline %.2f", state.BestScore, state.BaselineScore)
+	if state.BaselineScore > 0 {
+		fmt.Fprintf(&screen, ", %+.1f%%", (state.BestScore-state.BaselineScore)/state.BaselineScore*100)
+	}
+	fmt.Fprintf(&screen, ")\nLast score   %.2f\n", state.LastScore)
+	fmt.Fprintf(&screen, "Trials       %d (%d failed)\n", state.Trials, state.Failures)
+	fmt.Fprintf(&screen, "Stall        %d/%d trials without improvement\n", state.NoNewBest, timeout)
+	fmt.Fprintf(&screen, "Scores       %s\n\n", sparkline(state.RecentScores))
+
+	tuiTail.Lock()
+	for _, line := range tuiTail.lines {
+		screen.WriteString(line + "\n")
+	}
+	tuiTail.Unlock()
+	os.Stdout.WriteString(screen.String())
+}
+
+// progressBar renders fraction (0..1) as bar of width characters
+func progressBar(fraction float64, width int) string {
+	filled := int(math.Min(fraction, 1) * float64(width))
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
+
+// sparkline renders values as one line of block characters scaled between their minimum and maximum
+func sparkline(values []float64) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	low, high := values[0], values[0]
+	for _, value := range values {
+		low, high = math.Min(low, value), math.Max(high, value)
+	}
+	var line strings.Builder
+	for _, value := range values {
+		level := 0
+		if high > low {
+			level = int((value - low) / (high - low) * float64(len(sparkBlocks)-1))
+		}
+		line.WriteRune(sparkBlocks[level])
+	}
+	return line.String()
+}
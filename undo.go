@@ -0,0 +1,45 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// undoEntry is a change made by setValue together with the value it replaced
+type undoEntry struct {
+	Option   ConfigOption
+	Previous string
+	Applied  string
+	Time     time.Time
+}
+
+// undoStack holds every change of the run in the order it was made and is
+// saved with the checkpoint. Rolling back replays it backwards.
+var undoStack []undoEntry
+
+// pushUndo records that option is about to be changed to value
+func pushUndo(option ConfigOption, value string) {
+	previous, known := currentValues[option.key()]
+	if !known {
+		previous = getCurrentValueForOption(option)
+	}
+	undoStack = append(undoStack, undoEntry{Option: option, Previous: previous, Applied: value, Time: time.Now()})
+}
+
+// undoMark returns the position of the stack to roll back to with rollbackTo
+func undoMark() int {
+	return len(undoStack)
+}
+
+// rollbackTo undoes all changes made since mark, the latest first
+func rollbackTo(mark int) {
+	changeLock.Lock()
+	defer changeLock.Unlock()
+	for len(undoStack) > mark {
+		entry := undoStack[len(undoStack)-1]
+		log.WithFields(log.Fields{"option": entry.Option.Name, "value": entry.Previous, "undone": entry.Applied}).Debug("Undoing change")
+		rollbackValue(&entry.Option, entry.Previous)
+		undoStack = undoStack[:len(undoStack)-1]
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Binary size suffixes as used by Ceph (4K = 4096 bytes)
+var sizeSuffixes = map[string]float64{
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+	"P": 1 << 50,
+}
+
+// parseQuantity parses plain numbers as well as sizes (4K, 512M, 2GiB) and
+// durations (500ms, 10s, 5m). Durations are returned in multiples of
+// durationUnit, so the same text works for secs and millisecs options.
+func parseQuantity(text string, durationUnit time.Duration) (float64, error) {
+	text = strings.TrimSpace(text)
+	if number, err := strconv.ParseFloat(text, 64); err == nil {
+		return number, nil
+	}
+	if strings.ToLower(text) == text {
+		if duration, err := time.ParseDuration(text); err == nil {
+			return float64(duration) / float64(durationUnit), nil
+		}
+	}
+	unit := strings.TrimSuffix(strings.TrimSuffix(text, "B"), "i")
+	if len(unit) > 1 {
+		if multiplier, found := sizeSuffixes[unit[len(unit)-1:]]; found {
+			number, err := strconv.ParseFloat(unit[:len(unit)-1], 64)
+			if err == nil {
+				return number * multiplier, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("cannot parse %q as number, size or duration", text)
+}
+
+// durationUnit returns the unit Ceph expects for durations of the option's type
+func durationUnit(cephType string) time.Duration {
+	if cephType == "millisecs" {
+		return time.Millisecond
+	}
+	return time.Second
+}
+
+// formatQuantity renders a number the way Ceph accepts it for every
+// release - without unit suffix and never in exponent notation
+func formatQuantity(number float64) string {
+	return strconv.FormatFloat(number, 'f', -1, 64)
+}
+
+// UnmarshalYAML allows min, max and the hard bounds to be written with size or duration suffixes
+func (option *ConfigOption) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plainOption ConfigOption
+	var raw struct {
+		plainOption `yaml:",inline"`
+		Min         string `yaml:"min"`
+		Max         string `yaml:"max"`
+		HardMin     string `yaml:"hardMin"`
+		HardMax     string `yaml:"hardMax"`
+	}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	*option = ConfigOption(raw.plainOption)
+	option.minText, option.maxText = raw.Min, raw.Max
+	option.hardMinText, option.hardMaxText = raw.HardMin, raw.HardMax
+	return option.convertUnits(time.Second)
+}
+
+// convertUnits sets Min and Max from their config file text
+func (option *ConfigOption) convertUnits(unit time.Duration) (err error) {
+	if option.minText != "" {
+		if option.Min, err = parseQuantity(option.minText, unit); err != nil {
+			return fmt.Errorf("min of %s: %w", option.Name, err)
+		}
+	}
+	if option.maxText != "" {
+		if option.Max, err = parseQuantity(option.maxText, unit); err != nil {
+			return fmt.Errorf("max of %s: %w", option.Name, err)
+		}
+	}
+	if option.hardMinText != "" {
+		hardMin, err := parseQuantity(option.hardMinText, unit)
+		if err != nil {
+			return fmt.Errorf("hardMin of %s: %w", option.Name, err)
+		}
+		option.HardMin = &hardMin
+	}
+	if option.hardMaxText != "" {
+		hardMax, err := parseQuantity(option.hardMaxText, unit)
+		if err != nil {
+			return fmt.Errorf("hardMax of %s: %w", option.Name, err)
+		}
+		option.HardMax = &hardMax
+	}
+	return nil
+}
+
+// convertMillisecondBounds re-reads min and max of millisecs options, whose
+// durations were converted to seconds while the config file was parsed
+func convertMillisecondBounds(options []ConfigOption) {
+	for i := range options {
+		if !options[i].hasMetadata() {
+			continue
+		}
+		help, err := getOptionHelp(options[i].Name)
+		if err != nil || help.Type != "millisecs" {
+			continue
+		}
+		if err := options[i].convertUnits(time.Millisecond); err != nil {
+			log.WithError(err).Fatal("Cannot convert option range to milliseconds")
+		}
+	}
+}
+
+// cephValue converts a value with size or duration suffix into the plain
+// number Ceph expects for the option. Other values are returned unchanged.
+func (option ConfigOption) cephValue(value string) string {
+	if option.Type == "bool" || option.Type == "enum" || value == "" {
+		return value
+	}
+	unit := time.Second
+	if option.hasMetadata() {
+		help, _ := getOptionHelp(option.Name)
+		unit = durationUnit(help.Type)
+	}
+	number, err := parseQuantity(value, unit)
+	if err != nil {
+		return value
+	}
+	return formatQuantity(number)
+}
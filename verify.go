@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// verifyValue reads the running value back from a random sample of the
+// OSDs in scope. injectargs does not complain about options it cannot change
+// at runtime, so without this a trial could score a change that never applied.
+func verifyValue(option ConfigOption, value string) (err error) {
+	span := startSpan("verify", "option", option.Name, "value", value)
+	defer func() { span.end(err) }()
+	if option.isGroup() {
+		members, values := option.memberValues(value)
+		for i := range members {
+			if err := verifyValue(members[i], values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if verifyOSDs <= 0 || option.daemonType() != "osd" {
+		return nil
+	}
+	ids := option.osdIDs()
+	if ids == nil {
+		dump, err := getOSDDump()
+		if err != nil {
+			log.WithError(err).Warn("Cannot list OSDs - skipping read-back verification")
+			return nil
+		}
+		for _, osd := range dump.OSDs {
+			if osd.Up == 1 {
+				ids = append(ids, osd.OSD)
+			}
+		}
+	}
+	sample := append([]int{}, ids...)
+	r.Shuffle(len(sample), func(i, j int) { sample[i], sample[j] = sample[j], sample[i] })
+	if len(sample) > verifyOSDs {
+		sample = sample[:verifyOSDs]
+	}
+
+	for _, id := range sample {
+		daemon := fmt.Sprintf("osd.%d", id)
+		var running map[string]interface{}
+		if err := cephJSON(&running, []string{"tell", daemon, "config", "get", option.Name}); err != nil {
+			return fmt.Errorf("%w: cannot read %s back from %s: %v", errTrialFailed, option.Name, daemon, err)
+		}
+		if actual := fmt.Sprint(running[option.Name]); !sameValue(actual, value) {
+			return fmt.Errorf("%w: %s runs with %s=%s instead of %s", errTrialFailed, daemon, option.Name, actual, value)
+		}
+	}
+	log.WithFields(log.Fields{"option": option.Name, "osds": sample}).Debug("Verified value on OSDs")
+	return nil
+}
+
+// sameValue compares values the way Ceph normalizes them - numbers may be
+// printed with different precision and bools as true/false or 1/0
+func sameValue(actual, expected string) bool {
+	if actual == expected {
+		return true
+	}
+	if a, err := strconv.ParseFloat(actual, 64); err == nil {
+		if e, err := strconv.ParseFloat(expected, 64); err == nil {
+			return math.Abs(a-e) <= 1e-6*math.Max(math.Abs(a), math.Abs(e))
+		}
+	}
+	if a, err := strconv.ParseBool(actual); err == nil {
+		if e, err := strconv.ParseBool(expected); err == nil {
+			return a == e
+		}
+	}
+	return false
+}